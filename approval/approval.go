@@ -0,0 +1,116 @@
+package approval
+
+// Package approval asks the local user of the host whether a browser may
+// connect before the peer answers its offer.
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrRejected is wrapped by every refusal, including an unanswered prompt.
+var ErrRejected = errors.New("connection rejected by the host")
+
+// Request describes who wants to connect.
+type Request struct {
+	Addr      string // address the server saw the browser connect from
+	Name      string // name the browser gave, may be empty
+	UserAgent string
+	Session   string
+}
+
+// String is the requester line shown in prompts.
+func (r Request) String() string {
+	who := r.Addr
+	if who == "" {
+		who = "unknown address"
+	}
+	if r.Name != "" {
+		who = fmt.Sprintf("%q from %s", r.Name, who)
+	}
+	if r.UserAgent != "" {
+		who += " (" + r.UserAgent + ")"
+	}
+	return who
+}
+
+// Approver decides on a connection request. It returns nil to accept and
+// an error wrapping ErrRejected to refuse; it must give up when ctx ends.
+type Approver interface {
+	Approve(ctx context.Context, r Request) error
+}
+
+// Func adapts a function to an Approver.
+type Func func(ctx context.Context, r Request) error
+
+func (f Func) Approve(ctx context.Context, r Request) error { return f(ctx, r) }
+
+// New returns the approver selected by name: "" or "off" (nil, every
+// offer is answered), "console" (a y/N question on stdin/stdout) or
+// "desktop" (a message box on the host's desktop, Windows only).
+func New(name string, in io.Reader, out io.Writer) (Approver, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "off", "none":
+		return nil, nil
+	case "console":
+		return NewConsole(in, out), nil
+	case "desktop":
+		return newDesktop()
+	default:
+		return nil, fmt.Errorf("unknown approval prompt %q (want off, console or desktop)", name)
+	}
+}
+
+// errTimeout is the refusal for a prompt nobody answered in time.
+var errTimeout = fmt.Errorf("%w: no answer in time", ErrRejected)
+
+// Console asks on a terminal. Lines typed while no question is open are
+// ignored.
+type Console struct {
+	out io.Writer
+
+	mu    sync.Mutex // one question at a time
+	lines chan string
+}
+
+// NewConsole reads answers from in and writes questions to out.
+func NewConsole(in io.Reader, out io.Writer) *Console {
+	c := &Console{out: out, lines: make(chan string)}
+	go func() {
+		sc := bufio.NewScanner(in)
+		for sc.Scan() {
+			select {
+			case c.lines <- sc.Text():
+			default:
+				// Nobody is asking
+			}
+		}
+		close(c.lines)
+	}()
+	return c
+}
+
+func (c *Console) Approve(ctx context.Context, r Request) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.out, "Connection request from %s. Allow? [y/N] ", r)
+	select {
+	case line, ok := <-c.lines:
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return nil
+		}
+		if !ok {
+			return fmt.Errorf("%w: no console input", ErrRejected)
+		}
+		return ErrRejected
+	case <-ctx.Done():
+		fmt.Fprintln(c.out, "\nno answer; rejected")
+		return errTimeout
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package approval
+
+import "fmt"
+
+// The desktop prompt is only implemented on Windows, where the peer runs;
+// elsewhere use the console prompt.
+func newDesktop() (Approver, error) {
+	return nil, fmt.Errorf("desktop approval prompt is only supported on Windows; use console")
+}
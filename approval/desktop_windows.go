@@ -0,0 +1,62 @@
+//go:build windows
+
+package approval
+
+import (
+	"context"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	user32                = syscall.NewLazyDLL("user32.dll")
+	procMessageBoxTimeout = user32.NewProc("MessageBoxTimeoutW")
+)
+
+// Win32 constants
+const (
+	MB_YESNO         = 0x00000004
+	MB_ICONQUESTION  = 0x00000020
+	MB_DEFBUTTON2    = 0x00000100
+	MB_SYSTEMMODAL   = 0x00001000
+	MB_SETFOREGROUND = 0x00010000
+	MB_TOPMOST       = 0x00040000
+
+	IDYES        = 6
+	MB_TIMEDOUT  = 32000
+	infiniteWait = 0xFFFFFFFF
+)
+
+// desktop shows a topmost Yes/No message box that closes itself when the
+// request times out. No is the default button, so a stray Enter rejects.
+type desktop struct{}
+
+func newDesktop() (Approver, error) {
+	if err := procMessageBoxTimeout.Find(); err != nil {
+		return nil, err
+	}
+	return desktop{}, nil
+}
+
+func (desktop) Approve(ctx context.Context, r Request) error {
+	wait := uint32(infiniteWait)
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = uint32(max(time.Until(deadline).Milliseconds(), 1))
+	}
+	text, _ := syscall.UTF16PtrFromString("Allow a remote connection to this desktop?\n\nFrom " + r.String())
+	caption, _ := syscall.UTF16PtrFromString("Remote desktop request")
+	ret, _, _ := procMessageBoxTimeout.Call(0,
+		uintptr(unsafe.Pointer(text)),
+		uintptr(unsafe.Pointer(caption)),
+		MB_YESNO|MB_ICONQUESTION|MB_DEFBUTTON2|MB_SYSTEMMODAL|MB_SETFOREGROUND|MB_TOPMOST,
+		0, uintptr(wait))
+	switch ret {
+	case IDYES:
+		return nil
+	case MB_TIMEDOUT:
+		return errTimeout
+	default:
+		return ErrRejected
+	}
+}
@@ -0,0 +1,273 @@
+package audit
+
+// Package audit writes a structured log of who connected, when, from where
+// and what they did, one JSON Event per line. The server and the peer each
+// keep their own files:
+//
+//	audit-server-20261018-115630.123.jsonl
+//	audit-peer-20261018-115630.123.jsonl
+//
+// A file is replaced once it reaches Config.FileBytes or Config.FileDuration;
+// old files are deleted after Config.MaxAge.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"weblinuxgui/config/setting"
+)
+
+// Event kinds.
+const (
+	KindOffer        = "offer"         // server: a browser sent an offer
+	KindAnswer       = "answer"        // server: the peer answered it
+	KindRejected     = "rejected"      // the offer was refused; Detail says why
+	KindSessionStart = "session_start" // peer: a new session was answered
+	KindSessionEnd   = "session_end"   // peer: Detail is the duration
+	KindICERestart   = "ice_restart"
+	KindPaired       = "paired" // peer: a browser paired with a code
+	KindPaste        = "paste"  // clipboard text typed into the host
+	KindKey          = "key"    // a key press (Config.Keys)
+)
+
+// Keystroke logging modes.
+const (
+	KeysOff    = "off"    // no key events
+	KeysRedact = "redact" // named keys (Enter, Control, ...) as is, characters as "*"
+	KeysFull   = "full"   // every key, and the text of pastes
+)
+
+// Event is one line of the audit log.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"` // "server" or "peer"
+	Kind      string    `json:"event"`
+	Session   string    `json:"session,omitempty"`
+	Addr      string    `json:"addr,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	// Pastes: the length always, the text only with KeysFull
+	Length int    `json:"length,omitempty"`
+	Text   string `json:"text,omitempty"`
+}
+
+// Config controls the audit log.
+type Config struct {
+	Dir string
+	// FileBytes and FileDuration start a new file once the current one is
+	// this large or old (0 = no limit).
+	FileBytes    int64
+	FileDuration time.Duration
+	// MaxAge deletes files older than this (0 = keep forever).
+	MaxAge time.Duration
+	// Keys is KeysOff, KeysRedact or KeysFull.
+	Keys string
+}
+
+// Default returns the configuration with the default rotation; auditing
+// stays off until Dir is set.
+func Default() Config {
+	return Config{FileBytes: 10 << 20, FileDuration: 24 * time.Hour, Keys: KeysOff}
+}
+
+// Enabled reports whether Dir is set.
+func (c Config) Enabled() bool { return c.Dir != "" }
+
+// Settings lists the audit settings, bound to c.
+func (c *Config) Settings() []*setting.Setting {
+	return []*setting.Setting{
+		{Env: "AUDIT_DIR", Usage: "directory for audit files; enables auditing", Value: setting.String(&c.Dir)},
+		{Env: "AUDIT_FILE_MB", Usage: "start a new audit file after this many MB; 0 for no limit", Value: setting.MB(&c.FileBytes)},
+		{Env: "AUDIT_FILE_DURATION", Usage: "start a new audit file after this long; 0 for no limit", Value: setting.Duration(&c.FileDuration, 0)},
+		{Env: "AUDIT_RETENTION", Usage: "delete audit files older than this; 0 keeps them", Value: setting.Duration(&c.MaxAge, 0)},
+		KeysSetting(&c.Keys),
+	}
+}
+
+// KeysSetting is AUDIT_KEYS, which also applies to the input in session
+// recordings.
+func KeysSetting(p *string) *setting.Setting {
+	return &setting.Setting{Env: "AUDIT_KEYS", Usage: "keystroke logging in the audit log and recordings", Value: setting.Choice(p, KeysOff, KeysRedact, KeysFull)}
+}
+
+// FromEnv reads the audit configuration from the environment alone. It
+// returns ok=false when AUDIT_DIR is not set.
+func FromEnv() (cfg Config, ok bool, err error) {
+	cfg = Default()
+	err = setting.FromEnv(cfg.Settings()...)
+	return cfg, cfg.Enabled(), err
+}
+
+// KeysFromEnv reads AUDIT_KEYS from the environment alone. It defaults to
+// KeysOff.
+func KeysFromEnv() (string, error) {
+	keys := KeysOff
+	err := setting.FromEnv(KeysSetting(&keys))
+	return keys, err
+}
+
+// RedactKey returns key as KeysRedact shows it: named keys as is, a
+// character as "*".
+func RedactKey(key string) string {
+	if utf8.RuneCountInString(key) == 1 {
+		return "*"
+	}
+	return key
+}
+
+const nameLayout = "20060102-150405.000"
+
+// Logger appends events to the current audit file. It is safe for
+// concurrent use, and a nil Logger discards events.
+type Logger struct {
+	cfg    Config
+	source string
+
+	mu      sync.Mutex
+	f       *os.File
+	started time.Time
+	size    int64
+}
+
+// New creates the directory and applies retention once. source tags every
+// event and the file names.
+func New(cfg Config, source string) (*Logger, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("audit dir: %w", err)
+	}
+	if cfg.Keys == "" {
+		cfg.Keys = KeysOff
+	}
+	l := &Logger{cfg: cfg, source: source}
+	l.prune()
+	return l, nil
+}
+
+// Log writes e, stamped with the time and source.
+func (l *Logger) Log(e Event) {
+	if l == nil {
+		return
+	}
+	e.Time, e.Source = time.Now(), l.source
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.rotateLocked(int64(len(b))); err != nil {
+		slog.Error("audit", "err", err)
+		return
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	if err != nil {
+		slog.Error("audit", "err", err)
+	}
+}
+
+// Key logs a key press according to Config.Keys.
+func (l *Logger) Key(session, key string) {
+	if l == nil || l.cfg.Keys == KeysOff {
+		return
+	}
+	if l.cfg.Keys == KeysRedact {
+		key = RedactKey(key)
+	}
+	l.Log(Event{Kind: KindKey, Session: session, Key: key})
+}
+
+// Paste logs clipboard text sent to the host; the text itself only with
+// KeysFull.
+func (l *Logger) Paste(session, text string) {
+	if l == nil {
+		return
+	}
+	e := Event{Kind: KindPaste, Session: session, Length: utf8.RuneCountInString(text)}
+	if l.cfg.Keys == KeysFull {
+		e.Text = text
+	}
+	l.Log(e)
+}
+
+// Close closes the current file.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closeLocked()
+}
+
+// rotateLocked makes sure a file is open with room for n more bytes.
+func (l *Logger) rotateLocked(n int64) error {
+	if l.f != nil {
+		full := l.cfg.FileBytes > 0 && l.size > 0 && l.size+n > l.cfg.FileBytes
+		old := l.cfg.FileDuration > 0 && time.Since(l.started) >= l.cfg.FileDuration
+		if !full && !old {
+			return nil
+		}
+		l.closeLocked()
+	}
+	now := time.Now()
+	name := filepath.Join(l.cfg.Dir, "audit-"+l.source+"-"+now.Format(nameLayout)+".jsonl")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	l.f, l.started, l.size = f, now, 0
+	return nil
+}
+
+func (l *Logger) closeLocked() error {
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	go l.prune()
+	return err
+}
+
+// prune deletes this source's files older than MaxAge.
+func (l *Logger) prune() {
+	if l.cfg.MaxAge <= 0 {
+		return
+	}
+	prefix := "audit-" + l.source + "-"
+	entries, err := os.ReadDir(l.cfg.Dir)
+	if err != nil {
+		slog.Warn("audit retention", "err", err)
+		return
+	}
+	l.mu.Lock()
+	var current string
+	if l.f != nil {
+		current = filepath.Base(l.f.Name())
+	}
+	l.mu.Unlock()
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".jsonl") || name == current {
+			continue
+		}
+		t, err := time.ParseInLocation(nameLayout, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".jsonl"), time.Local)
+		if err != nil || time.Since(t) <= l.cfg.MaxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(l.cfg.Dir, name)); err != nil {
+			slog.Warn("audit retention", "err", err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package audit_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"weblinuxgui/audit"
+)
+
+// files returns the names of dir's files, oldest first.
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// events reads all events in dir, oldest file first.
+func events(t *testing.T, dir string) []audit.Event {
+	t.Helper()
+	var all []audit.Event
+	for _, name := range files(t, dir) {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var e audit.Event
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			all = append(all, e)
+		}
+		f.Close()
+	}
+	return all
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	l, err := audit.New(audit.Config{Dir: dir, FileBytes: 200}, "peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		l.Log(audit.Event{Kind: audit.KindSessionStart, Session: strings.Repeat("s", 50)})
+		if i < 4 {
+			// File names have millisecond resolution
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names := files(t, dir)
+	if len(names) < 2 {
+		t.Fatalf("files %v, want a new one past 200 bytes", names)
+	}
+	for _, n := range names {
+		if !strings.HasPrefix(n, "audit-peer-") || !strings.HasSuffix(n, ".jsonl") {
+			t.Errorf("file name %s", n)
+		}
+		st, err := os.Stat(filepath.Join(dir, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Size() > 200 {
+			t.Errorf("%s has %d bytes, limit 200", n, st.Size())
+		}
+	}
+	evs := events(t, dir)
+	if len(evs) != 5 {
+		t.Fatalf("%d events, want 5", len(evs))
+	}
+	if evs[0].Source != "peer" || evs[0].Time.IsZero() {
+		t.Errorf("event not stamped: %+v", evs[0])
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	name := func(source string, age time.Duration) string {
+		return filepath.Join(dir, "audit-"+source+"-"+time.Now().Add(-age).Format("20060102-150405.000")+".jsonl")
+	}
+	old, recent, otherSource := name("peer", 48*time.Hour), name("peer", time.Hour), name("server", 48*time.Hour)
+	for _, p := range []string{old, recent, otherSource} {
+		if err := os.WriteFile(p, []byte("{}\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := audit.New(audit.Config{Dir: dir, MaxAge: 24 * time.Hour}, "peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("expired file kept")
+	}
+	for _, p := range []string{recent, otherSource} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s: %v", filepath.Base(p), err)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	for _, tc := range []struct {
+		keys      string
+		wantKeys  []string
+		wantPaste string
+	}{
+		{audit.KeysOff, nil, ""},
+		{audit.KeysRedact, []string{"*", "Enter"}, ""},
+		{audit.KeysFull, []string{"s", "Enter"}, "hunter2"},
+	} {
+		t.Run(tc.keys, func(t *testing.T) {
+			dir := t.TempDir()
+			l, err := audit.New(audit.Config{Dir: dir, Keys: tc.keys}, "peer")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.Key("s1", "s")
+			l.Key("s1", "Enter")
+			l.Paste("s1", "hunter2")
+			l.Close()
+			var keys []string
+			for _, e := range events(t, dir) {
+				switch e.Kind {
+				case audit.KindKey:
+					keys = append(keys, e.Key)
+				case audit.KindPaste:
+					if e.Length != 7 || e.Text != tc.wantPaste {
+						t.Errorf("paste logged as %+v", e)
+					}
+				}
+			}
+			if strings.Join(keys, ",") != strings.Join(tc.wantKeys, ",") {
+				t.Errorf("keys %q, want %q", keys, tc.wantKeys)
+			}
+		})
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *audit.Logger
+	l.Log(audit.Event{Kind: audit.KindOffer})
+	l.Key("s", "a")
+	l.Paste("s", "x")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
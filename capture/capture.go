@@ -0,0 +1,86 @@
+package capture
+
+// Package capture abstracts where screen images come from so the frames
+// pipeline can run against a real desktop or, in tests, a synthetic source.
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Capturer is a source of screen images.
+type Capturer interface {
+	// Displays lists the bounds of each display in virtual-screen
+	// coordinates. Index 0 is the primary display.
+	Displays() ([]image.Rectangle, error)
+	// Capture grabs the given rectangle of the virtual screen.
+	Capture(rect image.Rectangle) (*image.RGBA, error)
+	// Cursor reports the cursor position in virtual-screen coordinates.
+	Cursor() (x, y int)
+	// Close releases any connection or shared memory held by the backend.
+	Close() error
+}
+
+// BufferedCapturer is implemented by backends that can fill an image the
+// caller reuses instead of allocating one per frame.
+type BufferedCapturer interface {
+	// CaptureInto is Capture writing into dst when it is non-nil and has
+	// the size of rect.
+	CaptureInto(dst *image.RGBA, rect image.Rectangle) (*image.RGBA, error)
+}
+
+// CaptureInto captures rect into dst when c supports it and allocates
+// otherwise.
+func CaptureInto(c Capturer, dst *image.RGBA, rect image.Rectangle) (*image.RGBA, error) {
+	if bc, ok := c.(BufferedCapturer); ok {
+		return bc.CaptureInto(dst, rect)
+	}
+	return c.Capture(rect)
+}
+
+// reuse returns dst if it can hold a w x h image, or a new image.
+func reuse(dst *image.RGBA, w, h int) *image.RGBA {
+	if dst != nil && dst.Rect == image.Rect(0, 0, w, h) {
+		return dst
+	}
+	return image.NewRGBA(image.Rect(0, 0, w, h))
+}
+
+// New returns the backend with the given name:
+//
+//	"screenshot" (or "")  kbinani/screenshot, all platforms
+//	"x11shm"              persistent X11 connection with MIT-SHM (Linux only)
+//	"synthetic"           deterministic 1280x720 test pattern
+func New(name string) (Capturer, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "screenshot":
+		return NewScreenshot(), nil
+	case "x11shm", "x11":
+		x, err := NewX11SHM()
+		if err != nil {
+			return nil, err
+		}
+		return x, nil
+	case "synthetic":
+		return NewSynthetic(1280, 720), nil
+	default:
+		return nil, fmt.Errorf("unknown capture backend %q", name)
+	}
+}
+
+// Display returns the bounds of display index d, falling back to the
+// primary display when d is out of range.
+func Display(c Capturer, d int) (image.Rectangle, error) {
+	displays, err := c.Displays()
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if len(displays) == 0 {
+		return image.Rectangle{}, fmt.Errorf("no active displays")
+	}
+	if d < 0 || d >= len(displays) {
+		d = 0
+	}
+	return displays[d], nil
+}
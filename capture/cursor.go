@@ -0,0 +1,68 @@
+package capture
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// CursorShape is the current cursor image.
+type CursorShape struct {
+	// Image is nil when the cursor is hidden.
+	Image      *image.RGBA
+	HotX, HotY int
+	// Serial changes whenever the shape changes, so callers can skip
+	// unchanged shapes without comparing pixels.
+	Serial uint64
+}
+
+// CursorShaper is implemented by backends that can read the cursor image.
+type CursorShaper interface {
+	CursorShape() (CursorShape, error)
+}
+
+// ErrNoCursorShape is returned when the platform cannot report the cursor
+// image; the page then draws its own arrow.
+var ErrNoCursorShape = errors.New("cursor shape not supported")
+
+// Shapes drawn by the synthetic backend.
+var syntheticShapes = map[string]CursorShape{
+	"arrow": {Image: arrowCursor(), Serial: 1},
+	"ibeam": {Image: ibeamCursor(), HotX: 4, HotY: 8, Serial: 2},
+	"none":  {Serial: 3},
+}
+
+// arrowCursor is a 12x19 black-outlined white arrow with its hotspot at 0,0.
+func arrowCursor() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 12, 19))
+	for y := 0; y < 19; y++ {
+		w := y * 2 / 3
+		if y > 16 {
+			w = 0
+		}
+		for x := 0; x <= w && x < 12; x++ {
+			c := color.RGBA{255, 255, 255, 255}
+			if x == 0 || x == w || y == 16 {
+				c = color.RGBA{0, 0, 0, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// ibeamCursor is a 9x17 text cursor with its hotspot in the middle.
+func ibeamCursor() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 9, 17))
+	black := color.RGBA{0, 0, 0, 255}
+	for y := 0; y < 17; y++ {
+		img.SetRGBA(4, y, black)
+	}
+	for x := 1; x < 8; x++ {
+		if x != 4 {
+			img.SetRGBA(x, 0, black)
+			img.SetRGBA(x, 16, black)
+		}
+	}
+	return img
+}
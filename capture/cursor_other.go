@@ -0,0 +1,7 @@
+//go:build !windows
+
+package capture
+
+// CursorShape is only implemented on Windows for this backend; on Linux use
+// the x11shm backend.
+func (*Screenshot) CursorShape() (CursorShape, error) { return CursorShape{}, ErrNoCursorShape }
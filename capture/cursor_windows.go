@@ -0,0 +1,171 @@
+//go:build windows
+
+package capture
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	user32                 = syscall.NewLazyDLL("user32.dll")
+	gdi32                  = syscall.NewLazyDLL("gdi32.dll")
+	procGetCursorInfo      = user32.NewProc("GetCursorInfo")
+	procGetIconInfo        = user32.NewProc("GetIconInfo")
+	procDrawIconEx         = user32.NewProc("DrawIconEx")
+	procCreateCompatibleDC = gdi32.NewProc("CreateCompatibleDC")
+	procCreateDIBSection   = gdi32.NewProc("CreateDIBSection")
+	procSelectObject       = gdi32.NewProc("SelectObject")
+	procDeleteObject       = gdi32.NewProc("DeleteObject")
+	procDeleteDC           = gdi32.NewProc("DeleteDC")
+	procGetObject          = gdi32.NewProc("GetObjectW")
+)
+
+const (
+	cursorShowing = 0x1
+	diNormal      = 0x3
+)
+
+type cursorInfo struct {
+	cbSize  uint32
+	flags   uint32
+	hCursor uintptr
+	x, y    int32
+}
+
+type iconInfo struct {
+	fIcon              int32
+	xHotspot, yHotspot uint32
+	hbmMask, hbmColor  uintptr
+}
+
+type bitmap struct {
+	bmType, bmWidth, bmHeight, bmWidthBytes int32
+	bmPlanes, bmBitsPixel                   uint16
+	bmBits                                  uintptr
+}
+
+type bitmapInfoHeader struct {
+	biSize                           uint32
+	biWidth, biHeight                int32
+	biPlanes, biBitCount             uint16
+	biCompression, biSizeImage       uint32
+	biXPelsPerMeter, biYPelsPerMeter int32
+	biClrUsed, biClrImportant        uint32
+}
+
+// The cursor handle identifies the shape, so the image is only rendered
+// again when the handle changes.
+var (
+	cursorMu   sync.Mutex
+	lastCursor CursorShape
+)
+
+// CursorShape reads the current cursor through GetCursorInfo/DrawIconEx.
+func (*Screenshot) CursorShape() (CursorShape, error) {
+	ci := cursorInfo{cbSize: uint32(unsafe.Sizeof(cursorInfo{}))}
+	if ret, _, err := procGetCursorInfo.Call(uintptr(unsafe.Pointer(&ci))); ret == 0 {
+		return CursorShape{}, fmt.Errorf("GetCursorInfo: %w", err)
+	}
+	if ci.flags&cursorShowing == 0 || ci.hCursor == 0 {
+		return CursorShape{}, nil
+	}
+	cursorMu.Lock()
+	defer cursorMu.Unlock()
+	if lastCursor.Serial == uint64(ci.hCursor) {
+		return lastCursor, nil
+	}
+	shape, err := renderCursor(ci.hCursor)
+	if err != nil {
+		return CursorShape{}, err
+	}
+	lastCursor = shape
+	return shape, nil
+}
+
+func renderCursor(h uintptr) (CursorShape, error) {
+	var ii iconInfo
+	if ret, _, err := procGetIconInfo.Call(h, uintptr(unsafe.Pointer(&ii))); ret == 0 {
+		return CursorShape{}, fmt.Errorf("GetIconInfo: %w", err)
+	}
+	defer func() {
+		if ii.hbmMask != 0 {
+			procDeleteObject.Call(ii.hbmMask)
+		}
+		if ii.hbmColor != 0 {
+			procDeleteObject.Call(ii.hbmColor)
+		}
+	}()
+	var bm bitmap
+	src := ii.hbmColor
+	if src == 0 {
+		src = ii.hbmMask
+	}
+	if ret, _, _ := procGetObject.Call(src, unsafe.Sizeof(bm), uintptr(unsafe.Pointer(&bm))); ret == 0 {
+		return CursorShape{}, fmt.Errorf("GetObject: cursor bitmap")
+	}
+	w, hgt := int(bm.bmWidth), int(bm.bmHeight)
+	if ii.hbmColor == 0 {
+		// Monochrome cursors stack the AND and XOR masks vertically
+		hgt /= 2
+	}
+	if w <= 0 || hgt <= 0 || w > 256 || hgt > 256 {
+		return CursorShape{}, fmt.Errorf("cursor size %dx%d", w, hgt)
+	}
+	// Draw on black and on white: the difference gives the alpha, which
+	// also covers monochrome and legacy cursors without an alpha channel.
+	onBlack, err := drawCursor(h, w, hgt, 0x00)
+	if err != nil {
+		return CursorShape{}, err
+	}
+	onWhite, err := drawCursor(h, w, hgt, 0xff)
+	if err != nil {
+		return CursorShape{}, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, hgt))
+	for i := 0; i < w*hgt*4; i += 4 {
+		b, wt := onBlack[i:i+4], onWhite[i:i+4]
+		a := 255 - (int(wt[1]) - int(b[1]))
+		switch {
+		case a <= 0:
+			continue
+		case a > 255:
+			// Inverting (XOR) pixel: show it as opaque black
+			img.Pix[i+3] = 255
+			continue
+		}
+		// BGRA on black is the premultiplied color
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = b[2], b[1], b[0], byte(a)
+	}
+	return CursorShape{Image: img, HotX: int(ii.xHotspot), HotY: int(ii.yHotspot), Serial: uint64(h)}, nil
+}
+
+// drawCursor renders the cursor into a top-down 32-bit DIB filled with bg.
+func drawCursor(h uintptr, w, hgt int, bg byte) ([]byte, error) {
+	dc, _, _ := procCreateCompatibleDC.Call(0)
+	if dc == 0 {
+		return nil, fmt.Errorf("CreateCompatibleDC failed")
+	}
+	defer procDeleteDC.Call(dc)
+	bih := bitmapInfoHeader{biWidth: int32(w), biHeight: -int32(hgt), biPlanes: 1, biBitCount: 32}
+	bih.biSize = uint32(unsafe.Sizeof(bih))
+	var bits unsafe.Pointer
+	dib, _, _ := procCreateDIBSection.Call(dc, uintptr(unsafe.Pointer(&bih)), 0, uintptr(unsafe.Pointer(&bits)), 0, 0)
+	if dib == 0 || bits == nil {
+		return nil, fmt.Errorf("CreateDIBSection failed")
+	}
+	defer procDeleteObject.Call(dib)
+	old, _, _ := procSelectObject.Call(dc, dib)
+	defer procSelectObject.Call(dc, old)
+	pix := unsafe.Slice((*byte)(bits), w*hgt*4)
+	for i := range pix {
+		pix[i] = bg
+	}
+	if ret, _, err := procDrawIconEx.Call(dc, 0, 0, h, uintptr(w), uintptr(hgt), 0, 0, diNormal); ret == 0 {
+		return nil, fmt.Errorf("DrawIconEx: %w", err)
+	}
+	return append([]byte(nil), pix...), nil
+}
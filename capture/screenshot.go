@@ -0,0 +1,33 @@
+package capture
+
+import (
+	"image"
+
+	"weblinuxgui/input"
+
+	"github.com/kbinani/screenshot"
+)
+
+// Screenshot captures through kbinani/screenshot. The cursor position comes
+// from the input package, which only reports it on Windows.
+type Screenshot struct{}
+
+// NewScreenshot returns the default capture backend.
+func NewScreenshot() *Screenshot { return &Screenshot{} }
+
+func (*Screenshot) Displays() ([]image.Rectangle, error) {
+	n := screenshot.NumActiveDisplays()
+	out := make([]image.Rectangle, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, screenshot.GetDisplayBounds(i))
+	}
+	return out, nil
+}
+
+func (*Screenshot) Capture(rect image.Rectangle) (*image.RGBA, error) {
+	return screenshot.CaptureRect(rect)
+}
+
+func (*Screenshot) Cursor() (x, y int) { return input.GetMousePos() }
+
+func (*Screenshot) Close() error { return nil }
@@ -0,0 +1,116 @@
+package capture
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+)
+
+// Step is a scripted change applied by Synthetic once its frame counter
+// reaches Frame. Fills accumulate; a later step paints over earlier ones.
+type Step struct {
+	Frame int
+	// Fill paints Rect with Color when Rect is non-empty.
+	Rect  image.Rectangle
+	Color color.RGBA
+	// MoveCursor sets the reported cursor position to CursorX/CursorY.
+	MoveCursor       bool
+	CursorX, CursorY int
+	// CursorShape switches the cursor image: "arrow", "ibeam" or "none".
+	CursorShape string
+}
+
+// Synthetic renders a deterministic test pattern (eight vertical color bars)
+// with scripted changes on top. Every Capture call advances the frame
+// counter by one, so the same script always yields the same images.
+type Synthetic struct {
+	mu      sync.Mutex
+	bounds  image.Rectangle
+	base    *image.RGBA
+	script  []Step
+	applied int
+	frame   int
+	cx, cy  int
+	shape   string
+}
+
+// Bar colors of the base pattern, left to right.
+var syntheticBars = []color.RGBA{
+	{255, 255, 255, 255}, {255, 255, 0, 255}, {0, 255, 255, 255}, {0, 255, 0, 255},
+	{255, 0, 255, 255}, {255, 0, 0, 255}, {0, 0, 255, 255}, {0, 0, 0, 255},
+}
+
+// NewSynthetic returns a single-display source of the given size. Steps must
+// be ordered by Frame.
+func NewSynthetic(width, height int, script ...Step) *Synthetic {
+	b := image.Rect(0, 0, width, height)
+	base := image.NewRGBA(b)
+	for i, c := range syntheticBars {
+		x0 := width * i / len(syntheticBars)
+		x1 := width * (i + 1) / len(syntheticBars)
+		draw.Draw(base, image.Rect(x0, 0, x1, height), &image.Uniform{C: c}, image.Point{}, draw.Src)
+	}
+	return &Synthetic{bounds: b, base: base, script: script, shape: "arrow"}
+}
+
+func (s *Synthetic) Displays() ([]image.Rectangle, error) {
+	return []image.Rectangle{s.bounds}, nil
+}
+
+// Capture returns the current picture clipped to rect and advances the
+// frame counter. Areas outside the display are black.
+func (s *Synthetic) Capture(rect image.Rectangle) (*image.RGBA, error) {
+	return s.CaptureInto(nil, rect)
+}
+
+func (s *Synthetic) CaptureInto(dst *image.RGBA, rect image.Rectangle) (*image.RGBA, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applyLocked()
+	s.frame++
+	img := reuse(dst, rect.Dx(), rect.Dy())
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{0, 0, 0, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, img.Bounds(), s.base, rect.Min, draw.Src)
+	return img, nil
+}
+
+// applyLocked paints all steps due at the current frame into the base image.
+func (s *Synthetic) applyLocked() {
+	for s.applied < len(s.script) && s.script[s.applied].Frame <= s.frame {
+		st := s.script[s.applied]
+		if !st.Rect.Empty() {
+			draw.Draw(s.base, st.Rect, &image.Uniform{C: st.Color}, image.Point{}, draw.Src)
+		}
+		if st.MoveCursor {
+			s.cx, s.cy = st.CursorX, st.CursorY
+		}
+		if _, ok := syntheticShapes[st.CursorShape]; ok {
+			s.shape = st.CursorShape
+		}
+		s.applied++
+	}
+}
+
+func (s *Synthetic) Cursor() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cx, s.cy
+}
+
+// CursorShape returns the scripted shape. The image is shared; callers must
+// not modify it.
+func (s *Synthetic) CursorShape() (CursorShape, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return syntheticShapes[s.shape], nil
+}
+
+// Frame returns how many images have been captured so far.
+func (s *Synthetic) Frame() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frame
+}
+
+func (*Synthetic) Close() error { return nil }
@@ -0,0 +1,190 @@
+//go:build linux && !s390x && !ppc64le
+
+package capture
+
+import (
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/gen2brain/shm"
+	"github.com/jezek/xgb"
+	mshm "github.com/jezek/xgb/shm"
+	"github.com/jezek/xgb/xfixes"
+	"github.com/jezek/xgb/xinerama"
+	"github.com/jezek/xgb/xproto"
+)
+
+// X11SHM captures from an X server over one persistent connection, reusing a
+// MIT-SHM segment between frames instead of reconnecting and copying the
+// image through the socket every time.
+type X11SHM struct {
+	mu   sync.Mutex
+	conn *xgb.Conn
+	root xproto.Window
+
+	seg     mshm.Seg
+	shmID   int
+	data    []byte
+	segSize int
+
+	// XFixes is set up on the first CursorShape call
+	fixesChecked bool
+	fixesErr     error
+}
+
+// NewX11SHM connects to $DISPLAY. The server must support MIT-SHM, which
+// rules out remote X connections.
+func NewX11SHM() (*X11SHM, error) {
+	c, err := xgb.NewConn()
+	if err != nil {
+		return nil, fmt.Errorf("x11 connect: %w", err)
+	}
+	if err := mshm.Init(c); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("x11 MIT-SHM: %w", err)
+	}
+	root := xproto.Setup(c).DefaultScreen(c).Root
+	return &X11SHM{conn: c, root: root, shmID: -1}, nil
+}
+
+func (x *X11SHM) Displays() ([]image.Rectangle, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := xinerama.Init(x.conn); err == nil {
+		if reply, err := xinerama.QueryScreens(x.conn).Reply(); err == nil && len(reply.ScreenInfo) > 0 {
+			out := make([]image.Rectangle, 0, len(reply.ScreenInfo))
+			for _, s := range reply.ScreenInfo {
+				out = append(out, image.Rect(int(s.XOrg), int(s.YOrg), int(s.XOrg)+int(s.Width), int(s.YOrg)+int(s.Height)))
+			}
+			return out, nil
+		}
+	}
+	// No Xinerama: the whole root window is one display
+	screen := xproto.Setup(x.conn).DefaultScreen(x.conn)
+	return []image.Rectangle{image.Rect(0, 0, int(screen.WidthInPixels), int(screen.HeightInPixels))}, nil
+}
+
+func (x *X11SHM) Capture(rect image.Rectangle) (*image.RGBA, error) {
+	return x.CaptureInto(nil, rect)
+}
+
+func (x *X11SHM) CaptureInto(dst *image.RGBA, rect image.Rectangle) (*image.RGBA, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if rect.Empty() {
+		return nil, fmt.Errorf("empty capture rect")
+	}
+	if err := x.ensureSegment(rect.Dx() * rect.Dy() * 4); err != nil {
+		return nil, err
+	}
+	_, err := mshm.GetImage(x.conn, xproto.Drawable(x.root),
+		int16(rect.Min.X), int16(rect.Min.Y), uint16(rect.Dx()), uint16(rect.Dy()),
+		0xffffffff, byte(xproto.ImageFormatZPixmap), x.seg, 0).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("x11 shm get image: %w", err)
+	}
+	// ZPixmap at depth 24/32 is BGRX
+	img := reuse(dst, rect.Dx(), rect.Dy())
+	src := x.data[:len(img.Pix)]
+	for i := 0; i < len(src); i += 4 {
+		img.Pix[i] = src[i+2]
+		img.Pix[i+1] = src[i+1]
+		img.Pix[i+2] = src[i]
+		img.Pix[i+3] = 255
+	}
+	return img, nil
+}
+
+// ensureSegment (re)allocates the shared memory segment when a larger
+// capture is requested.
+func (x *X11SHM) ensureSegment(size int) error {
+	if size <= x.segSize {
+		return nil
+	}
+	x.releaseSegment()
+	id, err := shm.Get(shm.IPC_PRIVATE, size, shm.IPC_CREAT|0o600)
+	if err != nil {
+		return fmt.Errorf("shmget: %w", err)
+	}
+	data, err := shm.At(id, 0, 0)
+	if err != nil {
+		_ = shm.Rm(id)
+		return fmt.Errorf("shmat: %w", err)
+	}
+	seg, err := mshm.NewSegId(x.conn)
+	if err != nil {
+		_ = shm.Dt(data)
+		_ = shm.Rm(id)
+		return fmt.Errorf("x11 shm segment: %w", err)
+	}
+	if err := mshm.AttachChecked(x.conn, seg, uint32(id), false).Check(); err != nil {
+		_ = shm.Dt(data)
+		_ = shm.Rm(id)
+		return fmt.Errorf("x11 shm attach: %w", err)
+	}
+	// Mark for removal now; it is destroyed once both sides detach
+	_ = shm.Rm(id)
+	x.seg, x.shmID, x.data, x.segSize = seg, id, data, size
+	return nil
+}
+
+func (x *X11SHM) releaseSegment() {
+	if x.shmID < 0 {
+		return
+	}
+	_ = mshm.DetachChecked(x.conn, x.seg).Check()
+	_ = shm.Dt(x.data)
+	x.shmID, x.data, x.segSize = -1, nil, 0
+}
+
+func (x *X11SHM) Cursor() (int, int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	reply, err := xproto.QueryPointer(x.conn, x.root).Reply()
+	if err != nil {
+		return 0, 0
+	}
+	return int(reply.RootX), int(reply.RootY)
+}
+
+// CursorShape reads the cursor image through XFixes.
+func (x *X11SHM) CursorShape() (CursorShape, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !x.fixesChecked {
+		x.fixesChecked = true
+		if x.fixesErr = xfixes.Init(x.conn); x.fixesErr == nil {
+			// The server only answers XFixes requests after version negotiation
+			_, x.fixesErr = xfixes.QueryVersion(x.conn, 4, 0).Reply()
+		}
+	}
+	if x.fixesErr != nil {
+		return CursorShape{}, ErrNoCursorShape
+	}
+	r, err := xfixes.GetCursorImage(x.conn).Reply()
+	if err != nil {
+		return CursorShape{}, fmt.Errorf("x11 cursor image: %w", err)
+	}
+	w, h := int(r.Width), int(r.Height)
+	if w*h > len(r.CursorImage) {
+		return CursorShape{}, fmt.Errorf("x11 cursor image: short reply")
+	}
+	// Pixels are premultiplied ARGB, like image.RGBA
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, p := range r.CursorImage[:w*h] {
+		img.Pix[i*4] = byte(p >> 16)
+		img.Pix[i*4+1] = byte(p >> 8)
+		img.Pix[i*4+2] = byte(p)
+		img.Pix[i*4+3] = byte(p >> 24)
+	}
+	return CursorShape{Image: img, HotX: int(r.Xhot), HotY: int(r.Yhot), Serial: uint64(r.CursorSerial)}, nil
+}
+
+func (x *X11SHM) Close() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.releaseSegment()
+	x.conn.Close()
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build !linux || s390x || ppc64le
+
+package capture
+
+import (
+	"fmt"
+	"image"
+)
+
+// X11SHM is only available on Linux.
+type X11SHM struct{}
+
+func NewX11SHM() (*X11SHM, error) {
+	return nil, fmt.Errorf("x11shm capture is not supported on this platform")
+}
+
+func (*X11SHM) Displays() ([]image.Rectangle, error) { return nil, nil }
+
+func (*X11SHM) Capture(image.Rectangle) (*image.RGBA, error) {
+	return nil, fmt.Errorf("x11shm capture is not supported on this platform")
+}
+
+func (*X11SHM) Cursor() (int, int) { return 0, 0 }
+
+func (*X11SHM) CursorShape() (CursorShape, error) { return CursorShape{}, ErrNoCursorShape }
+
+func (*X11SHM) Close() error { return nil }
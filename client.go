@@ -9,7 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -19,17 +19,30 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"weblinuxgui/audit"
+	"weblinuxgui/config"
+	"weblinuxgui/logging"
+	"weblinuxgui/metrics"
+	"weblinuxgui/signaling"
+	"weblinuxgui/tlscert"
+	"weblinuxgui/turnserver"
 )
 
-//go:embed index.html
+//go:embed index.html playback.html render.js
 var embeddedFiles embed.FS
 
 func serveIndex(w http.ResponseWriter, r *http.Request) {
+	serveEmbedded(w, "index.html", "text/html; charset=utf-8")
+}
+
+// serveEmbedded writes an embedded file without caching.
+func serveEmbedded(w http.ResponseWriter, name, contentType string) {
 	setNoCache(w)
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	b, err := embeddedFiles.ReadFile("index.html")
+	w.Header().Set("Content-Type", contentType)
+	b, err := embeddedFiles.ReadFile(name)
 	if err != nil {
-		http.Error(w, "index missing", http.StatusInternalServerError)
+		http.Error(w, name+" missing", http.StatusInternalServerError)
 		return
 	}
 	_, _ = w.Write(b)
@@ -43,16 +56,10 @@ func setNoCache(w http.ResponseWriter) {
 	w.Header().Set("Expires", "0")
 }
 
-// startPeriodicMemoryRelease runs GC and returns free memory to the OS at a fixed interval.
-// Controlled by env var CACHE_CLEAN_INTERVAL (e.g., "5m", "30s"). Set to "0" or empty to disable.
-func startPeriodicMemoryRelease(done <-chan struct{}) {
-	intervalStr := os.Getenv("CACHE_CLEAN_INTERVAL")
-	if strings.TrimSpace(intervalStr) == "" {
-		intervalStr = "5m"
-	}
-	d, err := time.ParseDuration(intervalStr)
-	if err != nil || d <= 0 {
-		// Disabled or invalid interval; do nothing
+// startPeriodicMemoryRelease runs GC and returns free memory to the OS every
+// d (CACHE_CLEAN_INTERVAL); 0 disables it.
+func startPeriodicMemoryRelease(d time.Duration, done <-chan struct{}) {
+	if d <= 0 {
 		return
 	}
 	ticker := time.NewTicker(d)
@@ -71,60 +78,104 @@ func startPeriodicMemoryRelease(done <-chan struct{}) {
 	}()
 }
 
-func runServer(addr string) {
+// fatal logs an error and exits, like log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func runServer(cfg *config.Server) {
+	addr, iceCfg := cfg.Addr, cfg.ICE
+	// Optional embedded TURN relay (TURN_ENABLE=1)
+	var relay *turnserver.Server
+	if turnCfg := cfg.TURN; turnCfg.Enable {
+		var err error
+		turnCfg.LoggerFactory = cfg.Log.Pion()
+		if relay, err = turnserver.Start(turnCfg); err != nil {
+			fatal("turn", "err", err)
+		}
+		defer relay.Close()
+		slog.Info("embedded TURN relay listening", "advertised", relay.Addr())
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", serveIndex)
+	mux.HandleFunc("/render.js", func(w http.ResponseWriter, r *http.Request) {
+		serveEmbedded(w, "render.js", "text/javascript; charset=utf-8")
+	})
+	registerPlayback(mux, cfg.RecordDir, cfg.PlaybackToken)
+	// /config tells the page which ICE servers and transport policy to use so it agrees with the peer.
+	// It is unauthenticated, so it never carries TURN credentials: /signal hands those out with
+	// the answer, once the peer has admitted the browser.
+	mux.HandleFunc("/config", newRateLimit(30, time.Minute).wrap(func(w http.ResponseWriter, r *http.Request) {
+		setNoCache(w)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(iceCfg.Browser())
+	}))
+	// /qr renders the POSTed text (a pasted offer/answer) as a QR code PNG for manual signaling
+	mux.HandleFunc("/qr", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		setNoCache(w)
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 16*1024))
+		_ = r.Body.Close()
+		png, err := signaling.QR(strings.TrimSpace(string(body)), 512)
+		if err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(png)
+	})
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		setNoCache(w)
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
 
-	// UDP signaling parameters (ENV)
-	getEnv := func(k, def string) string {
-		if v := os.Getenv(k); v != "" {
-			return v
-		}
-		return def
-	}
-	getEnvBool := func(k string, def bool) bool {
-		if v := os.Getenv(k); v != "" {
-			v = strings.ToLower(strings.TrimSpace(v))
-			return v == "1" || v == "true" || v == "yes"
-		}
-		return def
-	}
-	// New simple variables: set PEER_IP and UDP_PORT; others optional
-	udpPort := getEnv("UDP_PORT", "8080")
-	peerIP := getEnv("PEER_IP", "192.168.1.16")
-	localhostOnly := getEnvBool("BIND_LOCALHOST_ONLY", false)
-	// Derive bind/remote with overrides
-	bindAddr := os.Getenv("LOCAL_ADDR")
-	if bindAddr == "" {
-		if localhostOnly {
-			bindAddr = net.JoinHostPort("127.0.0.1", udpPort)
-		} else {
-			bindAddr = net.JoinHostPort("0.0.0.0", udpPort)
-		}
-	}
-	remoteAddrStr := os.Getenv("REMOTE_ADDR")
-	if remoteAddrStr == "" {
-		remoteAddrStr = net.JoinHostPort(peerIP, udpPort)
-	}
+	// UDP signaling: offers go to the peer at PEER_IP:UDP_PORT (or REMOTE_ADDR)
+	bindAddr, remoteAddrStr := cfg.UDP.BindAddr(), cfg.PeerAddr()
 
 	// Prepare UDP socket
 	localAddr, err := net.ResolveUDPAddr("udp4", bindAddr)
 	if err != nil {
-		log.Printf("UDP resolve local error: %v", err)
+		slog.Error("UDP resolve local address", "addr", bindAddr, "err", err)
 	}
 	remoteAddr, err := net.ResolveUDPAddr("udp4", remoteAddrStr)
 	if err != nil {
-		log.Printf("UDP resolve remote error: %v", err)
+		slog.Error("UDP resolve peer address", "addr", remoteAddrStr, "err", err)
 	}
-	conn, err := net.ListenUDP("udp4", localAddr)
-	if err != nil {
-		log.Printf("UDP listen error: %v", err)
+	var sig *signaling.Conn
+	if conn, err := net.ListenUDP("udp4", localAddr); err != nil {
+		slog.Error("UDP listen", "addr", bindAddr, "err", err)
+	} else {
+		sig = signaling.NewConn(conn)
+		defer sig.Close()
 	}
+	// Pin the peer's DTLS certificate: answers from a host that is not enrolled are rejected
+	var pins *signaling.Pins
+	if pinCfg := cfg.Pins; pinCfg.Enabled() {
+		var err error
+		if pins, err = signaling.NewPins(pinCfg); err != nil {
+			fatal("pins", "err", err)
+		}
+		slog.Info("pinning peer DTLS certificate", "enrolled", pins.Len())
+	}
+	// AUDIT_DIR logs every offer and its outcome as JSON lines
+	var auditLog *audit.Logger
+	if auditCfg := cfg.Audit; auditCfg.Enabled() {
+		var err error
+		if auditLog, err = audit.New(auditCfg, "server"); err != nil {
+			fatal("audit", "err", err)
+		}
+		defer auditLog.Close()
+		slog.Info("audit log", "dir", auditCfg.Dir)
+	}
+	// Prometheus metrics of the signaling requests
+	serverMetrics := metrics.NewServer()
+	mux.Handle("/metrics", serverMetrics.Handler())
 	// /signal handler: accept offer (base64 or JSON), forward to Windows via UDP, return answer as JSON
 	mux.HandleFunc("/signal", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -132,9 +183,23 @@ func runServer(addr string) {
 			return
 		}
 		setNoCache(w)
+		start, result, session := time.Now(), metrics.OutcomeBadRequest, ""
+		defer func() {
+			d := time.Since(start)
+			serverMetrics.Signal(result, d)
+			level := slog.LevelInfo
+			if result != metrics.OutcomeAnswered {
+				level = slog.LevelWarn
+			}
+			slog.Log(r.Context(), level, "signal", "session", session, "addr", r.RemoteAddr, "outcome", result, "duration", d)
+		}()
 		var req struct {
 			offerB64 string
 			Offer    json.RawMessage
+			Session  string
+			Name     string
+			Code     string
+			Token    string
 		}
 		// Accept either raw base64 string or JSON object
 		body, _ := io.ReadAll(r.Body)
@@ -142,6 +207,13 @@ func runServer(addr string) {
 		// Try to parse as JSON first
 		var tmp map[string]any
 		if err := json.Unmarshal(body, &tmp); err == nil {
+			// session lets the peer recognize ICE restarts of an existing session
+			req.Session, _ = tmp["session"].(string)
+			// name is how the browser introduces itself in the host's approval prompt
+			req.Name, _ = tmp["name"].(string)
+			// code (shown on the host) or token (from an earlier pairing) admit the browser
+			req.Code, _ = tmp["code"].(string)
+			req.Token, _ = tmp["token"].(string)
 			if v, ok := tmp["offerB64"].(string); ok && v != "" {
 				req.offerB64 = v
 			} else if v, ok := tmp["offer"].(map[string]any); ok {
@@ -156,82 +228,208 @@ func runServer(addr string) {
 			_, _ = w.Write([]byte("missing offer"))
 			return
 		}
-		if len(req.Offer) != 0 && req.offerB64 == "" {
-			req.offerB64 = base64.StdEncoding.EncodeToString(req.Offer)
+		if len(req.Offer) == 0 {
+			b, err := base64.StdEncoding.DecodeString(req.offerB64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte("invalid offer b64"))
+				return
+			}
+			req.Offer = b
 		}
-		if conn == nil || remoteAddr == nil {
+		offer, err := signaling.DecodeOffer(req.Offer)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		if req.Session != "" {
+			offer.Session = req.Session
+		}
+		session = offer.Session
+		offer.Code, offer.DeviceToken = req.Code, req.Token
+		offer.Requester = &signaling.Requester{Addr: r.RemoteAddr, Name: req.Name, UserAgent: r.UserAgent()}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			offer.Requester.Addr = host
+		}
+		outcome := func(kind, detail string) {
+			auditLog.Log(audit.Event{Kind: kind, Session: offer.Session, Addr: offer.Requester.Addr, Name: req.Name, UserAgent: offer.Requester.UserAgent, Detail: detail})
+		}
+		outcome(audit.KindOffer, "")
+		// Hand the peer its own short-lived TURN credentials for this session
+		if relay != nil {
+			cred, err := relay.Credentials("peer")
+			if err != nil {
+				result = metrics.OutcomeError
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			offer.ICEServers = append(offer.ICEServers, cred)
+		}
+		offerJSON, _ := json.Marshal(offer)
+		req.offerB64 = base64.StdEncoding.EncodeToString(offerJSON)
+		if sig == nil || remoteAddr == nil {
+			result = metrics.OutcomeUnavailable
 			w.WriteHeader(http.StatusServiceUnavailable)
 			_, _ = w.Write([]byte("UDP not configured"))
 			return
 		}
 		// Send OFFER via UDP and wait for ANSWER
-		_, _ = conn.WriteToUDP([]byte("OFFER:"+req.offerB64), remoteAddr)
-		answerStr, _, err := waitForPrefix(conn, "ANSWER:", 30*time.Second)
+		id, err := sig.Send(remoteAddr, "OFFER", []byte(req.offerB64))
 		if err != nil {
+			outcome(audit.KindRejected, "send OFFER: "+err.Error())
+			result = metrics.OutcomeError
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte("send OFFER: " + err.Error()))
+			return
+		}
+		// Long enough for the host to answer an approval prompt (APPROVAL_TIMEOUT, 30s by default)
+		ans, err := sig.ReceiveReply(60*time.Second, id)
+		if err != nil {
+			outcome(audit.KindRejected, "no answer from the peer")
+			result = metrics.OutcomeTimeout
 			w.WriteHeader(http.StatusGatewayTimeout)
 			_, _ = w.Write([]byte("wait ANSWER timeout"))
 			return
 		}
+		switch ans.Kind {
+		case "REJECT":
+			outcome(audit.KindRejected, string(ans.Payload))
+			result = metrics.OutcomeRejected
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write(ans.Payload)
+			return
+		case "UNPAIRED":
+			outcome(audit.KindRejected, string(ans.Payload))
+			result = metrics.OutcomeUnpaired
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write(ans.Payload)
+			return
+		case "ERROR":
+			outcome(audit.KindRejected, "peer error: "+string(ans.Payload))
+			result = metrics.OutcomeError
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write(ans.Payload)
+			return
+		}
 		// Decode and return JSON
-		b, err := base64.StdEncoding.DecodeString(answerStr)
+		b, err := base64.StdEncoding.DecodeString(string(ans.Payload))
 		if err != nil {
+			result = metrics.OutcomeError
 			w.WriteHeader(http.StatusBadGateway)
 			_, _ = w.Write([]byte("invalid ANSWER b64"))
 			return
 		}
+		if pins != nil {
+			if err := pins.Check(b); err != nil {
+				slog.Warn("rejecting ANSWER", "session", offer.Session, "from", ans.From.String(), "err", err)
+				outcome(audit.KindRejected, "peer not trusted: "+err.Error())
+				result = metrics.OutcomeUntrusted
+				w.WriteHeader(http.StatusBadGateway)
+				_, _ = w.Write([]byte("peer not trusted: " + err.Error()))
+				return
+			}
+		}
+		// The browser's TURN credentials come only with an answer, i.e. after pairing or approval;
+		// the page applies them to its next ICE gathering
+		if relay != nil {
+			if b, err = withBrowserCredentials(b, relay); err != nil {
+				result = metrics.OutcomeError
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		outcome(audit.KindAnswer, "")
+		result = metrics.OutcomeAnswered
 		w.Header().Set("Content-Type", "application/json")
 		_, _ = w.Write(b)
 	})
 
 	srv := &http.Server{Addr: addr, Handler: mux}
+	// Optional HTTPS (TLS_ENABLE=1 or TLS_CERT) with an HTTP redirect listener
+	tlsCfg, useTLS := cfg.TLS, cfg.TLS.Enabled()
+	var redirect *http.Server
+	if useTLS {
+		var err error
+		if srv.TLSConfig, err = tlsCfg.TLSConfig(); err != nil {
+			fatal("tls", "err", err)
+		}
+		if tlsCfg.CertFile == "" {
+			slog.Info("using self-signed certificate", "dir", tlsCfg.Dir, "sha256", tlscert.Fingerprint(srv.TLSConfig.Certificates[0]))
+		}
+		if tlsCfg.RedirectAddr != "" {
+			redirect = &http.Server{Addr: tlsCfg.RedirectAddr, Handler: tlscert.RedirectHandler(addr)}
+			go func() {
+				slog.Info("http redirect listening", "addr", tlsCfg.RedirectAddr)
+				if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					fatal("redirect ListenAndServe", "err", err)
+				}
+			}()
+		}
+	}
 
 	// Start periodic memory release loop; stop it when server is shutting down
 	done := make(chan struct{})
-	startPeriodicMemoryRelease(done)
+	startPeriodicMemoryRelease(cfg.CacheCleanInterval, done)
 
 	go func() {
-		log.Println("http server started on", addr)
+		if useTLS {
+			slog.Info("https server started", "addr", addr)
+			// The certificate comes from srv.TLSConfig
+			if err := srv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				fatal("ListenAndServeTLS", "err", err)
+			}
+			return
+		}
+		slog.Info("http server started", "addr", addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal("ListenAndServe:", err)
+			fatal("ListenAndServe", "err", err)
 		}
 	}()
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 	<-sigCh
-	log.Println("shutting down server...")
+	slog.Info("shutting down server")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Println("server shutdown error:", err)
+		slog.Error("server shutdown", "err", err)
+	}
+	if redirect != nil {
+		_ = redirect.Shutdown(ctx)
 	}
 	close(done)
 }
 
-// waitForPrefix reads UDP packets until one starting with the given prefix arrives, or timeout.
-func waitForPrefix(conn *net.UDPConn, prefix string, timeout time.Duration) (string, *net.UDPAddr, error) {
-	if conn == nil {
-		return "", nil, fmt.Errorf("no UDP conn")
+// withBrowserCredentials adds a fresh TURN credential for the browser to the
+// answer.
+func withBrowserCredentials(b []byte, relay *turnserver.Server) ([]byte, error) {
+	var answer signaling.Answer
+	if err := json.Unmarshal(b, &answer); err != nil {
+		return nil, fmt.Errorf("decode ANSWER: %w", err)
 	}
-	_ = conn.SetReadDeadline(time.Now().Add(timeout))
-	buf := make([]byte, 64*1024)
-	for {
-		n, addr, err := conn.ReadFromUDP(buf)
-		if err != nil {
-			return "", nil, err
-		}
-		msg := string(buf[:n])
-		if strings.HasPrefix(msg, prefix) {
-			return msg[len(prefix):], addr, nil
-		}
+	cred, err := relay.Credentials("browser")
+	if err != nil {
+		return nil, err
 	}
+	answer.ICEServers = append(answer.ICEServers, cred)
+	return json.Marshal(answer)
 }
 
 func main() {
-	addr := os.Getenv("ADDR")
-	if addr == "" {
-		addr = ":8080"
+	// Defaults < CONFIG_FILE (or -config) < environment < flags
+	cfg, err := config.LoadServer(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		_ = cfg.Print(os.Stdout)
+		return
 	}
-	runServer(addr)
+	// LOG_LEVEL, LOG_FORMAT and PION_LOG_LEVEL; pion's ICE/DTLS/SCTP/TURN logs go to the same log
+	logging.Setup(cfg.Log, os.Stderr)
+	runServer(cfg)
 }
@@ -0,0 +1,280 @@
+// Command viewer is a headless client: it connects through the server's
+// /signal endpoint like index.html, reassembles frames and can save
+// snapshots, record an MJPEG stream and play an input script. It exits
+// non-zero if no frame arrives, so it doubles as a health check.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"weblinuxgui/signaling"
+	"weblinuxgui/viewer"
+)
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "base URL of the signaling server")
+	connectTimeout := flag.Duration("timeout", 30*time.Second, "time allowed for signaling and opening the data channels")
+	snapDir := flag.String("snapshot-dir", "", "directory for periodic snapshots")
+	snapEvery := flag.Duration("snapshot-every", 0, "snapshot interval (0 disables periodic snapshots)")
+	format := flag.String("format", "png", "periodic snapshot format: png or jpg")
+	record := flag.String("record", "", "append every frame to this MJPEG file (play with ffplay -f mjpeg)")
+	script := flag.String("script", "", "input script to run once connected")
+	duration := flag.Duration("duration", 0, "stop after this long (0 = until the script ends or interrupted)")
+	frames := flag.Int("frames", 0, "stop after this many frames (e.g. 1 for a health check)")
+	viewport := flag.String("viewport", "", "ask the peer to scale frames down to fit WxH (e.g. 1280x720)")
+	caCert := flag.String("cacert", "", "PEM certificate to trust for an https server (e.g. its self-signed.crt)")
+	insecure := flag.Bool("insecure", false, "skip verification of the https server's certificate")
+	pairCode := flag.String("pair", "", "pairing code shown on the host")
+	tokenFile := flag.String("token-file", "", "device token from an earlier pairing; written after pairing with -pair")
+	showStats := flag.Bool("stats", false, "log the peer's stream statistics as they arrive")
+	flag.Parse()
+
+	var actions []viewer.Action
+	if *script != "" {
+		f, err := os.Open(*script)
+		if err != nil {
+			log.Fatalf("script: %v", err)
+		}
+		actions, err = viewer.ParseScript(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *format != "png" && *format != "jpg" {
+		usageError("-format must be png or jpg, got %q", *format)
+	}
+	if (*snapEvery > 0) != (*snapDir != "") {
+		usageError("-snapshot-every and -snapshot-dir must be set together")
+	}
+	var viewW, viewH int
+	if *viewport != "" {
+		if _, err := fmt.Sscanf(*viewport, "%dx%d", &viewW, &viewH); err != nil || viewW <= 0 || viewH <= 0 {
+			usageError("-viewport must be WxH, got %q", *viewport)
+		}
+	}
+
+	httpClient := &http.Client{Timeout: *connectTimeout + 5*time.Second}
+	if *caCert != "" || *insecure {
+		tlsCfg := &tls.Config{InsecureSkipVerify: *insecure}
+		if *caCert != "" {
+			pem, err := os.ReadFile(*caCert)
+			if err != nil {
+				log.Fatalf("cacert: %v", err)
+			}
+			tlsCfg.RootCAs = x509.NewCertPool()
+			if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+				log.Fatalf("cacert: no certificates in %s", *caCert)
+			}
+		}
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = tlsCfg
+		httpClient.Transport = tr
+	}
+	cfg, err := viewer.FetchConfig(httpClient, *server)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	exchange := viewer.HTTPExchange(httpClient, *server)
+	var token string
+	if *tokenFile != "" {
+		if b, err := os.ReadFile(*tokenFile); err == nil {
+			token = strings.TrimSpace(string(b))
+		} else if !os.IsNotExist(err) {
+			log.Fatalf("token file: %v", err)
+		}
+	}
+	c, err := viewer.Dial(cfg, newSessionID(), func(o signaling.Offer) (signaling.Answer, error) {
+		o.Code, o.DeviceToken = *pairCode, token
+		return exchange(o)
+	}, *connectTimeout)
+	if err != nil {
+		log.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+	log.Println("connected to", *server)
+	if c.DeviceToken != "" {
+		if *tokenFile == "" {
+			log.Println("paired; pass -token-file to keep the device token")
+		} else if err := os.WriteFile(*tokenFile, []byte(c.DeviceToken+"\n"), 0o600); err != nil {
+			log.Printf("write token file: %v", err)
+		} else {
+			log.Println("paired; device token saved to", *tokenFile)
+		}
+	}
+	if viewW > 0 {
+		if err := c.SetViewport(viewW, viewH, 0, 0, 0); err != nil {
+			log.Fatalf("viewport: %v", err)
+		}
+	}
+
+	var rec *os.File
+	if *record != "" {
+		if rec, err = os.OpenFile(*record, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+			log.Fatalf("record: %v", err)
+		}
+		defer rec.Close()
+	}
+
+	// latest is the most recent frame, used by periodic and scripted snapshots
+	var (
+		mu       sync.Mutex
+		latest   *viewer.Frame
+		received int
+	)
+	firstFrame := make(chan struct{})
+	enough := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case f := <-c.Frames:
+				if rec != nil {
+					data, err := f.JPEG()
+					if err == nil {
+						_, err = rec.Write(data)
+					}
+					if err != nil {
+						log.Println("record:", err)
+					}
+				}
+				mu.Lock()
+				latest = f
+				received++
+				n := received
+				mu.Unlock()
+				if n == 1 {
+					close(firstFrame)
+				}
+				if *frames > 0 && n == *frames {
+					close(enough)
+				}
+			case st := <-c.Stats:
+				if *showStats {
+					log.Printf("stats: %.1f fps, capture %.1fms, encode %.1fms, %d bytes/frame, %d bytes buffered, RTT %.1fms",
+						st.FPS, st.CaptureMs, st.EncodeMs, st.FrameBytes, st.BufferedBytes, st.RTTMs)
+				}
+			case err := <-c.Errors:
+				log.Println("frames:", err)
+			case <-c.Done():
+				return
+			}
+		}
+	}()
+	snapshot := func(path string) error {
+		mu.Lock()
+		f := latest
+		mu.Unlock()
+		if f == nil {
+			return fmt.Errorf("no frame received yet")
+		}
+		return f.Save(path)
+	}
+
+	select {
+	case <-firstFrame:
+	case <-c.Done():
+		log.Fatal("session ended before the first frame")
+	case <-time.After(*connectTimeout):
+		log.Fatalf("no frame within %s", *connectTimeout)
+	}
+
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	finish := func() { stopOnce.Do(func() { close(stop) }) }
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigCh:
+		case <-c.Done():
+			log.Println("session ended by peer")
+		case <-enough:
+		}
+		finish()
+	}()
+	if *duration > 0 {
+		time.AfterFunc(*duration, finish)
+	}
+
+	if *snapEvery > 0 {
+		go func() {
+			t := time.NewTicker(*snapEvery)
+			defer t.Stop()
+			for {
+				select {
+				case now := <-t.C:
+					name := filepath.Join(*snapDir, now.Format("20060102-150405.000")+"."+*format)
+					if err := snapshot(name); err != nil {
+						log.Println("snapshot:", err)
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	if *script != "" {
+		if err := run(c, actions, snapshot, stop); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Println("script finished")
+		if *duration == 0 && *frames == 0 {
+			finish()
+		}
+	}
+	<-stop
+	mu.Lock()
+	log.Printf("received %d frames", received)
+	mu.Unlock()
+}
+
+// run executes the script actions in order until stop is closed.
+// usageError reports a bad combination of flags and exits like flag does.
+func usageError(format string, a ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	flag.Usage()
+	os.Exit(2)
+}
+
+func run(c *viewer.Client, actions []viewer.Action, snapshot func(string) error, stop <-chan struct{}) error {
+	for _, a := range actions {
+		switch {
+		case a.Event != nil:
+			if err := c.SendInput(*a.Event); err != nil {
+				return fmt.Errorf("script line %d: send %s: %w", a.Line, a.Event.Type, err)
+			}
+		case a.Sleep > 0:
+			select {
+			case <-time.After(a.Sleep):
+			case <-stop:
+				return nil
+			}
+		case a.Snapshot != "":
+			if err := snapshot(a.Snapshot); err != nil {
+				return fmt.Errorf("script line %d: snapshot: %w", a.Line, err)
+			}
+		}
+	}
+	return nil
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,408 @@
+package config
+
+// Package config loads the settings of the server (client.go) and the
+// Windows peer (windows.go). Every setting can come from, in increasing
+// precedence:
+//
+//	defaults < config file < environment < command-line flags
+//
+// The config file is flat YAML named by -config or CONFIG_FILE. Its keys
+// are the environment variables in lower case, and the flags are the keys
+// with dashes, so UDP_PORT is udp_port in the file and -udp-port on the
+// command line:
+//
+//	# peer.yaml
+//	fps: 15
+//	codec: auto
+//	local_addr: 192.168.1.16
+//
+// Every value is checked as it is read and all problems are reported
+// together, each with where the value came from. -print-config prints the
+// effective configuration, and the source of each value, as YAML.
+//
+// The ICE, TURN, TLS, pinning, pairing, audit, recording, metrics and
+// logging settings are declared by their packages (see the setting
+// package) and loaded here with the rest; their FromEnv functions read the
+// same settings from the environment alone.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"weblinuxgui/audit"
+	"weblinuxgui/config/setting"
+	"weblinuxgui/logging"
+	"weblinuxgui/metrics"
+	"weblinuxgui/pairing"
+	"weblinuxgui/recording"
+	"weblinuxgui/rtcconfig"
+	"weblinuxgui/signaling"
+	"weblinuxgui/tlscert"
+	"weblinuxgui/turnserver"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UDP is the UDP signaling link between the server and the peer.
+type UDP struct {
+	Port          int
+	LocalhostOnly bool
+	// LocalAddr overrides the bind address; "ip" or "ip:port".
+	LocalAddr string
+}
+
+// BindAddr is the address to listen on: LocalAddr, with Port added when it
+// has none, or else Port on all interfaces (on 127.0.0.1 if LocalhostOnly).
+func (u UDP) BindAddr() string {
+	port := strconv.Itoa(u.Port)
+	switch {
+	case u.LocalAddr != "":
+		if _, _, err := net.SplitHostPort(u.LocalAddr); err == nil {
+			return u.LocalAddr
+		}
+		return net.JoinHostPort(u.LocalAddr, port)
+	case u.LocalhostOnly:
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return net.JoinHostPort("0.0.0.0", port)
+}
+
+// Server is the configuration of the signaling server.
+type Server struct {
+	Addr       string
+	UDP        UDP
+	PeerIP     string
+	RemoteAddr string
+	// CacheCleanInterval is how often memory is returned to the OS; 0 disables.
+	CacheCleanInterval time.Duration
+	// ICE is handed to the page by /config.
+	ICE  rtcconfig.Config
+	TURN turnserver.Config
+	TLS  tlscert.Config
+	// Pins are the peer certificates accepted in answers.
+	Pins  signaling.PinConfig
+	Audit audit.Config
+	// RecordDir is the peer's RECORD_DIR, shared or copied to this host,
+	// for playback. PlaybackToken admits other hosts than localhost to it.
+	RecordDir, PlaybackToken string
+	Log                      logging.Config
+	// PrintConfig is set by -print-config: print the configuration and exit.
+	PrintConfig bool
+
+	ice      rtcconfig.Options
+	settings []*setting.Setting
+}
+
+// PeerAddr is where offers are sent: RemoteAddr, or PeerIP on UDP.Port.
+func (c *Server) PeerAddr() string {
+	if c.RemoteAddr != "" {
+		return c.RemoteAddr
+	}
+	return net.JoinHostPort(c.PeerIP, strconv.Itoa(c.UDP.Port))
+}
+
+// Print writes the effective configuration as YAML.
+func (c *Server) Print(w io.Writer) error { return writeConfig(w, c.settings) }
+
+// LoadServer reads the server's configuration; args are the command-line
+// arguments without the program name. -help prints the settings and exits,
+// like an unknown flag, which exits with status 2.
+func LoadServer(args []string) (*Server, error) {
+	c := &Server{
+		Addr:               ":8080",
+		UDP:                UDP{Port: 8080},
+		PeerIP:             "192.168.1.16",
+		CacheCleanInterval: 5 * time.Minute,
+		TURN:               turnserver.Default(),
+		TLS:                tlscert.Default(),
+		Audit:              audit.Default(),
+		Log:                logging.Default(),
+	}
+	c.settings = []*setting.Setting{
+		{Env: "ADDR", Usage: "HTTP(S) listen address", Value: setting.Addr(&c.Addr, false)},
+		{Env: "UDP_PORT", Usage: "UDP signaling port", Value: setting.Int(&c.UDP.Port, 1, 65535)},
+		{Env: "PEER_IP", Usage: "IP address of the Windows peer", Value: setting.IP(&c.PeerIP)},
+		{Env: "REMOTE_ADDR", Usage: "peer address as ip:port, instead of PEER_IP and UDP_PORT", Value: setting.Addr(&c.RemoteAddr, false)},
+		{Env: "LOCAL_ADDR", Usage: "UDP bind address, ip or ip:port (default all interfaces)", Value: setting.Addr(&c.UDP.LocalAddr, true)},
+		{Env: "BIND_LOCALHOST_ONLY", Usage: "bind UDP to 127.0.0.1 only", Value: setting.Bool(&c.UDP.LocalhostOnly)},
+		{Env: "CACHE_CLEAN_INTERVAL", Usage: "how often memory is returned to the OS; 0 disables", Value: setting.Duration(&c.CacheCleanInterval, 0)},
+		{Env: "RECORD_DIR", Usage: "the peer's recordings, for playback", Value: setting.String(&c.RecordDir)},
+		{Env: "PLAYBACK_TOKEN", Usage: "token that admits other hosts than localhost to the recordings", Value: setting.Secret(&c.PlaybackToken)},
+	}
+	c.settings = slices.Concat(c.settings, c.ice.Settings(), c.TURN.Settings(), c.TLS.Settings(), c.Pins.Settings(), c.Audit.Settings(), c.Log.Settings())
+	var err error
+	c.PrintConfig, err = load("server", args, c.settings, func() error {
+		var err error
+		c.ICE, err = c.ice.Config()
+		if err != nil {
+			err = fmt.Errorf("ICE: %w", err)
+		}
+		if c.TLS.Enabled() {
+			err = errors.Join(err, c.TLS.Check())
+		}
+		return errors.Join(err, c.Pins.Check())
+	})
+	return c, err
+}
+
+// Peer is the configuration of the Windows peer.
+type Peer struct {
+	FPS, Quality, Display int
+	UDP                   UDP
+	// CacheCleanInterval is how often memory is returned to the OS; 0 disables.
+	CacheCleanInterval time.Duration
+	// DTLSCert is the file keeping the DTLS certificate; "none" makes a new
+	// one per session.
+	DTLSCert         string
+	ReconnectTimeout time.Duration
+	Capture          string
+	// CursorRate is how often the cursor position is sent; 0 disables.
+	CursorRate      int
+	Codec, Lossless string
+	Settle          time.Duration
+	// Encoders is how many frames are encoded in parallel; 0 picks one per
+	// CPU, at most 4.
+	Encoders, EncodeBands int
+	InputDryRun           bool
+	Signaling             string
+	Approval              string
+	ApprovalTimeout       time.Duration
+	// OfferFile and AnswerFile replace stdin and stdout in manual signaling.
+	OfferFile, AnswerFile string
+	QRTerminal            bool
+	ICE                   rtcconfig.Config
+	Pairing               pairing.Config
+	// Audit.Keys also applies to Recording.
+	Audit     audit.Config
+	Recording recording.Config
+	// MetricsAddr is the /metrics listener; empty disables it.
+	MetricsAddr string
+	Log         logging.Config
+	// PrintConfig is set by -print-config: print the configuration and exit.
+	PrintConfig bool
+
+	ice      rtcconfig.Options
+	settings []*setting.Setting
+}
+
+// Print writes the effective configuration as YAML.
+func (c *Peer) Print(w io.Writer) error { return writeConfig(w, c.settings) }
+
+// LoadPeer reads the peer's configuration like LoadServer.
+func LoadPeer(args []string) (*Peer, error) {
+	c := &Peer{
+		FPS:              10,
+		Quality:          80,
+		UDP:              UDP{Port: 8080},
+		DTLSCert:         rtcconfig.DefaultCertificatePath(),
+		ReconnectTimeout: 2 * time.Minute,
+		Capture:          "screenshot",
+		CursorRate:       60,
+		Codec:            "jpeg",
+		Lossless:         "png",
+		Settle:           500 * time.Millisecond,
+		EncodeBands:      1,
+		Signaling:        "udp",
+		Approval:         "off",
+		ApprovalTimeout:  30 * time.Second,
+		// The screenshot backend allocates a new image per frame
+		CacheCleanInterval: 5 * time.Minute,
+		Pairing:            pairing.Default(),
+		Audit:              audit.Default(),
+		Recording:          recording.Default(),
+		Log:                logging.Default(),
+	}
+	c.settings = []*setting.Setting{
+		{Env: "FPS", Usage: "frames captured per second", Value: setting.Int(&c.FPS, 1, 120)},
+		{Env: "QUALITY", Usage: "JPEG quality", Value: setting.Int(&c.Quality, 1, 100)},
+		{Env: "DISPLAY_INDEX", Usage: "display to stream, 0 is the primary", Value: setting.Int(&c.Display, 0, math.MaxInt)},
+		{Env: "CAPTURE", Usage: "capture backend", Value: setting.Choice(&c.Capture, "screenshot", "x11shm", "x11", "synthetic")},
+		{Env: "CODEC", Usage: "frame format", Value: setting.Choice(&c.Codec, "jpeg", "jpg", "png", "qoi", "auto")},
+		{Env: "LOSSLESS", Usage: "format of lossless frames", Value: setting.Choice(&c.Lossless, "png", "qoi")},
+		{Env: "SETTLE", Usage: "how long the screen is still before CODEC=auto sends a lossless frame", Value: setting.Duration(&c.Settle, 1)},
+		{Env: "CURSOR_RATE", Usage: "cursor positions sent per second; 0 turns cursor messages off and the page draws a plain arrow", Value: setting.Int(&c.CursorRate, 0, 1000)},
+		{Env: "ENCODERS", Usage: "frames encoded in parallel; 0 is one per CPU, at most 4", Value: setting.Int(&c.Encoders, 0, 64)},
+		{Env: "ENCODE_BANDS", Usage: "bands each frame is split into for encoding", Value: setting.Int(&c.EncodeBands, 1, 64)},
+		{Env: "SIGNALING", Usage: "udp, or manual for copy/paste", Value: setting.Choice(&c.Signaling, "udp", "manual")},
+		{Env: "UDP_PORT", Usage: "UDP signaling port", Value: setting.Int(&c.UDP.Port, 1, 65535)},
+		{Env: "LOCAL_ADDR", Usage: "UDP bind address, ip or ip:port (default all interfaces)", Value: setting.Addr(&c.UDP.LocalAddr, true)},
+		{Env: "BIND_LOCALHOST_ONLY", Usage: "bind UDP to 127.0.0.1 only", Value: setting.Bool(&c.UDP.LocalhostOnly)},
+		{Env: "OFFER_FILE", Usage: "read the manual offer from this file instead of stdin", Value: setting.String(&c.OfferFile)},
+		{Env: "ANSWER_FILE", Usage: "also write the manual answer to this file", Value: setting.String(&c.AnswerFile)},
+		{Env: "QR_TERMINAL", Usage: "also print the manual answer as a QR code", Value: setting.Bool(&c.QRTerminal)},
+		{Env: "APPROVAL", Usage: "ask before a browser connects", Value: setting.Choice(&c.Approval, "off", "none", "console", "desktop")},
+		{Env: "APPROVAL_TIMEOUT", Usage: "how long an approval prompt waits", Value: setting.Duration(&c.ApprovalTimeout, 1)},
+		{Env: "RECONNECT_TIMEOUT", Usage: "how long a failed session waits for an ICE restart", Value: setting.Duration(&c.ReconnectTimeout, 1)},
+		{Env: "DTLS_CERT", Usage: "file keeping the DTLS certificate; none for a new one per session", Value: setting.String(&c.DTLSCert)},
+		{Env: "INPUT_DRY_RUN", Usage: "print input instead of injecting it", Value: setting.Bool(&c.InputDryRun)},
+		{Env: "CACHE_CLEAN_INTERVAL", Usage: "how often memory is returned to the OS; 0 disables", Value: setting.Duration(&c.CacheCleanInterval, 0)},
+		metrics.AddrSetting(&c.MetricsAddr),
+	}
+	c.settings = slices.Concat(c.settings, c.ice.Settings(), c.Pairing.Settings(), c.Audit.Settings(), c.Recording.Settings(), c.Log.Settings())
+	var err error
+	c.PrintConfig, err = load("peer", args, c.settings, func() error {
+		c.Recording.Keys = c.Audit.Keys
+		var err error
+		if c.ICE, err = c.ice.Config(); err != nil {
+			return fmt.Errorf("ICE: %w", err)
+		}
+		return nil
+	})
+	return c, err
+}
+
+// load applies the config file, the environment and the flags in args to
+// settings, which hold the defaults, and then runs check for the problems
+// that involve several settings. It reports whether -print-config was
+// given.
+func load(name string, args []string, settings []*setting.Setting, check func() error) (bool, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	path := fs.String("config", "", "")
+	printConfig := fs.Bool("print-config", false, "")
+	flags := map[string]*setting.Setting{}
+	for _, s := range settings {
+		flags[s.Flag()] = s
+		fs.Var(&rawFlag{bool: setting.IsBool(s.Value)}, s.Flag(), s.Usage)
+	}
+	fs.Usage = func() { usage(fs.Output(), name, settings) }
+	_ = fs.Parse(args) // exits on errors
+	if fs.NArg() > 0 {
+		return false, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	var errs []error
+	if *path == "" {
+		*path = os.Getenv("CONFIG_FILE")
+	}
+	if *path != "" {
+		if err := loadFile(*path, settings); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := setting.FromEnv(settings...); err != nil {
+		errs = append(errs, err)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if s, ok := flags[f.Name]; ok {
+			if err := s.Set(f.Value.String(), "flag -"+f.Name); err != nil {
+				errs = append(errs, fmt.Errorf("flag -%s: %w", f.Name, err))
+			}
+		}
+	})
+	if err := check(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return false, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return *printConfig, nil
+}
+
+// loadFile applies a flat YAML mapping of setting keys to values. Keys are
+// matched case-insensitively, with dashes or underscores.
+func loadFile(path string, settings []*setting.Setting) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil // empty file
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: want a mapping of settings, like \"fps: 15\"", path, root.Line)
+	}
+	keys := map[string]*setting.Setting{}
+	for _, s := range settings {
+		keys[s.Key()] = s
+	}
+	var errs []error
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		at := fmt.Sprintf("%s:%d", path, k.Line)
+		key := strings.ToLower(strings.ReplaceAll(k.Value, "-", "_"))
+		s, ok := keys[key]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", at, k.Value))
+		case seen[key]:
+			errs = append(errs, fmt.Errorf("%s: %s is set twice", at, key))
+		case v.Kind != yaml.ScalarNode:
+			errs = append(errs, fmt.Errorf("%s: %s: want a single value", at, key))
+		default:
+			value := v.Value
+			if v.Tag == "!!null" {
+				value = ""
+			}
+			if err := s.Set(value, at); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", at, key, err))
+			}
+		}
+		seen[key] = true
+	}
+	return errors.Join(errs...)
+}
+
+// writeConfig writes settings as YAML with the source of each value, so the
+// output can be saved as a config file.
+func writeConfig(w io.Writer, settings []*setting.Setting) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "# effective configuration: defaults < config file < environment < flags")
+	for _, s := range settings {
+		if h, ok := s.Value.(setting.Hidden); ok && h.Hidden() {
+			// Commented out: saved output keeps the secret where it came from
+			fmt.Fprintf(tw, "# %s: (hidden)\t# %s\n", s.Key(), s.Source)
+			continue
+		}
+		v := s.Value.String()
+		if _, quote := s.Value.(setting.Quoted); quote {
+			v = strconv.Quote(v)
+		}
+		source := s.Source
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(tw, "%s: %s\t# %s\n", s.Key(), v, source)
+	}
+	return tw.Flush()
+}
+
+// usage lists the flags with their environment variables and defaults.
+func usage(w io.Writer, name string, settings []*setting.Setting) {
+	fmt.Fprintf(w, "Usage of the %s:\n", name)
+	fmt.Fprintf(w, "  -config file\n    \tYAML config file (env CONFIG_FILE)\n")
+	fmt.Fprintf(w, "  -print-config\n    \tprint the effective configuration and exit\n")
+	for _, s := range settings {
+		kind := " " + s.Value.Kind()
+		if setting.IsBool(s.Value) {
+			kind = ""
+		}
+		fmt.Fprintf(w, "  -%s%s\n    \t%s (env %s, default %s)\n", s.Flag(), kind, s.Usage, s.Env, s.Value.String())
+	}
+}
+
+// rawFlag keeps a flag's text; it is parsed after the file and the
+// environment so that flags win.
+type rawFlag struct {
+	v    string
+	bool bool
+}
+
+func (f *rawFlag) String() string     { return f.v }
+func (f *rawFlag) Set(v string) error { f.v = v; return nil }
+func (f *rawFlag) IsBoolFlag() bool   { return f.bool }
@@ -0,0 +1,229 @@
+package config_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"weblinuxgui/config"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "peer.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	c, err := config.LoadServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != ":8080" || c.UDP.BindAddr() != "0.0.0.0:8080" || c.PeerAddr() != "192.168.1.16:8080" || c.CacheCleanInterval != 5*time.Minute {
+		t.Fatalf("defaults = %+v", c)
+	}
+	p, err := config.LoadPeer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CacheCleanInterval != 5*time.Minute {
+		t.Errorf("peer CACHE_CLEAN_INTERVAL = %v, want 5m", p.CacheCleanInterval)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeFile(t, "fps: 15\nquality: 70\nCodec: AUTO\nlocal-addr: 10.0.0.2\nsettle: 1s\n")
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("QUALITY", "60")
+	t.Setenv("SETTLE", "2s")
+	c, err := config.LoadPeer([]string{"-settle", "3s", "-input-dry-run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FPS != 15 || c.Codec != "auto" {
+		t.Errorf("file not applied: fps %d, codec %q", c.FPS, c.Codec)
+	}
+	if c.Quality != 60 {
+		t.Errorf("quality = %d, want 60 from the environment over the file", c.Quality)
+	}
+	if c.Settle != 3*time.Second || !c.InputDryRun {
+		t.Errorf("settle = %v, dry run %v; want the flags over the environment", c.Settle, c.InputDryRun)
+	}
+	if got := c.UDP.BindAddr(); got != "10.0.0.2:8080" {
+		t.Errorf("bind address = %q", got)
+	}
+	var out bytes.Buffer
+	if err := c.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"fps: 15", "# " + path + ":1",
+		"quality: 60", "# env QUALITY",
+		`settle: "3s"`, "# flag -settle",
+		"encode_bands: 1", "# default",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("printed configuration lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestPrintConfigFlag(t *testing.T) {
+	c, err := config.LoadServer([]string{"-print-config", "-addr", "127.0.0.1:9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.PrintConfig || c.Addr != "127.0.0.1:9000" {
+		t.Fatalf("PrintConfig %v, addr %q", c.PrintConfig, c.Addr)
+	}
+}
+
+func TestInvalidValues(t *testing.T) {
+	path := writeFile(t, "fps: 0\nfsp: 3\nquality: 50\nquality: 60\nencoders: [1, 2]\n")
+	t.Setenv("CODEC", "webp")
+	t.Setenv("BIND_LOCALHOST_ONLY", "maybe")
+	t.Setenv("APPROVAL_TIMEOUT", "-1s")
+	_, err := config.LoadPeer([]string{"-config", path, "-udp-port", "70000", "-local-addr", "1.2.3.4:x"})
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, want := range []string{
+		path + ":1: fps: 0 is below the minimum of 1",
+		path + `:2: unknown setting "fsp"`,
+		path + ":4: quality is set twice",
+		path + ":5: encoders: want a single value",
+		`env CODEC: "webp" is not one of jpeg, jpg, png, qoi, auto`,
+		`env BIND_LOCALHOST_ONLY: "maybe" is not a boolean`,
+		`env APPROVAL_TIMEOUT: "-1s" must be positive`,
+		"flag -udp-port: 70000 is above the maximum of 65535",
+		`flag -local-addr: "1.2.3.4:x" has an invalid port`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestMissingFile(t *testing.T) {
+	if _, err := config.LoadServer([]string{"-config", filepath.Join(t.TempDir(), "none.yaml")}); err == nil {
+		t.Fatal("missing config file accepted")
+	}
+}
+
+func TestUnexpectedArgument(t *testing.T) {
+	if _, err := config.LoadServer([]string{"extra"}); err == nil {
+		t.Fatal("positional argument accepted")
+	}
+}
+
+func TestSubsystemSettings(t *testing.T) {
+	path := writeFile(t, "audit_dir: /var/log/audit\nrecord_dir: /var/rec\nice_servers: stun:a.test:3478\n")
+	t.Setenv("AUDIT_KEYS", "redact")
+	t.Setenv("LOG_FORMAT", "json")
+	c, err := config.LoadPeer([]string{"-config", path, "-metrics-addr", ":9100", "-pairing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Audit.Enabled() || !c.Recording.Enabled() || c.Recording.Keys != "redact" {
+		t.Errorf("audit %+v, recording %+v; want both on with the audit keys", c.Audit, c.Recording)
+	}
+	if c.MetricsAddr != ":9100" || !c.Pairing.Enable || !c.Log.JSON {
+		t.Errorf("metrics %q, pairing %v, JSON log %v", c.MetricsAddr, c.Pairing.Enable, c.Log.JSON)
+	}
+	if len(c.ICE.ICEServers) != 1 || c.ICE.ICEServers[0].URLs[0] != "stun:a.test:3478" {
+		t.Errorf("ICE servers = %+v", c.ICE.ICEServers)
+	}
+	var out bytes.Buffer
+	if err := c.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`audit_dir: "/var/log/audit"`, "# " + path + ":1",
+		`audit_keys: "redact"`, "# env AUDIT_KEYS",
+		`metrics_addr: ":9100"`, "# flag -metrics-addr",
+		`log_format: "json"`, `pion_log_level: "warn"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("printed configuration lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestServerSubsystemSettings(t *testing.T) {
+	t.Setenv("TURN_SECRET", "s3cret")
+	t.Setenv("PLAYBACK_TOKEN", "t0ken")
+	c, err := config.LoadServer([]string{"-turn-enable", "-tls-cert", "a.crt", "-tls-key", "a.key", "-peer-fingerprint", "sha-256 AB, sha-256 CD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.TURN.Enable || c.TURN.Secret != "s3cret" || c.PlaybackToken != "t0ken" {
+		t.Errorf("TURN %+v, playback token %q", c.TURN, c.PlaybackToken)
+	}
+	if !c.TLS.Enabled() || !c.Pins.Enabled() || len(c.Pins.Fingerprints) != 2 {
+		t.Errorf("TLS %+v, pins %+v", c.TLS, c.Pins)
+	}
+	var out bytes.Buffer
+	if err := c.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "s3cret") || strings.Contains(out.String(), "t0ken") {
+		t.Errorf("printed configuration shows a secret:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "# turn_secret: (hidden)") {
+		t.Errorf("printed configuration lacks the hidden TURN secret:\n%s", out.String())
+	}
+}
+
+func TestInvalidSubsystemValues(t *testing.T) {
+	path := writeFile(t, "turn_cred_ttl: 0s\n")
+	t.Setenv("AUDIT_FILE_MB", "lots")
+	t.Setenv("LOG_LEVEL", "loud")
+	t.Setenv("ICE_TRANSPORT_POLICY", "sideways")
+	_, err := config.LoadServer([]string{"-config", path, "-tls-cert", "a.crt", "-peer-enroll", "-turn-relay-port-range", "9-1"})
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, want := range []string{
+		path + `:1: turn_cred_ttl: "0s" must be positive`,
+		`env AUDIT_FILE_MB: "lots" is not a number of MB`,
+		`env LOG_LEVEL: unknown level "loud"`,
+		`flag -turn-relay-port-range: "9-1" is not a port range`,
+		`ICE: iceTransportPolicy "sideways": want all or relay`,
+		"TLS_CERT and TLS_KEY must be set together",
+		"PEER_ENROLL needs PEER_FINGERPRINT_FILE",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %q:\n%v", want, err)
+		}
+	}
+	// The peer has no TURN relay
+	if _, err := config.LoadPeer([]string{"-config", path}); err == nil || !strings.Contains(err.Error(), `unknown setting "turn_cred_ttl"`) {
+		t.Errorf("peer accepted turn_cred_ttl: %v", err)
+	}
+}
+
+func TestPrintedConfigLoads(t *testing.T) {
+	for _, load := range []func([]string) (interface{ Print(io.Writer) error }, error){
+		func(args []string) (interface{ Print(io.Writer) error }, error) { return config.LoadServer(args) },
+		func(args []string) (interface{ Print(io.Writer) error }, error) { return config.LoadPeer(args) },
+	} {
+		c, err := load(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := c.Print(&out); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := load([]string{"-config", writeFile(t, out.String())}); err != nil {
+			t.Errorf("printed configuration does not load: %v\n%s", err, out.String())
+		}
+	}
+}
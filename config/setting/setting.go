@@ -0,0 +1,344 @@
+package setting
+
+// Package setting describes one configurable value: its environment
+// variable, a usage line and a Value that parses and validates text into a
+// field. The config package reads settings from the config file, the
+// environment and flags; the packages that declare them (audit, tlscert,
+// ...) also read them from the environment alone with FromEnv.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Setting is one configurable value: its file key is Env in lower case and
+// its flag the key with dashes.
+type Setting struct {
+	Env   string
+	Usage string
+	Value Value
+	// Source is where the value came from, for printing; empty means the
+	// default.
+	Source string
+}
+
+// Key is the setting's config file key.
+func (s *Setting) Key() string { return strings.ToLower(s.Env) }
+
+// Flag is the setting's command-line flag, without the dash.
+func (s *Setting) Flag() string { return strings.ReplaceAll(s.Key(), "_", "-") }
+
+// Set parses v into the setting; from names the source for printing.
+func (s *Setting) Set(v, from string) error {
+	if err := s.Value.Set(strings.TrimSpace(v)); err != nil {
+		return err
+	}
+	s.Source = from
+	return nil
+}
+
+// FromEnv applies the environment variables that are set to settings and
+// reports every invalid one.
+func FromEnv(settings ...*Setting) error {
+	var errs []error
+	for _, s := range settings {
+		if v := os.Getenv(s.Env); v != "" {
+			if err := s.Set(v, "env "+s.Env); err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", s.Env, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Value parses and validates a setting into its field.
+type Value interface {
+	Set(string) error
+	String() string
+	// Kind names the type of value for -help, e.g. "int".
+	Kind() string
+}
+
+// Quoted is implemented by values that are printed as YAML strings.
+type Quoted interface{ Quoted() }
+
+// IsBool reports whether v is a boolean, which flags take without a value.
+func IsBool(v Value) bool {
+	_, ok := v.(boolValue)
+	return ok
+}
+
+// Int is a whole number from min to max.
+func Int(p *int, min, max int) Value { return intValue{p, min, max} }
+
+type intValue struct {
+	p        *int
+	min, max int
+}
+
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number", s)
+	}
+	switch {
+	case n < v.min:
+		return fmt.Errorf("%d is below the minimum of %d", n, v.min)
+	case n > v.max:
+		return fmt.Errorf("%d is above the maximum of %d", n, v.max)
+	}
+	*v.p = n
+	return nil
+}
+
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+func (intValue) Kind() string     { return "int" }
+
+// MB is a size given in megabytes and kept in bytes; 0 is allowed.
+func MB(p *int64) Value { return mbValue{p} }
+
+type mbValue struct{ p *int64 }
+
+func (v mbValue) Set(s string) error {
+	mb, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || mb < 0 || mb > 1<<40 {
+		return fmt.Errorf("%q is not a number of MB", s)
+	}
+	*v.p = mb << 20
+	return nil
+}
+
+func (v mbValue) String() string { return strconv.FormatInt(*v.p>>20, 10) }
+func (mbValue) Kind() string     { return "MB" }
+
+// Duration is at least min; a min of 1 asks for a positive duration.
+func Duration(p *time.Duration, min time.Duration) Value { return durationValue{p, min} }
+
+type durationValue struct {
+	p   *time.Duration
+	min time.Duration
+}
+
+func (v durationValue) Set(s string) error {
+	if s == "0" {
+		s = "0s"
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration like 30s or 5m", s)
+	}
+	if d < v.min {
+		if v.min == 1 {
+			return fmt.Errorf("%q must be positive", s)
+		}
+		return fmt.Errorf("%q is below the minimum of %s", s, v.min)
+	}
+	*v.p = d
+	return nil
+}
+
+func (v durationValue) String() string { return v.p.String() }
+func (durationValue) Kind() string     { return "duration" }
+func (durationValue) Quoted()          {}
+
+// Bool is true, false, yes, no, on, off, 1 or 0.
+func Bool(p *bool) Value { return boolValue{p} }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(s string) error {
+	switch strings.ToLower(s) {
+	case "1", "true", "yes", "on":
+		*v.p = true
+	case "0", "false", "no", "off":
+		*v.p = false
+	default:
+		return fmt.Errorf("%q is not a boolean (want true or false)", s)
+	}
+	return nil
+}
+
+func (v boolValue) String() string { return strconv.FormatBool(*v.p) }
+func (boolValue) Kind() string     { return "bool" }
+
+// String is any text.
+func String(p *string) Value { return stringValue{p} }
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+func (v stringValue) String() string     { return *v.p }
+func (stringValue) Kind() string         { return "string" }
+func (stringValue) Quoted()              {}
+
+// Secret is text that is not printed, like a password: String is empty
+// and Hidden reports whether it is set.
+func Secret(p *string) Value { return secretValue{p} }
+
+// Hidden is implemented by values that must not be printed.
+type Hidden interface{ Hidden() bool }
+
+type secretValue struct{ p *string }
+
+func (v secretValue) Set(s string) error { *v.p = s; return nil }
+func (v secretValue) String() string     { return "" }
+func (v secretValue) Hidden() bool       { return *v.p != "" }
+func (secretValue) Kind() string         { return "string" }
+func (secretValue) Quoted()              {}
+
+// List is comma-separated text; blank items are dropped.
+func List(p *[]string) Value { return listValue{p} }
+
+type listValue struct{ p *[]string }
+
+func (v listValue) Set(s string) error {
+	*v.p = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v.p = append(*v.p, item)
+		}
+	}
+	return nil
+}
+
+func (v listValue) String() string { return strings.Join(*v.p, ",") }
+func (listValue) Kind() string     { return "list" }
+func (listValue) Quoted()          {}
+
+// Choice is one of choices, in lower case.
+func Choice(p *string, choices ...string) Value { return choiceValue{p, choices} }
+
+type choiceValue struct {
+	p       *string
+	choices []string
+}
+
+func (v choiceValue) Set(s string) error {
+	s = strings.ToLower(s)
+	for _, c := range v.choices {
+		if s == c {
+			*v.p = s
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not one of %s", s, strings.Join(v.choices, ", "))
+}
+
+func (v choiceValue) String() string { return *v.p }
+func (choiceValue) Kind() string     { return "string" }
+func (choiceValue) Quoted()          {}
+
+// IP is an IP address.
+func IP(p *string) Value { return ipValue{p} }
+
+type ipValue struct{ p *string }
+
+func (v ipValue) Set(s string) error {
+	if net.ParseIP(s) == nil {
+		return fmt.Errorf("%q is not an IP address", s)
+	}
+	*v.p = s
+	return nil
+}
+
+func (v ipValue) String() string { return *v.p }
+func (ipValue) Kind() string     { return "ip" }
+func (ipValue) Quoted()          {}
+
+// NetIP is an IP address kept as a net.IP, or empty.
+func NetIP(p *net.IP) Value { return netIPValue{p} }
+
+type netIPValue struct{ p *net.IP }
+
+func (v netIPValue) Set(s string) error {
+	if s == "" {
+		*v.p = nil
+		return nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return fmt.Errorf("%q is not an IP address", s)
+	}
+	*v.p = ip
+	return nil
+}
+
+func (v netIPValue) String() string {
+	if *v.p == nil {
+		return ""
+	}
+	return v.p.String()
+}
+
+func (netIPValue) Kind() string { return "ip" }
+func (netIPValue) Quoted()      {}
+
+// Addr is host:port, or empty; with hostOnly the port may be left out.
+func Addr(p *string, hostOnly bool) Value { return addrValue{p, hostOnly} }
+
+type addrValue struct {
+	p        *string
+	hostOnly bool
+}
+
+func (v addrValue) Set(s string) error {
+	if s == "" {
+		*v.p = s
+		return nil
+	}
+	_, port, err := net.SplitHostPort(s)
+	if err != nil {
+		if !v.hostOnly || strings.Count(s, ":") == 1 {
+			return fmt.Errorf("%q is not a host:port address", s)
+		}
+		if net.ParseIP(s) == nil {
+			return fmt.Errorf("%q is not an IP address or ip:port", s)
+		}
+		*v.p = s
+		return nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%q has an invalid port", s)
+	}
+	*v.p = s
+	return nil
+}
+
+func (v addrValue) String() string { return *v.p }
+func (addrValue) Kind() string     { return "addr" }
+func (addrValue) Quoted()          {}
+
+// PortRange is MIN-MAX, kept in lo and hi; empty when both are 0.
+func PortRange(lo, hi *uint16) Value { return portRangeValue{lo, hi} }
+
+type portRangeValue struct{ lo, hi *uint16 }
+
+func (v portRangeValue) Set(s string) error {
+	if s == "" {
+		*v.lo, *v.hi = 0, 0
+		return nil
+	}
+	lo, hi, found := strings.Cut(s, "-")
+	pmin, err1 := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+	pmax, err2 := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+	if !found || err1 != nil || err2 != nil || pmin > pmax {
+		return fmt.Errorf("%q is not a port range like 50000-50100", s)
+	}
+	*v.lo, *v.hi = uint16(pmin), uint16(pmax)
+	return nil
+}
+
+func (v portRangeValue) String() string {
+	if *v.lo == 0 && *v.hi == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", *v.lo, *v.hi)
+}
+
+func (portRangeValue) Kind() string { return "MIN-MAX" }
+func (portRangeValue) Quoted()      {}
@@ -2,7 +2,11 @@ package input
 
 // Package input provides a tiny cross-platform abstraction over a few
 // keyboard/mouse operations we need. Each platform implements these in
-// separate files guarded by build tags.
+// separate files guarded by build tags. The package-level functions route
+// through the active Injector, which is the platform backend unless
+// replaced with SetInjector (e.g. by a Recorder for dry runs).
+
+import "sync"
 
 type Button string
 
@@ -12,24 +16,66 @@ const (
 	ButtonMiddle Button = "middle"
 )
 
+// Injector performs synthetic mouse and keyboard input.
+type Injector interface {
+	MoveMouse(x, y int)
+	Click(btn Button)
+	KeyDown(k string)
+	KeyUp(k string)
+	TypeString(s string)
+	Scroll(deltaY float64)
+}
+
+// Platform is the Injector backed by the OS for the current build.
+type Platform struct{}
+
+func (Platform) MoveMouse(x, y int)    { moveMouse(x, y) }
+func (Platform) Click(btn Button)      { click(btn) }
+func (Platform) KeyDown(k string)      { keyDown(k) }
+func (Platform) KeyUp(k string)        { keyUp(k) }
+func (Platform) TypeString(s string)   { typeString(s) }
+func (Platform) Scroll(deltaY float64) { scroll(deltaY) }
+
+var (
+	mu     sync.RWMutex
+	active Injector = Platform{}
+)
+
+// SetInjector replaces the active Injector; nil restores Platform.
+func SetInjector(in Injector) {
+	if in == nil {
+		in = Platform{}
+	}
+	mu.Lock()
+	active = in
+	mu.Unlock()
+}
+
+// Current returns the active Injector.
+func Current() Injector {
+	mu.RLock()
+	defer mu.RUnlock()
+	return active
+}
+
 // MoveMouse moves the cursor to absolute screen coordinates (x,y).
-func MoveMouse(x, y int) { moveMouse(x, y) }
+func MoveMouse(x, y int) { Current().MoveMouse(x, y) }
 
 // Click performs a mouse click with the given button.
-func Click(btn Button) { click(btn) }
+func Click(btn Button) { Current().Click(btn) }
 
 // GetMousePos returns the current cursor position.
 func GetMousePos() (x, y int) { return getMousePos() }
 
 // KeyDown presses a virtual key by name (best-effort mapping).
-func KeyDown(k string) { keyDown(k) }
+func KeyDown(k string) { Current().KeyDown(k) }
 
 // KeyUp releases a virtual key by name.
-func KeyUp(k string) { keyUp(k) }
+func KeyUp(k string) { Current().KeyUp(k) }
 
 // TypeString types text using synthetic keyboard events.
-func TypeString(s string) { typeString(s) }
+func TypeString(s string) { Current().TypeString(s) }
 
 // Scroll vertically by a delta measured in browser-style pixels.
 // Positive delta scrolls down, negative scrolls up.
-func Scroll(deltaY float64) { scroll(deltaY) }
+func Scroll(deltaY float64) { Current().Scroll(deltaY) }
@@ -0,0 +1,127 @@
+package input
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Call is one recorded Injector call.
+type Call struct {
+	Time   time.Time
+	Op     string // "move", "click", "keydown", "keyup", "type" or "scroll"
+	X, Y   int
+	Button Button
+	Key    string
+	Text   string
+	DeltaY float64
+}
+
+// String formats the call without its timestamp, e.g. "move 10,20" or
+// "keydown ctrl".
+func (c Call) String() string {
+	switch c.Op {
+	case "move":
+		return fmt.Sprintf("move %d,%d", c.X, c.Y)
+	case "click":
+		return "click " + string(c.Button)
+	case "keydown", "keyup":
+		return c.Op + " " + c.Key
+	case "type":
+		return "type " + strconv.Quote(c.Text)
+	case "scroll":
+		return "scroll " + strconv.FormatFloat(c.DeltaY, 'g', -1, 64)
+	}
+	return c.Op
+}
+
+// Recorder is an Injector that records every call instead of touching the
+// OS. If Out is set each call is also printed, which makes it usable as a
+// dry-run backend; if Next is set calls are forwarded after recording.
+type Recorder struct {
+	Out  io.Writer
+	Next Injector
+
+	mu      sync.Mutex
+	calls   []Call
+	discard bool // print only, for long-running dry runs
+}
+
+// NewRecorder returns a Recorder keeping every call for Calls and printing
+// to out (may be nil). The calls are kept until Reset, so it is meant for
+// tests.
+func NewRecorder(out io.Writer) *Recorder { return &Recorder{Out: out} }
+
+// NewPrinter returns a Recorder that prints every call to out and keeps
+// nothing; Calls is always empty.
+func NewPrinter(out io.Writer) *Recorder { return &Recorder{Out: out, discard: true} }
+
+func (r *Recorder) record(c Call) {
+	c.Time = time.Now()
+	r.mu.Lock()
+	if !r.discard {
+		r.calls = append(r.calls, c)
+	}
+	if r.Out != nil {
+		fmt.Fprintf(r.Out, "%s input %s\n", c.Time.Format("15:04:05.000"), c)
+	}
+	r.mu.Unlock()
+}
+
+// Calls returns a copy of the recorded calls.
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Reset drops the recorded calls.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.calls = nil
+	r.mu.Unlock()
+}
+
+func (r *Recorder) MoveMouse(x, y int) {
+	r.record(Call{Op: "move", X: x, Y: y})
+	if r.Next != nil {
+		r.Next.MoveMouse(x, y)
+	}
+}
+
+func (r *Recorder) Click(btn Button) {
+	r.record(Call{Op: "click", Button: btn})
+	if r.Next != nil {
+		r.Next.Click(btn)
+	}
+}
+
+func (r *Recorder) KeyDown(k string) {
+	r.record(Call{Op: "keydown", Key: k})
+	if r.Next != nil {
+		r.Next.KeyDown(k)
+	}
+}
+
+func (r *Recorder) KeyUp(k string) {
+	r.record(Call{Op: "keyup", Key: k})
+	if r.Next != nil {
+		r.Next.KeyUp(k)
+	}
+}
+
+func (r *Recorder) TypeString(s string) {
+	r.record(Call{Op: "type", Text: s})
+	if r.Next != nil {
+		r.Next.TypeString(s)
+	}
+}
+
+func (r *Recorder) Scroll(deltaY float64) {
+	r.record(Call{Op: "scroll", DeltaY: deltaY})
+	if r.Next != nil {
+		r.Next.Scroll(deltaY)
+	}
+}
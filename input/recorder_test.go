@@ -0,0 +1,43 @@
+package input_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"weblinuxgui/input"
+)
+
+func TestRecorderKeepsCalls(t *testing.T) {
+	r := input.NewRecorder(nil)
+	r.MoveMouse(10, 20)
+	r.KeyDown("ctrl")
+	r.TypeString(`say "hi"`)
+	var got []string
+	for _, c := range r.Calls() {
+		got = append(got, c.String())
+	}
+	if want := `move 10,20|keydown ctrl|type "say \"hi\""`; strings.Join(got, "|") != want {
+		t.Fatalf("calls = %q, want %q", strings.Join(got, "|"), want)
+	}
+	r.Reset()
+	if n := len(r.Calls()); n != 0 {
+		t.Fatalf("%d calls after Reset", n)
+	}
+}
+
+func TestPrinterKeepsNothing(t *testing.T) {
+	var out bytes.Buffer
+	r := input.NewPrinter(&out)
+	for i := range 1000 {
+		r.MoveMouse(i, i)
+	}
+	r.Click(input.ButtonLeft)
+	if n := len(r.Calls()); n != 0 {
+		t.Fatalf("printer kept %d calls", n)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1001 || !strings.HasSuffix(lines[1000], " input click left") {
+		t.Fatalf("printed %d lines, last %q", len(lines), lines[len(lines)-1])
+	}
+}
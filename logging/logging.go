@@ -0,0 +1,220 @@
+package logging
+
+// Package logging sets up the leveled, structured logger (log/slog) both
+// binaries write to, and bridges pion's loggers (ICE, DTLS, SCTP, TURN)
+// into it. Session-scoped lines carry a "session" attribute and pion lines
+// a "scope" attribute, so one session can be followed with e.g.
+//
+//	LOG_FORMAT=json ... | jq 'select(.session == "...")'
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"weblinuxgui/config/setting"
+
+	pionlogging "github.com/pion/logging"
+)
+
+// LevelTrace is below slog.LevelDebug, for pion's packet-level tracing.
+const LevelTrace = slog.LevelDebug - 4
+
+// Config selects the level and format of the log.
+type Config struct {
+	Level slog.Level
+	JSON  bool
+	// PionLevel is the least severe pion message logged (default warn);
+	// pion is chatty at info and below.
+	PionLevel slog.Level
+}
+
+// Default returns info level text logs, with pion at warn.
+func Default() Config {
+	return Config{Level: slog.LevelInfo, PionLevel: slog.LevelWarn}
+}
+
+// Settings lists the logging settings, bound to c.
+func (c *Config) Settings() []*setting.Setting {
+	return []*setting.Setting{
+		{Env: "LOG_LEVEL", Usage: "debug, info, warn or error", Value: levelValue{&c.Level}},
+		{Env: "LOG_FORMAT", Usage: "text or json", Value: formatValue{&c.JSON}},
+		{Env: "PION_LOG_LEVEL", Usage: "least severe pion ICE/DTLS/SCTP/TURN message logged: trace, debug, info, warn or error", Value: levelValue{&c.PionLevel}},
+	}
+}
+
+// FromEnv reads the logging configuration from the environment alone.
+func FromEnv() (Config, error) {
+	cfg := Default()
+	err := setting.FromEnv(cfg.Settings()...)
+	return cfg, err
+}
+
+type levelValue struct{ p *slog.Level }
+
+func (v levelValue) Set(s string) error {
+	l, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	*v.p = l
+	return nil
+}
+
+func (v levelValue) String() string {
+	if *v.p == LevelTrace {
+		return "trace"
+	}
+	return strings.ToLower(v.p.String())
+}
+
+func (levelValue) Kind() string { return "level" }
+func (levelValue) Quoted()      {}
+
+// formatValue is text or json, kept as whether the format is JSON.
+type formatValue struct{ p *bool }
+
+func (v formatValue) Set(s string) error {
+	switch strings.ToLower(s) {
+	case "text":
+		*v.p = false
+	case "json":
+		*v.p = true
+	default:
+		return fmt.Errorf("%q is not one of text, json", s)
+	}
+	return nil
+}
+
+func (v formatValue) String() string {
+	if *v.p {
+		return "json"
+	}
+	return "text"
+}
+
+func (formatValue) Kind() string { return "string" }
+func (formatValue) Quoted()      {}
+
+// ParseLevel parses trace, debug, info, warn(ing) or error.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "trace":
+		return LevelTrace, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown level %q (want trace, debug, info, warn or error)", s)
+}
+
+// Setup makes a logger writing to w the slog default and returns it. The
+// log package writes through it too, at info level.
+func Setup(cfg Config, w io.Writer) *slog.Logger {
+	// Pion's messages must get past the handler to be filtered by PionLevel
+	opts := &slog.HandlerOptions{Level: min(cfg.Level, cfg.PionLevel), ReplaceAttr: levelNames}
+	var h slog.Handler
+	if cfg.JSON {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	l := slog.New(&minLevel{Handler: h, level: cfg.Level})
+	slog.SetDefault(l)
+	return l
+}
+
+// Pion returns the factory for pion loggers writing to the default logger.
+func (c Config) Pion() PionFactory {
+	return PionFactory{Logger: slog.Default(), Level: c.PionLevel}
+}
+
+// levelNames prints LevelTrace as TRACE instead of DEBUG-4.
+func levelNames(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if l, ok := a.Value.Any().(slog.Level); ok && l <= LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+// minLevel applies Config.Level to everything but pion's records, which
+// PionFactory has filtered already.
+type minLevel struct {
+	slog.Handler
+	level slog.Level
+	pion  bool
+}
+
+func (h *minLevel) Enabled(ctx context.Context, l slog.Level) bool {
+	return (h.pion || l >= h.level) && h.Handler.Enabled(ctx, l)
+}
+
+func (h *minLevel) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &minLevel{Handler: h.Handler.WithAttrs(attrs), level: h.level, pion: h.pion}
+}
+
+func (h *minLevel) WithGroup(name string) slog.Handler {
+	return &minLevel{Handler: h.Handler.WithGroup(name), level: h.level, pion: h.pion}
+}
+
+// PionFactory is a pion logging.LoggerFactory writing to Logger. Each
+// pion scope ("ice", "dtls", "sctp", ...) becomes a "scope" attribute and
+// messages below Level are dropped. Add attributes, such as the session,
+// by replacing Logger with Logger.With(...).
+type PionFactory struct {
+	Logger *slog.Logger
+	Level  slog.Level
+}
+
+func (f PionFactory) NewLogger(scope string) pionlogging.LeveledLogger {
+	l := f.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	h := l.Handler()
+	if m, ok := h.(*minLevel); ok {
+		h = &minLevel{Handler: m.Handler, level: m.level, pion: true}
+	}
+	return pionLogger{l: slog.New(h).With("scope", scope), level: f.Level}
+}
+
+type pionLogger struct {
+	l     *slog.Logger
+	level slog.Level
+}
+
+func (p pionLogger) enabled(level slog.Level) bool {
+	return level >= p.level && p.l.Enabled(context.Background(), level)
+}
+
+func (p pionLogger) log(level slog.Level, msg string) {
+	if p.enabled(level) {
+		p.l.Log(context.Background(), level, msg)
+	}
+}
+
+func (p pionLogger) logf(level slog.Level, format string, args ...any) {
+	if p.enabled(level) {
+		p.l.Log(context.Background(), level, fmt.Sprintf(format, args...))
+	}
+}
+
+func (p pionLogger) Trace(msg string)                  { p.log(LevelTrace, msg) }
+func (p pionLogger) Tracef(format string, args ...any) { p.logf(LevelTrace, format, args...) }
+func (p pionLogger) Debug(msg string)                  { p.log(slog.LevelDebug, msg) }
+func (p pionLogger) Debugf(format string, args ...any) { p.logf(slog.LevelDebug, format, args...) }
+func (p pionLogger) Info(msg string)                   { p.log(slog.LevelInfo, msg) }
+func (p pionLogger) Infof(format string, args ...any)  { p.logf(slog.LevelInfo, format, args...) }
+func (p pionLogger) Warn(msg string)                   { p.log(slog.LevelWarn, msg) }
+func (p pionLogger) Warnf(format string, args ...any)  { p.logf(slog.LevelWarn, format, args...) }
+func (p pionLogger) Error(msg string)                  { p.log(slog.LevelError, msg) }
+func (p pionLogger) Errorf(format string, args ...any) { p.logf(slog.LevelError, format, args...) }
@@ -0,0 +1,266 @@
+package metrics
+
+// Package metrics exposes Prometheus counters and histograms for the peer's
+// frame stream and input and for the server's signaling. Both sides keep
+// their own registry:
+//
+//	p := metrics.NewPeer()     // frames, encoding, sessions, input, RTT
+//	s := metrics.NewServer()   // signaling requests by outcome
+//	mux.Handle("/metrics", s.Handler())
+//
+// The recording methods are safe on a nil *Peer or *Server, which discard
+// everything, so callers need not check whether metrics are enabled.
+
+import (
+	"net/http"
+	"time"
+
+	"weblinuxgui/config/setting"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "weblinuxgui"
+
+// Reasons a captured frame is not sent.
+const (
+	DropBusy       = "busy"       // every encoder slot was taken; the tick was skipped
+	DropSuperseded = "superseded" // the next frame was already encoded
+	DropEncode     = "encode"     // encoding failed
+	DropSend       = "send"       // the DataChannel refused it
+)
+
+// Signaling outcomes on the server.
+const (
+	OutcomeAnswered    = "answered"
+	OutcomeBadRequest  = "bad_request"
+	OutcomeUnavailable = "unavailable" // UDP signaling not configured
+	OutcomeRejected    = "rejected"    // the host refused the connection
+	OutcomeUnpaired    = "unpaired"    // no valid pairing code or device token
+	OutcomeUntrusted   = "untrusted"   // the answer's certificate is not pinned
+	OutcomeTimeout     = "timeout"     // no reply from the peer
+	OutcomeError       = "error"
+)
+
+// AddrSetting is METRICS_ADDR, the address of the peer's /metrics
+// listener, bound to p.
+func AddrSetting(p *string) *setting.Setting {
+	return &setting.Setting{Env: "METRICS_ADDR", Usage: "address of the /metrics listener, e.g. :9100 (default off)", Value: setting.Addr(p, false)}
+}
+
+// FromEnv reads METRICS_ADDR from the environment alone. ok is false when
+// it is not set.
+func FromEnv() (addr string, ok bool, err error) {
+	err = setting.FromEnv(AddrSetting(&addr))
+	return addr, addr != "", err
+}
+
+// sizeBuckets cover frame sizes from small PNG deltas to 4K lossless.
+var sizeBuckets = prometheus.ExponentialBuckets(4<<10, 2, 12) // 4 KiB .. 8 MiB
+
+// Peer holds the streaming side's metrics.
+type Peer struct {
+	reg *prometheus.Registry
+
+	framesCaptured prometheus.Counter
+	framesSent     *prometheus.CounterVec
+	framesDropped  *prometheus.CounterVec
+	keepalives     prometheus.Counter
+	encodeSeconds  *prometheus.HistogramVec
+	frameBytes     *prometheus.HistogramVec
+	buffered       prometheus.Gauge
+	rtt            prometheus.Gauge
+	packetLoss     prometheus.Gauge
+	inputEvents    *prometheus.CounterVec
+	sessions       prometheus.Gauge
+	sessionsTotal  prometheus.Counter
+}
+
+// NewPeer registers the peer metrics, along with the Go runtime and process
+// collectors, in a new registry.
+func NewPeer() *Peer {
+	m := &Peer{
+		reg: newRegistry(),
+		framesCaptured: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "frames_captured_total",
+			Help: "Screen captures taken, changed or not.",
+		}),
+		framesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "frames_sent_total",
+			Help: "Frames sent to the browser, by format.",
+		}, []string{"format"}),
+		framesDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "frames_dropped_total",
+			Help: "Frames not sent, by reason (busy, superseded, encode, send).",
+		}, []string{"reason"}),
+		keepalives: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "keepalives_sent_total",
+			Help: "Keepalives sent instead of unchanged frames.",
+		}),
+		encodeSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "frame_encode_seconds",
+			Help:    "Time to encode a frame, all bands included, by format.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 12), // 1ms .. 2s
+		}, []string{"format"}),
+		frameBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "frame_bytes",
+			Help:    "Encoded size of a sent frame before base64, by format.",
+			Buckets: sizeBuckets,
+		}, []string{"format"}),
+		buffered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "datachannel_buffered_bytes",
+			Help: "Bytes queued on the frames DataChannel.",
+		}),
+		rtt: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "rtt_seconds",
+			Help: "Latest round-trip time of the selected ICE candidate pair.",
+		}),
+		packetLoss: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "packet_loss_ratio",
+			Help: "Share of ICE connectivity checks without a response since the previous poll.",
+		}),
+		inputEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "input_events_total",
+			Help: "Input events received from the browser, by type.",
+		}, []string{"type"}),
+		sessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "sessions_active",
+			Help: "Browser sessions currently open.",
+		}),
+		sessionsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "sessions_total",
+			Help: "Browser sessions answered.",
+		}),
+	}
+	m.reg.MustRegister(m.framesCaptured, m.framesSent, m.framesDropped, m.keepalives,
+		m.encodeSeconds, m.frameBytes, m.buffered, m.rtt, m.packetLoss,
+		m.inputEvents, m.sessions, m.sessionsTotal)
+	return m
+}
+
+// Handler serves the peer metrics in the Prometheus text format.
+func (m *Peer) Handler() http.Handler { return handler(m.reg) }
+
+func (m *Peer) FrameCaptured() {
+	if m != nil {
+		m.framesCaptured.Inc()
+	}
+}
+
+// FrameSent counts a frame of n encoded bytes.
+func (m *Peer) FrameSent(format string, n int) {
+	if m != nil {
+		m.framesSent.WithLabelValues(format).Inc()
+		m.frameBytes.WithLabelValues(format).Observe(float64(n))
+	}
+}
+
+// FrameDropped counts a frame not sent; reason is one of the Drop constants.
+func (m *Peer) FrameDropped(reason string) {
+	if m != nil {
+		m.framesDropped.WithLabelValues(reason).Inc()
+	}
+}
+
+func (m *Peer) KeepaliveSent() {
+	if m != nil {
+		m.keepalives.Inc()
+	}
+}
+
+func (m *Peer) Encoded(format string, d time.Duration) {
+	if m != nil {
+		m.encodeSeconds.WithLabelValues(format).Observe(d.Seconds())
+	}
+}
+
+func (m *Peer) Buffered(n uint64) {
+	if m != nil {
+		m.buffered.Set(float64(n))
+	}
+}
+
+// Transport records the latest RTT and the connectivity-check loss ratio.
+func (m *Peer) Transport(rtt time.Duration, loss float64) {
+	if m != nil {
+		m.rtt.Set(rtt.Seconds())
+		m.packetLoss.Set(loss)
+	}
+}
+
+// Input counts a browser event; unknown types are counted as "other" so a
+// misbehaving browser cannot create new series.
+func (m *Peer) Input(typ string) {
+	if m == nil {
+		return
+	}
+	switch typ {
+	case "mousemove", "mousedown", "mouseup", "contextmenu", "wheel", "keydown", "keyup", "paste", "viewport":
+	default:
+		typ = "other"
+	}
+	m.inputEvents.WithLabelValues(typ).Inc()
+}
+
+func (m *Peer) SessionStarted() {
+	if m != nil {
+		m.sessions.Inc()
+		m.sessionsTotal.Inc()
+	}
+}
+
+func (m *Peer) SessionEnded() {
+	if m != nil {
+		m.sessions.Dec()
+	}
+}
+
+// Server holds the signaling server's metrics.
+type Server struct {
+	reg *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewServer registers the server metrics, along with the Go runtime and
+// process collectors, in a new registry.
+func NewServer() *Server {
+	m := &Server{
+		reg: newRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "signaling_requests_total",
+			Help: "Offers posted to /signal, by outcome.",
+		}, []string{"outcome"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "signaling_duration_seconds",
+			Help:    "Time from an offer to the response, approval prompts included, by outcome.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 13), // 10ms .. 40s
+		}, []string{"outcome"}),
+	}
+	m.reg.MustRegister(m.requests, m.duration)
+	return m
+}
+
+// Handler serves the server metrics in the Prometheus text format.
+func (m *Server) Handler() http.Handler { return handler(m.reg) }
+
+// Signal records a /signal request; outcome is one of the Outcome constants.
+func (m *Server) Signal(outcome string, d time.Duration) {
+	if m != nil {
+		m.requests.WithLabelValues(outcome).Inc()
+		m.duration.WithLabelValues(outcome).Observe(d.Seconds())
+	}
+}
+
+func newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return reg
+}
+
+func handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
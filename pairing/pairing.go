@@ -0,0 +1,351 @@
+package pairing
+
+// Package pairing admits browsers with a short one-time code shown on the
+// host instead of a shared password. A browser that pairs gets a device
+// token that admits it again later; only token hashes are stored.
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"weblinuxgui/config/setting"
+)
+
+// ErrUnpaired is wrapped by every refusal: no code, a wrong or expired
+// code, or an unknown token.
+var ErrUnpaired = errors.New("pairing required")
+
+const (
+	codeDigits = 6
+	// maxAttempts wrong codes from one address, or maxGlobalAttempts from
+	// all addresses together, lock code entry out (see Config.Lockout) and
+	// replace the code. Guessing is limited to a few tries per lockout, and
+	// the lockouts grow up to maxLockout.
+	maxAttempts       = 5
+	maxGlobalAttempts = 20
+	maxLockout        = time.Hour
+)
+
+// Config sets up a Pairer.
+type Config struct {
+	// Enable requires pairing; New ignores it.
+	Enable bool
+	// Rotate is how long a code is valid (default 5m). A code is also
+	// replaced once used.
+	Rotate time.Duration
+	// Store is the JSON file of paired devices; empty keeps them in memory.
+	Store string
+	// Lockout is how long codes are refused after too many wrong ones
+	// (default 1m). It doubles with every further lockout, up to an hour,
+	// until a browser pairs. Device tokens are still accepted meanwhile.
+	Lockout time.Duration
+}
+
+// Default returns the configuration with the default code lifetime and
+// the devices in <user config dir>/weblinuxgui/devices.json; pairing stays
+// off until Enable is set.
+func Default() Config {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		base = "."
+	}
+	return Config{Rotate: 5 * time.Minute, Store: filepath.Join(base, "weblinuxgui", "devices.json")}
+}
+
+// Settings lists the pairing settings, bound to c.
+func (c *Config) Settings() []*setting.Setting {
+	return []*setting.Setting{
+		{Env: "PAIRING", Usage: "require a pairing code or device token", Value: setting.Bool(&c.Enable)},
+		{Env: "PAIRING_ROTATE", Usage: "how long a pairing code is valid", Value: setting.Duration(&c.Rotate, 1)},
+		{Env: "PAIRING_STORE", Usage: "file of the paired devices", Value: setting.String(&c.Store)},
+	}
+}
+
+// FromEnv reads the pairing configuration from the environment alone. It
+// returns ok=false unless PAIRING is enabled.
+func FromEnv() (cfg Config, ok bool, err error) {
+	cfg = Default()
+	err = setting.FromEnv(cfg.Settings()...)
+	return cfg, cfg.Enable, err
+}
+
+// Device is a paired browser.
+type Device struct {
+	TokenHash string    `json:"tokenHash"` // hex SHA-256 of the token
+	Name      string    `json:"name,omitempty"`
+	Addr      string    `json:"addr,omitempty"`
+	Paired    time.Time `json:"paired"`
+	LastUsed  time.Time `json:"lastUsed"`
+}
+
+// Pairer holds the current code and the paired devices.
+type Pairer struct {
+	cfg    Config
+	onCode func(code string, expires time.Time)
+
+	mu      sync.Mutex
+	code    string
+	expires time.Time
+	timer   *time.Timer
+	devices []Device
+	closed  bool
+	// Wrong codes by address, and from all addresses together
+	failures map[string]*failures
+	global   failures
+}
+
+// failures counts the wrong codes from one source.
+type failures struct {
+	wrong int       // since the last lockout
+	locks int       // lockouts so far, for the backoff
+	until time.Time // end of the current lockout
+	last  time.Time // of the last wrong code
+}
+
+// fail counts a wrong code and reports whether it starts a lockout.
+func (f *failures) fail(now time.Time, limit int, lockout time.Duration) bool {
+	f.wrong++
+	f.last = now
+	if f.wrong < limit {
+		return false
+	}
+	f.wrong = 0
+	f.locks++
+	f.until = now.Add(min(lockout<<min(f.locks-1, 16), maxLockout))
+	return true
+}
+
+// New loads the paired devices and shows the first code. onCode is called
+// with every new code, from the goroutine that replaced it.
+func New(cfg Config, onCode func(code string, expires time.Time)) (*Pairer, error) {
+	if cfg.Rotate <= 0 {
+		cfg.Rotate = 5 * time.Minute
+	}
+	if cfg.Lockout <= 0 {
+		cfg.Lockout = time.Minute
+	}
+	p := &Pairer{cfg: cfg, onCode: onCode, failures: map[string]*failures{}}
+	if cfg.Store != "" {
+		b, err := os.ReadFile(cfg.Store)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("read paired devices: %w", err)
+		default:
+			if err := json.Unmarshal(b, &p.devices); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", cfg.Store, err)
+			}
+		}
+	}
+	p.mu.Lock()
+	code, expires, err := p.rotateLocked()
+	p.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	p.show(code, expires)
+	return p, nil
+}
+
+// Close stops replacing the code.
+func (p *Pairer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+}
+
+// Devices returns the paired devices.
+func (p *Pairer) Devices() []Device {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Device(nil), p.devices...)
+}
+
+// Verify admits a browser by device token or, failing that, by the current
+// code. A code is used up by a successful pairing, which returns the new
+// device token for the browser to keep. addr is the browser's address, for
+// the lockout after too many wrong codes.
+func (p *Pairer) Verify(code, token, name, addr string) (newToken string, err error) {
+	p.mu.Lock()
+	newToken, rotate, err := p.verifyLocked(normalize(code), token, name, addr)
+	var (
+		next    string
+		expires time.Time
+	)
+	if rotate {
+		next, expires, _ = p.rotateLocked()
+	}
+	p.mu.Unlock()
+	if next != "" {
+		p.show(next, expires)
+	}
+	return newToken, err
+}
+
+// verifyLocked implements Verify and reports whether the code must be
+// replaced.
+func (p *Pairer) verifyLocked(code, token, name, addr string) (newToken string, rotate bool, err error) {
+	if token != "" {
+		hash := hashToken(token)
+		for i := range p.devices {
+			if subtle.ConstantTimeCompare([]byte(p.devices[i].TokenHash), []byte(hash)) == 1 {
+				p.devices[i].LastUsed, p.devices[i].Addr = time.Now(), addr
+				if err := p.saveLocked(); err != nil {
+					// Only the last use is lost; the device stays admitted
+					slog.Warn("pairing: save last use", "err", err)
+				}
+				return "", false, nil
+			}
+		}
+	}
+	switch {
+	case code == "" && token != "":
+		return "", false, fmt.Errorf("%w: unknown device token", ErrUnpaired)
+	case code == "":
+		return "", false, fmt.Errorf("%w: enter the code shown on the host", ErrUnpaired)
+	}
+	now := time.Now()
+	f := p.failures[addr]
+	if f == nil {
+		f = &failures{}
+	}
+	if until := maxTime(f.until, p.global.until); now.Before(until) {
+		// Not even compared, so guesses during a lockout are worthless
+		return "", false, fmt.Errorf("%w: too many wrong codes, try again in %s", ErrUnpaired, max(until.Sub(now).Round(time.Second), time.Second))
+	}
+	if now.After(p.expires) || subtle.ConstantTimeCompare([]byte(code), []byte(p.code)) != 1 {
+		p.pruneLocked(now)
+		p.failures[addr] = f
+		locked := f.fail(now, maxAttempts, p.cfg.Lockout)
+		if p.global.fail(now, maxGlobalAttempts, p.cfg.Lockout) {
+			locked = true
+		}
+		if locked {
+			slog.Warn("pairing: too many wrong codes; locked out", "addr", addr, "for", maxTime(f.until, p.global.until).Sub(now))
+			return "", true, fmt.Errorf("%w: too many wrong codes, a new code is shown on the host", ErrUnpaired)
+		}
+		return "", false, fmt.Errorf("%w: wrong or expired code", ErrUnpaired)
+	}
+	delete(p.failures, addr)
+	p.global = failures{}
+	// The code is one-time
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", true, err
+	}
+	newToken = hex.EncodeToString(b)
+	p.devices = append(p.devices, Device{TokenHash: hashToken(newToken), Name: name, Addr: addr, Paired: now, LastUsed: now})
+	if err := p.saveLocked(); err != nil {
+		return "", true, err
+	}
+	return newToken, true, nil
+}
+
+// pruneLocked forgets addresses that have not sent a wrong code for longer
+// than the longest lockout.
+func (p *Pairer) pruneLocked(now time.Time) {
+	for addr, f := range p.failures {
+		if now.Sub(f.last) > maxLockout && now.After(f.until) {
+			delete(p.failures, addr)
+		}
+	}
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// rotateLocked picks a new code and schedules the next rotation.
+func (p *Pairer) rotateLocked() (string, time.Time, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("pairing code: %w", err)
+	}
+	p.code = fmt.Sprintf("%0*d", codeDigits, n)
+	p.expires = time.Now().Add(p.cfg.Rotate)
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if !p.closed {
+		p.timer = time.AfterFunc(p.cfg.Rotate, func() {
+			p.mu.Lock()
+			if p.closed {
+				p.mu.Unlock()
+				return
+			}
+			code, expires, err := p.rotateLocked()
+			p.mu.Unlock()
+			if err == nil {
+				p.show(code, expires)
+			}
+		})
+	}
+	return p.code, p.expires, nil
+}
+
+func (p *Pairer) show(code string, expires time.Time) {
+	if p.onCode != nil {
+		p.onCode(Format(code), expires)
+	}
+}
+
+// saveLocked writes the devices file atomically.
+func (p *Pairer) saveLocked() error {
+	if p.cfg.Store == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(p.devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.cfg.Store), 0o700); err != nil {
+		return fmt.Errorf("paired devices dir: %w", err)
+	}
+	tmp := p.cfg.Store + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("write paired devices: %w", err)
+	}
+	return os.Rename(tmp, p.cfg.Store)
+}
+
+// Format shows a code in two groups, e.g. "123 456".
+func Format(code string) string {
+	if len(code) != codeDigits {
+		return code
+	}
+	return code[:3] + " " + code[3:]
+}
+
+// normalize drops the separators people type into codes.
+func normalize(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, code)
+}
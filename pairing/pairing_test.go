@@ -0,0 +1,146 @@
+package pairing_test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"weblinuxgui/pairing"
+)
+
+// newPairer returns a Pairer and the channel of the codes it shows, without
+// separators.
+func newPairer(t *testing.T, cfg pairing.Config) (*pairing.Pairer, chan string) {
+	t.Helper()
+	codes := make(chan string, 64)
+	p, err := pairing.New(cfg, func(code string, _ time.Time) { codes <- strings.ReplaceAll(code, " ", "") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	return p, codes
+}
+
+// wrong returns a code that differs from code.
+func wrong(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestPairAndToken(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "devices.json")
+	p, codes := newPairer(t, pairing.Config{Store: store})
+	code := <-codes
+	token, err := p.Verify(code, "", "laptop", "10.0.0.1:1")
+	if err != nil || token == "" {
+		t.Fatalf("Verify(code) = %q, %v", token, err)
+	}
+	if next := <-codes; next == code {
+		t.Error("code not replaced after use")
+	}
+	if _, err := p.Verify(code, "", "", "10.0.0.1:1"); !errors.Is(err, pairing.ErrUnpaired) {
+		t.Errorf("used code: %v, want ErrUnpaired", err)
+	}
+	if _, err := p.Verify("", token, "", "10.0.0.2:1"); err != nil {
+		t.Errorf("device token: %v", err)
+	}
+	if _, err := p.Verify("", "bogus", "", ""); !errors.Is(err, pairing.ErrUnpaired) {
+		t.Errorf("unknown token: %v, want ErrUnpaired", err)
+	}
+
+	reloaded, _ := newPairer(t, pairing.Config{Store: store})
+	devs := reloaded.Devices()
+	if len(devs) != 1 || devs[0].Name != "laptop" || devs[0].Addr != "10.0.0.2:1" {
+		t.Fatalf("reloaded devices = %+v", devs)
+	}
+	if strings.Contains(devs[0].TokenHash, token) {
+		t.Error("token stored in the clear")
+	}
+}
+
+func TestCodeExpiry(t *testing.T) {
+	p, codes := newPairer(t, pairing.Config{Rotate: 50 * time.Millisecond})
+	code := <-codes
+	select {
+	case next := <-codes:
+		if next == code {
+			t.Skip("the new code happens to equal the old one")
+		}
+		if _, err := p.Verify(code, "", "", ""); !errors.Is(err, pairing.ErrUnpaired) {
+			t.Errorf("expired code: %v, want ErrUnpaired", err)
+		}
+		if _, err := p.Verify(next, "", "", ""); err != nil {
+			t.Errorf("new code: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("code not rotated")
+	}
+}
+
+func TestLockoutPerAddress(t *testing.T) {
+	p, codes := newPairer(t, pairing.Config{Lockout: 300 * time.Millisecond})
+	code := <-codes
+	for i := range 5 {
+		_, err := p.Verify(wrong(code), "", "", "a")
+		if !errors.Is(err, pairing.ErrUnpaired) {
+			t.Fatalf("wrong code %d: %v", i, err)
+		}
+	}
+	code = <-codes // replaced on the lockout
+	if _, err := p.Verify(code, "", "", "a"); err == nil || !strings.Contains(err.Error(), "try again") {
+		t.Fatalf("right code during the lockout: %v, want refused", err)
+	}
+	// Other addresses are not locked out
+	if _, err := p.Verify(code, "", "", "b"); err != nil {
+		t.Fatalf("other address: %v", err)
+	}
+	code = <-codes
+
+	time.Sleep(400 * time.Millisecond)
+	for range 5 {
+		p.Verify(wrong(code), "", "", "a")
+	}
+	code = <-codes
+	// The second lockout is twice as long
+	time.Sleep(400 * time.Millisecond)
+	if _, err := p.Verify(code, "", "", "a"); err == nil || !strings.Contains(err.Error(), "try again") {
+		t.Fatalf("right code during the second lockout: %v, want refused", err)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if _, err := p.Verify(code, "", "", "a"); err != nil {
+		t.Fatalf("after the lockout: %v", err)
+	}
+}
+
+func TestLockoutGlobal(t *testing.T) {
+	p, codes := newPairer(t, pairing.Config{Lockout: time.Minute})
+	code := <-codes
+	// Spread over addresses, staying under the per-address limit
+	for i := range 20 {
+		p.Verify(wrong(code), "", "", string(rune('a'+i/4)))
+	}
+	code = <-codes
+	if _, err := p.Verify(code, "", "", "fresh"); err == nil || !strings.Contains(err.Error(), "try again") {
+		t.Fatalf("right code during the global lockout: %v, want refused", err)
+	}
+}
+
+func TestTokensDuringLockout(t *testing.T) {
+	p, codes := newPairer(t, pairing.Config{Lockout: time.Minute})
+	code := <-codes
+	token, err := p.Verify(code, "", "", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code = <-codes
+	for range 5 {
+		p.Verify(wrong(code), "", "", "a")
+	}
+	if _, err := p.Verify("", token, "", "a"); err != nil {
+		t.Fatalf("device token during a lockout: %v", err)
+	}
+}
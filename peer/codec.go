@@ -0,0 +1,124 @@
+package peer
+
+import (
+	"bytes"
+	"fmt"
+	"hash/maphash"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"time"
+
+	"weblinuxgui/qoi"
+)
+
+// Frame formats, also the frameMeta "format" field.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatQOI  = "qoi"
+)
+
+// CodecAuto sends JPEG while the screen changes and one lossless refinement
+// frame once it has been still for Options.Settle.
+const CodecAuto = "auto"
+
+// ParseCodec validates a codec name ("" means JPEG).
+func ParseCodec(name string) (string, error) {
+	switch c := strings.ToLower(strings.TrimSpace(name)); c {
+	case "", "jpg", FormatJPEG:
+		return FormatJPEG, nil
+	case FormatPNG, FormatQOI, CodecAuto:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown codec %q (want jpeg, png, qoi or auto)", name)
+	}
+}
+
+// encodeImage appends img in the given format to buf.
+func encodeImage(buf *bytes.Buffer, img image.Image, format string, quality int) error {
+	switch format {
+	case FormatPNG:
+		enc := png.Encoder{CompressionLevel: png.BestSpeed}
+		return enc.Encode(buf, img)
+	case FormatQOI:
+		return qoi.Encode(buf, img)
+	default:
+		if quality <= 0 || quality > 100 {
+			quality = 80
+		}
+		return jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	}
+}
+
+// keepaliveEvery is how often an unchanged screen is confirmed to the
+// browser while the cursor does not move either.
+const keepaliveEvery = time.Second
+
+var pixelSeed = maphash.MakeSeed()
+
+// hashPixels identifies a captured frame; equal hashes mean the screen did
+// not change.
+func hashPixels(img *image.RGBA) uint64 {
+	return maphash.Bytes(pixelSeed, img.Pix)
+}
+
+// framePlanner decides what each capture turns into. A changed screen is
+// sent as a frame in the codec's format (JPEG in auto mode); an unchanged
+// one is not encoded at all but confirmed with a keepalive when the cursor
+// moved or keepaliveEvery passed. In auto mode a still screen also gets one
+// lossless refinement frame after settle. Planning is sequential; the
+// encoding itself runs in the pool.
+type framePlanner struct {
+	codec    string
+	lossless string
+	settle   time.Duration
+
+	started   bool   // a frame was planned since reset
+	hash      uint64 // of the last captured pixels
+	changedAt time.Time
+	refined   bool
+	sentAt    time.Time // of the last frame or keepalive
+	mx, my    int
+}
+
+func newFramePlanner(codec, lossless string, settle time.Duration) *framePlanner {
+	return &framePlanner{codec: codec, lossless: lossless, settle: settle}
+}
+
+// reset forgets the previous frame, e.g. for a new session or view.
+func (e *framePlanner) reset() {
+	e.started, e.refined = false, false
+}
+
+// plan returns the format to encode a capture with pixel hash h in,
+// keepalive=true to send a keepalive instead, or ok=false to send nothing.
+func (e *framePlanner) plan(h uint64, mx, my int, now time.Time) (format string, keepalive, ok bool) {
+	moved := mx != e.mx || my != e.my
+	e.mx, e.my = mx, my
+	switch {
+	case !e.started || h != e.hash:
+		e.started, e.hash = true, h
+		e.changedAt, e.refined = now, false
+		format = e.codec
+		if format == CodecAuto {
+			format = FormatJPEG
+		}
+	case e.codec == CodecAuto && !e.refined && now.Sub(e.changedAt) >= e.settle:
+		e.refined = true
+		format = e.lossless
+	case moved || now.Sub(e.sentAt) >= keepaliveEvery:
+		e.sentAt = now
+		return "", true, true
+	default:
+		return "", false, false
+	}
+	e.sentAt = now
+	return format, false, true
+}
+
+// idle is how long the screen has been unchanged.
+func (e *framePlanner) idle(now time.Time) time.Duration {
+	return now.Sub(e.changedAt)
+}
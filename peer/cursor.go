@@ -0,0 +1,85 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"math"
+	"time"
+
+	"weblinuxgui/capture"
+)
+
+// Shape polls are rarer than position polls; reading the cursor image costs
+// more than reading its position.
+const cursorShapeEvery = 4
+
+// streamCursor sends cursor position updates at CursorRate and the cursor
+// image whenever it changes, independently of the frame rate.
+func (p *Peer) streamCursor(stop <-chan struct{}) {
+	shaper, _ := p.opts.Capturer.(capture.CursorShaper)
+	ticker := time.NewTicker(time.Second / time.Duration(p.opts.CursorRate))
+	defer ticker.Stop()
+	var (
+		sess         *Session
+		shapeSent    bool
+		lastSerial   uint64
+		lastX, lastY int
+	)
+	for tick := 0; ; tick++ {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		s := p.current()
+		if s == nil {
+			continue
+		}
+		dc := s.frames()
+		if dc == nil {
+			continue
+		}
+		if s != sess {
+			// A new session needs the current shape and position
+			sess, shapeSent, lastX, lastY = s, false, math.MinInt, math.MinInt
+		}
+		if shaper != nil && (!shapeSent || tick%cursorShapeEvery == 0) {
+			shape, err := shaper.CursorShape()
+			switch {
+			case errors.Is(err, capture.ErrNoCursorShape):
+				// The page keeps drawing its own arrow
+				shaper = nil
+			case err != nil:
+				s.log.Warn("cursor shape", "err", err)
+			case !shapeSent || shape.Serial != lastSerial:
+				if err := dc.SendText(mustJSON(cursorMsg(shape))); err == nil {
+					shapeSent, lastSerial = true, shape.Serial
+				}
+			}
+		}
+		x, y := p.opts.Capturer.Cursor()
+		ox, oy := p.displayOffset()
+		x, y = s.transform().toFrame(x-ox, y-oy)
+		if x != lastX || y != lastY {
+			if err := dc.SendText(mustJSON(CursorPos{Type: "cursorPos", X: x, Y: y})); err == nil {
+				lastX, lastY = x, y
+			}
+		}
+	}
+}
+
+func cursorMsg(shape capture.CursorShape) CursorMsg {
+	msg := CursorMsg{Type: "cursor", ID: shape.Serial, HotX: shape.HotX, HotY: shape.HotY, Hidden: shape.Image == nil}
+	if shape.Image != nil {
+		var buf bytes.Buffer
+		enc := png.Encoder{CompressionLevel: png.BestSpeed}
+		if err := enc.Encode(&buf, shape.Image); err == nil {
+			msg.PNG = base64.StdEncoding.EncodeToString(buf.Bytes())
+		} else {
+			msg.Hidden = true
+		}
+	}
+	return msg
+}
@@ -0,0 +1,149 @@
+package peer
+
+import (
+	"encoding/json"
+)
+
+// Chunked transfer to respect SCTP/DC message size limits
+// Keep chunks small (<16KB) to be safe across browsers/OSes
+const chunkSize = 12 * 1024
+
+// textSender is the part of a DataChannel the frames path needs.
+type textSender interface {
+	SendText(s string) error
+}
+
+// FrameMeta announces a frame; Chunks frameChunk messages follow.
+type FrameMeta struct {
+	Type   string `json:"type"`
+	ID     int    `json:"id"`
+	Chunks int    `json:"chunks"`
+	MouseX int    `json:"mouseX"`
+	MouseY int    `json:"mouseY"`
+	// Format is FormatJPEG when empty (older peers).
+	Format string `json:"format,omitempty"`
+	// View is the display region the frame shows, scaled to the frame
+	// size. It is omitted when the frame is the whole display at full size.
+	View *ViewRect `json:"view,omitempty"`
+	// Band is set when the frame is split into horizontal bands.
+	Band *Band `json:"band,omitempty"`
+	// Captured is when the frame was captured, in milliseconds since the
+	// Unix epoch on the peer's clock (see Pong).
+	Captured int64 `json:"captured,omitempty"`
+}
+
+// Band places one part of a banded frame: frame ID is sent as Count
+// frameMeta+chunks groups in order, each an image to draw at Y of a
+// Width x Height frame. The frame is complete with band Count-1.
+type Band struct {
+	Index  int `json:"index"`
+	Count  int `json:"count"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// FrameChunk carries one slice of the base64 image of frame ID.
+type FrameChunk struct {
+	Type  string `json:"type"`
+	ID    int    `json:"id"`
+	Index int    `json:"index"`
+	Data  string `json:"data"`
+}
+
+// Keepalive replaces frames while the screen is unchanged. It is sent when
+// the cursor moves and at least once a second, so the browser can tell an
+// idle screen from a stalled stream.
+type Keepalive struct {
+	Type   string `json:"type"`
+	MouseX int    `json:"mouseX"`
+	MouseY int    `json:"mouseY"`
+	// IdleMs is how long the screen has been unchanged.
+	IdleMs int64 `json:"idleMs"`
+}
+
+// Stats reports how the stream performed since the previous report. It is
+// sent every Options.StatsInterval while a session streams; the averages
+// are over the frames sent in that time and zero when none were.
+type Stats struct {
+	Type       string  `json:"type"`
+	CaptureMs  float64 `json:"captureMs"` // grabbing and scaling a frame
+	EncodeMs   float64 `json:"encodeMs"`  // encoding it, all bands included
+	FrameBytes int     `json:"frameBytes"`
+	FPS        float64 `json:"fps"` // frames sent per second
+	// BufferedBytes is queued on the frames channel, not yet sent.
+	BufferedBytes uint64 `json:"bufferedBytes"`
+	// RTTMs is the round-trip time of the ICE candidate pair in use, zero
+	// until one is measured.
+	RTTMs float64 `json:"rttMs,omitempty"`
+}
+
+// Pong answers a "ping" input event. With the ping's round trip the
+// browser estimates the offset between its clock and the peer's and so
+// the latency of a frame from FrameMeta.Captured.
+type Pong struct {
+	Type string `json:"type"`
+	// Time is the ping's, echoed.
+	Time int64 `json:"time"`
+	// PeerTime is when the peer answered, in milliseconds since the Unix
+	// epoch.
+	PeerTime int64 `json:"peerTime"`
+}
+
+// CursorMsg carries the cursor image. It is sent when the shape changes
+// and when a session starts streaming.
+type CursorMsg struct {
+	Type   string `json:"type"`
+	ID     uint64 `json:"id"`
+	HotX   int    `json:"hotX"`
+	HotY   int    `json:"hotY"`
+	PNG    string `json:"png,omitempty"` // base64 PNG
+	Hidden bool   `json:"hidden,omitempty"`
+}
+
+// CursorPos moves the cursor between frames, in frame coordinates.
+type CursorPos struct {
+	Type string `json:"type"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+}
+
+// sendFrame sends the metadata followed by the chunks of one frame. Type
+// and Chunks of meta are filled in.
+func sendFrame(dc textSender, meta FrameMeta, b64 string) error {
+	nChunks := (len(b64) + chunkSize - 1) / chunkSize
+	id := meta.ID
+	// Send metadata first
+	meta.Type, meta.Chunks = "frameMeta", nChunks
+	if meta.Format == FormatJPEG {
+		meta.Format = ""
+	}
+	if err := dc.SendText(mustJSON(meta)); err != nil {
+		// If we fail to send meta, skip this frame
+		return err
+	}
+	// Send chunks
+	for i := 0; i < nChunks; i++ {
+		start := i * chunkSize
+		end := start + chunkSize
+		if end > len(b64) {
+			end = len(b64)
+		}
+		chunk := FrameChunk{Type: "frameChunk", ID: id, Index: i, Data: b64[start:end]}
+		// An unchanged screen is not sent again, so the caller must know
+		// the browser missed this frame
+		if err := dc.SendText(mustJSON(chunk)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mustJSON is a tiny helper to marshal or return an empty JSON object on error.
+func mustJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
@@ -0,0 +1,119 @@
+package peer
+
+import (
+	"strings"
+
+	"weblinuxgui/input"
+)
+
+// InputEvent mirrors the browser-sent event structure
+type InputEvent struct {
+	Type          string   `json:"type"`
+	Key           string   `json:"key"`
+	KeyCode       int      `json:"keyCode"`
+	Modifiers     []string `json:"modifiers"`
+	DeltaY        float64  `json:"deltaY"`
+	X             int      `json:"x"`
+	Y             int      `json:"y"`
+	Button        string   `json:"button"`
+	ClipboardText string   `json:"clipboardText"`
+	// Viewport messages: canvas size and zoom around X,Y (display coordinates)
+	Width  int     `json:"width,omitempty"`
+	Height int     `json:"height,omitempty"`
+	Zoom   float64 `json:"zoom,omitempty"`
+	// Ping messages: the browser's clock in milliseconds, echoed in a Pong
+	Time int64 `json:"time,omitempty"`
+}
+
+// handleInput injects a browser event. Coordinates refer to the frame; they
+// are mapped back through xf to the display and shifted by its origin for
+// multi-monitor setups.
+func (p *Peer) handleInput(ev InputEvent, xf transform) {
+	in := p.opts.Input
+	ev.X, ev.Y = xf.toDisplay(ev.X, ev.Y)
+	dispOffsetX, dispOffsetY := p.displayOffset()
+	switch ev.Type {
+	case "mousemove":
+		// Adjust for display origin in multi-monitor setups
+		in.MoveMouse(ev.X+dispOffsetX, ev.Y+dispOffsetY)
+	case "mousedown":
+		// Ensure cursor is at target before any press logic
+		in.MoveMouse(ev.X+dispOffsetX, ev.Y+dispOffsetY)
+	case "mouseup":
+		// Ensure cursor is at target even if no prior mousemove arrived
+		in.MoveMouse(ev.X+dispOffsetX, ev.Y+dispOffsetY)
+		in.Click(mapButton(ev.Button))
+	case "contextmenu":
+		// Right click at target location
+		in.MoveMouse(ev.X+dispOffsetX, ev.Y+dispOffsetY)
+		in.Click(input.ButtonRight)
+	case "wheel":
+		// Scroll at target location
+		in.MoveMouse(ev.X+dispOffsetX, ev.Y+dispOffsetY)
+		in.Scroll(ev.DeltaY)
+	case "keydown":
+		if key := normalizeKey(ev.Key); key != "" {
+			in.KeyDown(key)
+		}
+	case "keyup":
+		if key := normalizeKey(ev.Key); key != "" {
+			in.KeyUp(key)
+		}
+	case "paste":
+		if ev.ClipboardText != "" {
+			in.TypeString(ev.ClipboardText)
+		}
+	}
+}
+
+func mapButton(b string) input.Button {
+	switch strings.ToLower(b) {
+	case "left", "l":
+		return input.ButtonLeft
+	case "right", "r":
+		return input.ButtonRight
+	case "center", "middle", "m":
+		return input.ButtonMiddle
+	default:
+		return input.ButtonLeft
+	}
+}
+
+func normalizeKey(k string) string {
+	k = strings.ToLower(k)
+	switch k {
+	case "enter":
+		return "enter"
+	case "shift":
+		return "shift"
+	case "control", "ctrl":
+		return "ctrl"
+	case "alt", "option":
+		return "alt"
+	case "meta", "command", "cmd":
+		return "cmd"
+	case "escape", "esc":
+		return "esc"
+	case " ", "space":
+		return "space"
+	case "tab":
+		return "tab"
+	case "backspace":
+		return "backspace"
+	case "delete":
+		return "delete"
+	case "arrowup":
+		return "up"
+	case "arrowdown":
+		return "down"
+	case "arrowleft":
+		return "left"
+	case "arrowright":
+		return "right"
+	default:
+		if len(k) == 1 {
+			return k
+		}
+		return ""
+	}
+}
@@ -0,0 +1,930 @@
+package peer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"weblinuxgui/approval"
+	"weblinuxgui/audit"
+	"weblinuxgui/capture"
+	"weblinuxgui/input"
+	"weblinuxgui/logging"
+	"weblinuxgui/metrics"
+	"weblinuxgui/pairing"
+	"weblinuxgui/peer"
+	"weblinuxgui/rtcconfig"
+	"weblinuxgui/signaling"
+	"weblinuxgui/viewer"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var loopbackICE = rtcconfig.Config{IncludeLoopback: true}
+
+// startPeer serves a peer on a loopback UDP signaling socket. FPS, Quality
+// and ICE (except its certificates and logger) are filled in.
+func startPeer(t *testing.T, opts peer.Options) string {
+	t.Helper()
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	sig := signaling.NewConn(udp)
+	ice := loopbackICE
+	ice.Certificates, ice.LoggerFactory = opts.ICE.Certificates, opts.ICE.LoggerFactory
+	opts.FPS, opts.Quality, opts.ICE = 30, 90, ice
+	p := peer.New(opts)
+	stop := make(chan struct{})
+	go p.Stream(stop)
+	go p.ServeUDP(sig)
+	t.Cleanup(func() {
+		close(stop)
+		sig.Close()
+	})
+	return sig.LocalAddr().String()
+}
+
+func dial(t *testing.T, peerAddr string) *viewer.Client {
+	t.Helper()
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	sig := signaling.NewConn(udp)
+	t.Cleanup(func() { sig.Close() })
+	c, err := viewer.Dial(loopbackICE, "loopback-test", viewer.UDPExchange(sig, peerAddr, 10*time.Second), 10*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// waitFrame returns the first frame satisfying ok.
+func waitFrame(t *testing.T, c *viewer.Client, ok func(*viewer.Frame) bool) *viewer.Frame {
+	t.Helper()
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case f := <-c.Frames:
+			if ok(f) {
+				return f
+			}
+		case err := <-c.Errors:
+			t.Fatalf("frames: %v", err)
+		case <-deadline:
+			t.Fatal("no matching frame within 10s")
+		}
+	}
+}
+
+// near compares colors with a tolerance for JPEG artifacts.
+func near(got color.Color, want color.RGBA) bool {
+	r, g, b, _ := got.RGBA()
+	d := func(a uint32, b uint8) int { return int(a>>8) - int(b) }
+	const tol = 40
+	abs := func(v int) int {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	return abs(d(r, want.R)) <= tol && abs(d(g, want.G)) <= tol && abs(d(b, want.B)) <= tol
+}
+
+func TestLoopbackFrames(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	fill := image.Rect(0, 0, 80, 60)
+	src := capture.NewSynthetic(320, 240,
+		capture.Step{Frame: 10, Rect: fill, Color: red, MoveCursor: true, CursorX: 100, CursorY: 50},
+	)
+	c := dial(t, startPeer(t, peer.Options{Capturer: src, Input: input.NewRecorder(nil)}))
+
+	f := waitFrame(t, c, func(*viewer.Frame) bool { return true })
+	if got := f.Image.Bounds().Size(); got != image.Pt(320, 240) {
+		t.Fatalf("frame size = %v, want 320x240", got)
+	}
+	// Sample the middle of each of the eight 40px bars below the scripted fill.
+	bars := []color.RGBA{
+		{255, 255, 255, 255}, {255, 255, 0, 255}, {0, 255, 255, 255}, {0, 255, 0, 255},
+		{255, 0, 255, 255}, {255, 0, 0, 255}, {0, 0, 255, 255}, {0, 0, 0, 255},
+	}
+	for i, want := range bars {
+		if got := f.Image.At(i*40+20, 200); !near(got, want) {
+			t.Errorf("bar %d = %v, want %v", i, got, want)
+		}
+	}
+
+	f = waitFrame(t, c, func(f *viewer.Frame) bool { return near(f.Image.At(40, 30), red) })
+	if f.MouseX != 100 || f.MouseY != 50 {
+		t.Errorf("cursor = %d,%d, want 100,50", f.MouseX, f.MouseY)
+	}
+}
+
+func TestLoopbackLossless(t *testing.T) {
+	src := capture.NewSynthetic(320, 240)
+	c := dial(t, startPeer(t, peer.Options{
+		Capturer: src, Input: input.NewRecorder(nil),
+		Codec: peer.CodecAuto, Lossless: peer.FormatQOI, Settle: 100 * time.Millisecond,
+	}))
+
+	if f := waitFrame(t, c, func(*viewer.Frame) bool { return true }); f.Format != peer.FormatJPEG {
+		t.Errorf("first frame format = %q, want jpeg", f.Format)
+	}
+	// The still screen is refined to an exact copy.
+	f := waitFrame(t, c, func(f *viewer.Frame) bool { return f.Format == peer.FormatQOI })
+	want, err := src.Capture(image.Rect(0, 0, 320, 240))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameRGB(f.Image, want) {
+		t.Error("refined frame differs from the display")
+	}
+}
+
+func TestLoopbackBands(t *testing.T) {
+	src := capture.NewSynthetic(320, 240)
+	c := dial(t, startPeer(t, peer.Options{Capturer: src, Input: input.NewRecorder(nil), Codec: peer.FormatQOI, Bands: 3, Encoders: 2}))
+
+	// The viewer only returns a banded frame once every band is in place.
+	f := waitFrame(t, c, func(*viewer.Frame) bool { return true })
+	if f.Data != nil {
+		t.Error("banded frame has Data")
+	}
+	want, err := src.Capture(image.Rect(0, 0, 320, 240))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameRGB(f.Image, want) {
+		t.Error("banded frame differs from the display")
+	}
+}
+
+// sameRGB reports whether two images have identical color channels.
+func sameRGB(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+		for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestLoopbackIdle(t *testing.T) {
+	c := dial(t, startPeer(t, peer.Options{Capturer: capture.NewSynthetic(320, 240), Input: input.NewRecorder(nil)}))
+	waitFrame(t, c, func(*viewer.Frame) bool { return true })
+
+	// The pattern does not change: keepalives replace frames.
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case f := <-c.Frames:
+			t.Fatalf("unchanged screen sent frame %d", f.ID)
+		case k := <-c.Keepalives:
+			if k.IdleMs <= 0 {
+				t.Errorf("keepalive idle = %dms, want > 0", k.IdleMs)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no keepalive within 3s")
+		}
+	}
+}
+
+func TestLoopbackInput(t *testing.T) {
+	rec := input.NewRecorder(nil)
+	c := dial(t, startPeer(t, peer.Options{Capturer: capture.NewSynthetic(320, 240), Input: rec}))
+	// Frames flowing means the display offset is known.
+	waitFrame(t, c, func(*viewer.Frame) bool { return true })
+
+	events := []peer.InputEvent{
+		{Type: "mousemove", X: 10, Y: 20},
+		{Type: "mouseup", X: 30, Y: 40, Button: "right"},
+		{Type: "keydown", Key: "Control"},
+		{Type: "keyup", Key: "Control"},
+		{Type: "wheel", X: 5, Y: 6, DeltaY: -120},
+		{Type: "paste", ClipboardText: "hello"},
+	}
+	for _, ev := range events {
+		if err := c.SendInput(ev); err != nil {
+			t.Fatalf("send %s: %v", ev.Type, err)
+		}
+	}
+	want := []string{
+		"move 10,20",
+		"move 30,40", "click right",
+		"keydown ctrl",
+		"keyup ctrl",
+		"move 5,6", "scroll -120",
+		`type "hello"`,
+	}
+	if got := waitCalls(rec, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("input calls = %q, want %q", got, want)
+	}
+}
+
+// waitCalls waits up to 10s for n injected calls and returns them as strings.
+func waitCalls(rec *input.Recorder, n int) []string {
+	deadline := time.Now().Add(10 * time.Second)
+	for len(rec.Calls()) < n && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	var got []string
+	for _, c := range rec.Calls() {
+		got = append(got, c.String())
+	}
+	return got
+}
+
+func TestLoopbackViewport(t *testing.T) {
+	rec := input.NewRecorder(nil)
+	c := dial(t, startPeer(t, peer.Options{Capturer: capture.NewSynthetic(320, 240), Input: rec}))
+	if f := waitFrame(t, c, func(*viewer.Frame) bool { return true }); f.View != nil {
+		t.Errorf("full frame view = %+v, want none", f.View)
+	}
+
+	// A canvas half the display size gets half-size frames.
+	if err := c.SetViewport(160, 120, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	f := waitFrame(t, c, func(f *viewer.Frame) bool { return f.Image.Bounds().Dx() == 160 })
+	if got, want := f.View, (peer.ViewRect{W: 320, H: 240}); got == nil || *got != want {
+		t.Errorf("scaled view = %+v, want %+v", got, want)
+	}
+	if got, want := f.Image.At(5*20+10, 100), (color.RGBA{255, 0, 0, 255}); !near(got, want) {
+		t.Errorf("scaled bar 5 = %v, want %v", got, want)
+	}
+	if err := c.SendInput(peer.InputEvent{Type: "mousemove", X: 50, Y: 60}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Zoom 2x around 200,150: a 160x120 region at 120,90, not scaled.
+	if err := c.SetViewport(0, 0, 2, 200, 150); err != nil {
+		t.Fatal(err)
+	}
+	f = waitFrame(t, c, func(f *viewer.Frame) bool { return f.View != nil && f.View.X != 0 })
+	if got, want := *f.View, (peer.ViewRect{X: 120, Y: 90, W: 160, H: 120}); got != want {
+		t.Errorf("zoomed view = %+v, want %+v", got, want)
+	}
+	if got := f.Image.Bounds().Size(); got != image.Pt(160, 120) {
+		t.Errorf("zoomed frame size = %v, want 160x120", got)
+	}
+	if err := c.SendInput(peer.InputEvent{Type: "mousemove", X: 10, Y: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"move 101,121", "move 130,110"}
+	if got := waitCalls(rec, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("input calls = %q, want %q", got, want)
+	}
+}
+
+func TestLoopbackRestartKeepsState(t *testing.T) {
+	rec := input.NewRecorder(nil)
+	c := dial(t, startPeer(t, peer.Options{Capturer: capture.NewSynthetic(320, 240), Input: rec}))
+	if err := c.SetViewport(160, 120, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	waitFrame(t, c, func(f *viewer.Frame) bool { return f.Image.Bounds().Dx() == 160 })
+	if err := c.SendInput(peer.InputEvent{Type: "keydown", Key: "Shift"}); err != nil {
+		t.Fatal(err)
+	}
+	waitCalls(rec, 1)
+
+	if err := c.Restart(); err != nil {
+		t.Fatalf("ICE restart: %v", err)
+	}
+	// Frame coordinates are still those of the half-size view, and the key
+	// is still held rather than released by the restart
+	if err := c.SendInput(peer.InputEvent{Type: "mousemove", X: 50, Y: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendInput(peer.InputEvent{Type: "keyup", Key: "Shift"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"keydown shift", "move 101,121", "keyup shift"}
+	if got := waitCalls(rec, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("input calls = %q, want %q", got, want)
+	}
+}
+
+func TestLoopbackCursor(t *testing.T) {
+	src := capture.NewSynthetic(320, 240,
+		capture.Step{Frame: 1, MoveCursor: true, CursorX: 30, CursorY: 40},
+		capture.Step{Frame: 10, CursorShape: "ibeam"},
+	)
+	c := dial(t, startPeer(t, peer.Options{Capturer: src, Input: input.NewRecorder(nil)}))
+
+	next := func() *viewer.CursorShape {
+		t.Helper()
+		select {
+		case cs := <-c.Cursors:
+			return cs
+		case err := <-c.Errors:
+			t.Fatalf("cursor: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("no cursor shape within 10s")
+		}
+		return nil
+	}
+	arrow := next()
+	if arrow.Image == nil || arrow.HotX != 0 || arrow.HotY != 0 {
+		t.Fatalf("first shape = %+v, want the arrow with hotspot 0,0", arrow)
+	}
+	ibeam := next()
+	if ibeam.ID == arrow.ID || ibeam.HotX != 4 || ibeam.HotY != 8 || ibeam.Image.Bounds().Dx() != 9 {
+		t.Fatalf("second shape = %+v, want the ibeam with hotspot 4,8", ibeam)
+	}
+	// The shape is only sent when it changes
+	select {
+	case cs := <-c.Cursors:
+		t.Fatalf("unchanged shape sent again: %+v", cs)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if x, y := c.CursorPos(); x != 30 || y != 40 {
+		t.Errorf("cursor position = %d,%d, want 30,40", x, y)
+	}
+}
+
+func TestLoopbackPinning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dtls.pem")
+	cert, err := rtcconfig.LoadCertificate(path)
+	if err != nil {
+		t.Fatalf("certificate: %v", err)
+	}
+	again, err := rtcconfig.LoadCertificate(path)
+	if err != nil {
+		t.Fatalf("reload certificate: %v", err)
+	}
+	fp, _ := rtcconfig.Fingerprint(cert)
+	if fp2, _ := rtcconfig.Fingerprint(again); fp2 != fp {
+		t.Fatalf("fingerprint changed on reload: %s != %s", fp2, fp)
+	}
+	addr := startPeer(t, peer.Options{
+		Capturer: capture.NewSynthetic(64, 48),
+		Input:    input.NewRecorder(nil),
+		ICE:      rtcconfig.Config{Certificates: []webrtc.Certificate{*cert}},
+	})
+
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	sig := signaling.NewConn(udp)
+	t.Cleanup(func() { sig.Close() })
+	exchange := viewer.UDPExchange(sig, addr, 10*time.Second)
+	var answer []byte
+	c, err := viewer.Dial(loopbackICE, "loopback-test", func(o signaling.Offer) (signaling.Answer, error) {
+		ans, err := exchange(o)
+		answer, _ = json.Marshal(ans)
+		return ans, err
+	}, 10*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(c.Close)
+	waitFrame(t, c, func(*viewer.Frame) bool { return true })
+
+	pins, err := signaling.NewPins(signaling.PinConfig{Fingerprints: []string{strings.ToLower(strings.TrimPrefix(fp, "sha-256 "))}})
+	if err != nil {
+		t.Fatalf("pins: %v", err)
+	}
+	if err := pins.Check(answer); err != nil {
+		t.Errorf("enrolled peer rejected: %v", err)
+	}
+	other, _ := signaling.NewPins(signaling.PinConfig{Fingerprints: []string{"sha-256 " + strings.Repeat("AB:", 31) + "AB"}})
+	if err := other.Check(answer); err == nil {
+		t.Error("answer accepted with another enrolled fingerprint")
+	}
+
+	enrolled := filepath.Join(t.TempDir(), "peers")
+	tofu, err := signaling.NewPins(signaling.PinConfig{File: enrolled, Enroll: true})
+	if err != nil {
+		t.Fatalf("enroll pins: %v", err)
+	}
+	if err := tofu.Check(answer); err != nil {
+		t.Fatalf("first answer not enrolled: %v", err)
+	}
+	reloaded, err := signaling.NewPins(signaling.PinConfig{File: enrolled})
+	if err != nil || reloaded.Check(answer) != nil {
+		t.Errorf("enrolled fingerprint not persisted: %v", err)
+	}
+}
+
+func TestLoopbackApproval(t *testing.T) {
+	answers := make(chan error, 1)
+	var (
+		mu    sync.Mutex
+		asked []approval.Request
+	)
+	addr := startPeer(t, peer.Options{
+		Capturer: capture.NewSynthetic(64, 48),
+		Input:    input.NewRecorder(nil),
+		Approver: approval.Func(func(ctx context.Context, r approval.Request) error {
+			mu.Lock()
+			asked = append(asked, r)
+			mu.Unlock()
+			select {
+			case err := <-answers:
+				return err
+			case <-ctx.Done():
+				return approval.ErrRejected
+			}
+		}),
+		ApprovalTimeout: 300 * time.Millisecond,
+	})
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	sig := signaling.NewConn(udp)
+	t.Cleanup(func() { sig.Close() })
+	exchange := viewer.UDPExchange(sig, addr, 5*time.Second)
+	withName := func(o signaling.Offer) (signaling.Answer, error) {
+		o.Requester = &signaling.Requester{Addr: "192.0.2.7", Name: "tester"}
+		return exchange(o)
+	}
+
+	answers <- approval.ErrRejected
+	if _, err := viewer.Dial(loopbackICE, "rejected", withName, 5*time.Second); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Fatalf("dial after rejection: %v, want a rejection", err)
+	}
+	// Nobody answers the prompt
+	if _, err := viewer.Dial(loopbackICE, "unanswered", withName, 5*time.Second); err == nil {
+		t.Fatal("dial succeeded without approval")
+	}
+	answers <- nil
+	c, err := viewer.Dial(loopbackICE, "approved", withName, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial after approval: %v", err)
+	}
+	t.Cleanup(c.Close)
+	waitFrame(t, c, func(*viewer.Frame) bool { return true })
+	mu.Lock()
+	defer mu.Unlock()
+	if len(asked) != 3 || asked[0].Addr != "192.0.2.7" || asked[0].Name != "tester" || asked[2].Session != "approved" {
+		t.Errorf("approval requests = %+v", asked)
+	}
+}
+
+// negotiate sends an offer from pc for session, an ICE restart if restart
+// is set, and applies the answer.
+func negotiate(pc *webrtc.PeerConnection, exchange viewer.Exchange, session string, restart bool) error {
+	offer, err := pc.CreateOffer(&webrtc.OfferOptions{ICERestart: restart})
+	if err != nil {
+		return err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	<-gathered
+	answer, err := exchange(signaling.Offer{SDP: *pc.LocalDescription(), Session: session})
+	if err != nil {
+		return err
+	}
+	return pc.SetRemoteDescription(answer.SessionDescription)
+}
+
+func TestLoopbackRestartDuringApproval(t *testing.T) {
+	answers := make(chan error, 1)
+	asked := make(chan string, 4)
+	addr := startPeer(t, peer.Options{
+		Capturer: capture.NewSynthetic(64, 48),
+		Input:    input.NewRecorder(nil),
+		Approver: approval.Func(func(ctx context.Context, r approval.Request) error {
+			asked <- r.Session
+			select {
+			case err := <-answers:
+				return err
+			case <-ctx.Done():
+				return approval.ErrRejected
+			}
+		}),
+		ApprovalTimeout: 20 * time.Second,
+	})
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	sig := signaling.NewConn(udp)
+	t.Cleanup(func() { sig.Close() })
+	exchange := viewer.UDPExchange(sig, addr, 30*time.Second)
+
+	pc, err := loopbackICE.NewPeerConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if _, err := pc.CreateDataChannel("frames", nil); err != nil {
+		t.Fatal(err)
+	}
+	answers <- nil
+	if err := negotiate(pc, exchange, "live", false); err != nil {
+		t.Fatalf("first offer: %v", err)
+	}
+	<-asked
+
+	// A second browser waits for the host to answer its prompt
+	pending := make(chan error, 1)
+	go func() {
+		c, err := viewer.Dial(loopbackICE, "waiting", exchange, 30*time.Second)
+		if err == nil {
+			c.Close()
+		}
+		pending <- err
+	}()
+	if s := <-asked; s != "waiting" {
+		t.Fatalf("prompt for %q, want waiting", s)
+	}
+	start := time.Now()
+	if err := negotiate(pc, exchange, "live", true); err != nil {
+		t.Fatalf("ICE restart: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("ICE restart answered after %v, behind the open prompt", d)
+	}
+	answers <- approval.ErrRejected
+	if err := <-pending; err == nil {
+		t.Error("rejected browser connected")
+	}
+}
+
+func TestLoopbackOfferErrors(t *testing.T) {
+	addr := startPeer(t, peer.Options{Capturer: capture.NewSynthetic(64, 48), Input: input.NewRecorder(nil)})
+	to, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	sig := signaling.NewConn(udp)
+	t.Cleanup(func() { sig.Close() })
+	for name, payload := range map[string]string{
+		"not base64": "%%%",
+		"not JSON":   base64.StdEncoding.EncodeToString([]byte("{")),
+		"bad SDP":    base64.StdEncoding.EncodeToString([]byte(`{"sdp":{"type":"offer","sdp":"v=0"}}`)),
+	} {
+		id, err := sig.Send(to, "OFFER", []byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Well before the server would give up
+		m, err := sig.ReceiveReply(5*time.Second, id)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if m.Kind != "ERROR" || len(m.Payload) == 0 {
+			t.Errorf("%s: reply %s %q, want ERROR with the reason", name, m.Kind, m.Payload)
+		}
+	}
+}
+
+func TestLoopbackRestartNeedsSameCertificate(t *testing.T) {
+	codes := make(chan string, 4)
+	pairer, err := pairing.New(pairing.Config{}, func(code string, _ time.Time) { codes <- code })
+	if err != nil {
+		t.Fatalf("pairing: %v", err)
+	}
+	t.Cleanup(pairer.Close)
+	code := <-codes
+	addr := startPeer(t, peer.Options{Capturer: capture.NewSynthetic(64, 48), Input: input.NewRecorder(nil), Pairing: pairer})
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	sig := signaling.NewConn(udp)
+	t.Cleanup(func() { sig.Close() })
+	exchange := viewer.UDPExchange(sig, addr, 5*time.Second)
+	newPC := func() *webrtc.PeerConnection {
+		pc, err := loopbackICE.NewPeerConnection()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { pc.Close() })
+		if _, err := pc.CreateDataChannel("frames", nil); err != nil {
+			t.Fatal(err)
+		}
+		return pc
+	}
+
+	pc := newPC()
+	withCode := func(o signaling.Offer) (signaling.Answer, error) {
+		o.Code = code
+		return exchange(o)
+	}
+	if err := negotiate(pc, withCode, "live", false); err != nil {
+		t.Fatalf("paired offer: %v", err)
+	}
+	// Another browser that knows the session id is not let in as a restart
+	if err := negotiate(newPC(), exchange, "live", false); err == nil || !strings.Contains(err.Error(), "not paired") {
+		t.Fatalf("restart offer from another certificate: %v, want not paired", err)
+	}
+	if err := negotiate(pc, exchange, "live", true); err != nil {
+		t.Fatalf("ICE restart without a code: %v", err)
+	}
+}
+
+func TestLoopbackFailedRestartIsRefused(t *testing.T) {
+	addr := startPeer(t, peer.Options{Capturer: capture.NewSynthetic(64, 48), Input: input.NewRecorder(nil)})
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	sig := signaling.NewConn(udp)
+	t.Cleanup(func() { sig.Close() })
+	exchange := viewer.UDPExchange(sig, addr, 5*time.Second)
+	pc, err := loopbackICE.NewPeerConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if _, err := pc.CreateDataChannel("frames", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := negotiate(pc, exchange, "live", false); err != nil {
+		t.Fatalf("first offer: %v", err)
+	}
+	// Same certificate, but an offer the session cannot apply
+	broken := func(o signaling.Offer) (signaling.Answer, error) {
+		var lines []string
+		for _, l := range strings.Split(o.SDP.SDP, "\r\n") {
+			if !strings.HasPrefix(l, "a=ice-ufrag:") && !strings.HasPrefix(l, "a=ice-pwd:") {
+				lines = append(lines, l)
+			}
+		}
+		o.SDP.SDP = strings.Join(lines, "\r\n")
+		return exchange(o)
+	}
+	start := time.Now()
+	err = negotiate(pc, broken, "live", true)
+	if err == nil || !strings.Contains(err.Error(), "could not answer") {
+		t.Fatalf("broken ICE restart: %v, want the peer's error", err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("refusal took %v", d)
+	}
+}
+
+func TestLoopbackPairing(t *testing.T) {
+	codes := make(chan string, 4)
+	store := filepath.Join(t.TempDir(), "devices.json")
+	pairer, err := pairing.New(pairing.Config{Store: store}, func(code string, _ time.Time) { codes <- code })
+	if err != nil {
+		t.Fatalf("pairing: %v", err)
+	}
+	t.Cleanup(pairer.Close)
+	code := <-codes
+	addr := startPeer(t, peer.Options{Capturer: capture.NewSynthetic(64, 48), Input: input.NewRecorder(nil), Pairing: pairer})
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	sig := signaling.NewConn(udp)
+	t.Cleanup(func() { sig.Close() })
+	exchange := viewer.UDPExchange(sig, addr, 5*time.Second)
+	dialWith := func(session, code, token string) (*viewer.Client, error) {
+		return viewer.Dial(loopbackICE, session, func(o signaling.Offer) (signaling.Answer, error) {
+			o.Code, o.DeviceToken = code, token
+			return exchange(o)
+		}, 5*time.Second)
+	}
+
+	if _, err := dialWith("no-code", "", ""); err == nil || !strings.Contains(err.Error(), "not paired") {
+		t.Fatalf("dial without a code: %v, want not paired", err)
+	}
+	if _, err := dialWith("wrong-code", "000000x", ""); err == nil {
+		t.Fatal("dial with a wrong code succeeded")
+	}
+	c, err := dialWith("paired", code, "")
+	if err != nil {
+		t.Fatalf("dial with the code: %v", err)
+	}
+	c.Close()
+	if c.DeviceToken == "" {
+		t.Fatal("no device token after pairing")
+	}
+	if next := <-codes; next == code {
+		t.Error("code not replaced after use")
+	}
+	if _, err := dialWith("reused-code", code, ""); err == nil {
+		t.Error("used code admitted again")
+	}
+
+	again, err := dialWith("token", "", c.DeviceToken)
+	if err != nil {
+		t.Fatalf("dial with the device token: %v", err)
+	}
+	t.Cleanup(again.Close)
+	waitFrame(t, again, func(*viewer.Frame) bool { return true })
+	reloaded, err := pairing.New(pairing.Config{Store: store}, nil)
+	if err != nil {
+		t.Fatalf("reload devices: %v", err)
+	}
+	defer reloaded.Close()
+	if n := len(reloaded.Devices()); n != 1 {
+		t.Errorf("%d devices stored, want 1", n)
+	}
+}
+
+func TestLoopbackAudit(t *testing.T) {
+	dir := t.TempDir()
+	auditLog, err := audit.New(audit.Config{Dir: dir, Keys: audit.KeysRedact}, "peer")
+	if err != nil {
+		t.Fatalf("audit: %v", err)
+	}
+	defer auditLog.Close()
+	rec := input.NewRecorder(nil)
+	c := dial(t, startPeer(t, peer.Options{Capturer: capture.NewSynthetic(64, 48), Input: rec, Audit: auditLog}))
+	waitFrame(t, c, func(*viewer.Frame) bool { return true })
+	for _, ev := range []peer.InputEvent{
+		{Type: "keydown", Key: "s"},
+		{Type: "keydown", Key: "Enter"},
+		{Type: "paste", ClipboardText: "secret"},
+	} {
+		if err := c.SendInput(ev); err != nil {
+			t.Fatalf("send %s: %v", ev.Type, err)
+		}
+	}
+	waitCalls(rec, 3)
+	c.Close()
+
+	want := []string{"session_start", "key *", "key Enter", "paste 6", "session_end"}
+	var got []string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		got = nil
+		files, _ := filepath.Glob(filepath.Join(dir, "audit-peer-*.jsonl"))
+		for _, f := range files {
+			b, _ := os.ReadFile(f)
+			for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+				var e audit.Event
+				if json.Unmarshal([]byte(line), &e) != nil {
+					continue
+				}
+				if e.Text != "" {
+					t.Fatalf("paste text logged in redact mode: %q", e.Text)
+				}
+				switch e.Kind {
+				case audit.KindKey:
+					got = append(got, "key "+e.Key)
+				case audit.KindPaste:
+					got = append(got, fmt.Sprint("paste ", e.Length))
+				default:
+					got = append(got, e.Kind)
+				}
+			}
+		}
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+	}
+	t.Errorf("audit events = %q, want %q", got, want)
+}
+
+func TestLoopbackStats(t *testing.T) {
+	c := dial(t, startPeer(t, peer.Options{Capturer: capture.NewSynthetic(64, 48), Input: input.NewRecorder(nil), StatsInterval: 100 * time.Millisecond}))
+	f := waitFrame(t, c, func(*viewer.Frame) bool { return true })
+	if lag := f.Received.Sub(f.Captured); f.Captured.IsZero() || lag < 0 || lag > 10*time.Second {
+		t.Errorf("frame captured %v, received %v", f.Captured, f.Received)
+	}
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case st := <-c.Stats:
+			if st.FPS == 0 {
+				// The synthetic screen does not change after the first frame
+				continue
+			}
+			if st.FrameBytes <= 0 || st.EncodeMs <= 0 {
+				t.Errorf("stats = %+v, want frame size and encode time", *st)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no stats message with a frame")
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer for concurrent writers.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLoopbackLogging(t *testing.T) {
+	var out syncBuffer
+	l := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: logging.LevelTrace}))
+	c := dial(t, startPeer(t, peer.Options{
+		Capturer: capture.NewSynthetic(64, 48),
+		Input:    input.NewRecorder(nil),
+		ICE:      rtcconfig.Config{LoggerFactory: logging.PionFactory{Logger: l, Level: slog.LevelDebug}},
+		Logger:   l,
+	}))
+	waitFrame(t, c, func(*viewer.Frame) bool { return true })
+
+	scopes := map[string]bool{}
+	var answered bool
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var rec struct {
+			Level, Msg, Session, Scope string
+		}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if rec.Scope != "" {
+			if rec.Session != "loopback-test" {
+				t.Errorf("pion line without the session: %s", line)
+			}
+			if rec.Level == "DEBUG-4" {
+				t.Errorf("trace line below the pion level: %s", line)
+			}
+			scopes[rec.Scope] = true
+		}
+		answered = answered || rec.Msg == "session answered; waiting for the data channels" && rec.Session == "loopback-test"
+	}
+	if !answered {
+		t.Error("no session line for the answer")
+	}
+	if !scopes["ice"] {
+		t.Errorf("pion scopes logged = %v, want ice among them", scopes)
+	}
+}
+
+func TestLoopbackMetrics(t *testing.T) {
+	m := metrics.NewPeer()
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	scrape := func() string {
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("scrape: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+	rec := input.NewRecorder(nil)
+	c := dial(t, startPeer(t, peer.Options{Capturer: capture.NewSynthetic(64, 48), Input: rec, Metrics: m}))
+	waitFrame(t, c, func(*viewer.Frame) bool { return true })
+	if err := c.SendInput(peer.InputEvent{Type: "keydown", Key: "a"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	waitCalls(rec, 1)
+
+	body := scrape()
+	for _, want := range []string{
+		"weblinuxgui_sessions_active 1",
+		`weblinuxgui_frames_sent_total{format="jpeg"}`,
+		`weblinuxgui_frame_encode_seconds_count{format="jpeg"}`,
+		`weblinuxgui_input_events_total{type="keydown"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+	c.Close()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if strings.Contains(scrape(), "weblinuxgui_sessions_active 0") {
+			return
+		}
+	}
+	t.Error("sessions_active not back to 0 after the session ended")
+}
@@ -0,0 +1,625 @@
+package peer
+
+// Package peer is the streaming side of a session: it answers browser
+// offers, captures and sends frames over the "frames" DataChannel and
+// injects events received on the "input" DataChannel.
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"weblinuxgui/approval"
+	"weblinuxgui/audit"
+	"weblinuxgui/capture"
+	"weblinuxgui/input"
+	"weblinuxgui/logging"
+	"weblinuxgui/metrics"
+	"weblinuxgui/pairing"
+	"weblinuxgui/recording"
+	"weblinuxgui/rtcconfig"
+	"weblinuxgui/signaling"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Options configures a Peer.
+type Options struct {
+	FPS, Quality, Display int
+	// ICE is used as is; the zero value means host candidates only.
+	ICE rtcconfig.Config
+	// Capturer defaults to capture.NewScreenshot().
+	Capturer capture.Capturer
+	// Input defaults to input.Current().
+	Input input.Injector
+	// OpenTimeout is how long a new session waits for the frames channel.
+	OpenTimeout time.Duration
+	// ReconnectTimeout is how long a failed session waits for an ICE restart.
+	ReconnectTimeout time.Duration
+	// Recorder, if set, receives every streamed frame and input event.
+	Recorder *recording.Writer
+	// Codec is FormatJPEG (default), FormatPNG, FormatQOI or CodecAuto.
+	Codec string
+	// Lossless is the refinement format in auto mode (default FormatPNG).
+	Lossless string
+	// Settle is how long the screen must be still before the auto mode
+	// sends the lossless refinement (default 500ms).
+	Settle time.Duration
+	// CursorRate is how many times per second the cursor position is sent
+	// between frames (default 60); negative disables cursor messages.
+	CursorRate int
+	// Encoders is how many frames are encoded in parallel (default the
+	// number of CPUs, at most 4).
+	Encoders int
+	// Bands splits every frame into this many horizontal bands that are
+	// encoded in parallel (default 1, no bands).
+	Bands int
+	// StatsInterval is how often the browser is sent a Stats message
+	// (default 1s); negative disables them.
+	StatsInterval time.Duration
+	// Approver, if set, is asked before a new session is answered; ICE
+	// restarts of the current session are not asked again.
+	Approver approval.Approver
+	// ApprovalTimeout is how long the approver may take (default 30s).
+	ApprovalTimeout time.Duration
+	// Pairing, if set, admits new sessions only with its current code or
+	// a device token from an earlier pairing.
+	Pairing *pairing.Pairer
+	// Audit, if set, logs sessions, refusals, pastes and key presses.
+	Audit *audit.Logger
+	// Metrics, if set, counts frames, input and sessions and polls the
+	// transport stats of the current session.
+	Metrics *metrics.Peer
+	// Logger defaults to slog.Default(). Session lines carry a "session"
+	// attribute, and so do pion's when ICE.LoggerFactory is a
+	// logging.PionFactory.
+	Logger *slog.Logger
+}
+
+// Peer owns the current session and the frame stream.
+type Peer struct {
+	opts Options
+	log  *slog.Logger
+
+	mu   sync.Mutex
+	sess *Session
+	// Display origin for multi-monitor setups, updated on every capture
+	offsetX, offsetY int
+
+	images imagePool
+	stats  frameStats
+	// resync makes the capture stage send the next capture even if it is
+	// unchanged, set when a frame could not be sent
+	resync atomic.Bool
+}
+
+// New returns a Peer with defaults filled in.
+func New(opts Options) *Peer {
+	if opts.Capturer == nil {
+		opts.Capturer = capture.NewScreenshot()
+	}
+	if opts.Input == nil {
+		opts.Input = input.Current()
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	if opts.ReconnectTimeout <= 0 {
+		opts.ReconnectTimeout = 2 * time.Minute
+	}
+	codec, err := ParseCodec(opts.Codec)
+	if err != nil {
+		opts.Logger.Warn("using jpeg", "err", err)
+		codec = FormatJPEG
+	}
+	opts.Codec = codec
+	if opts.Lossless != FormatQOI {
+		opts.Lossless = FormatPNG
+	}
+	if opts.Settle <= 0 {
+		opts.Settle = 500 * time.Millisecond
+	}
+	if opts.CursorRate == 0 {
+		opts.CursorRate = 60
+	}
+	if opts.Encoders <= 0 {
+		opts.Encoders = min(runtime.NumCPU(), 4)
+	}
+	opts.Bands = max(opts.Bands, 1)
+	if opts.StatsInterval == 0 {
+		opts.StatsInterval = time.Second
+	}
+	if opts.ApprovalTimeout <= 0 {
+		opts.ApprovalTimeout = 30 * time.Second
+	}
+	return &Peer{opts: opts, log: opts.Logger}
+}
+
+// Session is one browser connection. It survives ICE restarts: the
+// PeerConnection, its DataChannels, the viewport the browser asked for and
+// the held keys are kept and only the ICE transport is renegotiated. The
+// display streamed is the peer's (Options.Display), so it does not change
+// either.
+type Session struct {
+	id      string
+	pc      *webrtc.PeerConnection
+	in      input.Injector
+	rec     *recording.Writer
+	audit   *audit.Logger
+	metrics *metrics.Peer
+	log     *slog.Logger
+	// fingerprints are the browser's DTLS certificate fingerprints, which
+	// an ICE restart must repeat
+	fingerprints []string
+
+	negotiateMu sync.Mutex // serializes offer/answer rounds
+
+	mu          sync.Mutex
+	framesDC    *webrtc.DataChannel
+	framesOpen  bool
+	heldKeys    map[string]bool
+	failedTimer *time.Timer
+	view        viewport  // requested by the browser
+	xf          transform // of the last frame sent
+	started     time.Time // when the session was answered, for its end event
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (p *Peer) newSession(offer signaling.Offer) (*Session, error) {
+	l := p.sessionLog(offer.Session)
+	ice := p.opts.ICE
+	if f, ok := ice.LoggerFactory.(logging.PionFactory); ok {
+		f.Logger = l
+		ice.LoggerFactory = f
+	}
+	// The PeerConnection is created per offer so TURN credentials minted by the server can be used
+	pc, err := ice.NewPeerConnection(offer.ICEServers...)
+	if err != nil {
+		return nil, fmt.Errorf("new pc: %w", err)
+	}
+	s := &Session{id: offer.Session, pc: pc, in: p.opts.Input, rec: p.opts.Recorder, audit: p.opts.Audit, metrics: p.opts.Metrics, log: l, heldKeys: map[string]bool{}, done: make(chan struct{}),
+		fingerprints: signaling.Fingerprints(offer.SDP.SDP)}
+
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		label := dc.Label()
+		s.log.Debug("data channel", "label", label)
+		switch label {
+		case "input":
+			dc.OnOpen(func() { s.log.Info("input channel open") })
+			dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+				if !msg.IsString {
+					s.log.Warn("binary input message ignored", "bytes", len(msg.Data))
+					return
+				}
+				var ev InputEvent
+				if err := json.Unmarshal(msg.Data, &ev); err != nil {
+					s.log.Warn("bad input message", "err", err)
+					return
+				}
+				if ev.Type == "ping" {
+					s.pong(ev.Time)
+					return
+				}
+				s.metrics.Input(ev.Type)
+				if ev.Type == "viewport" {
+					s.setViewport(viewportFrom(ev))
+					return
+				}
+				if s.rec != nil {
+					s.rec.Input(s.id, msg.Data)
+				}
+				switch ev.Type {
+				case "keydown":
+					s.audit.Key(s.id, ev.Key)
+				case "paste":
+					s.audit.Paste(s.id, ev.ClipboardText)
+				}
+				s.trackKeys(ev)
+				p.handleInput(ev, s.transform())
+			})
+		case "frames":
+			s.mu.Lock()
+			s.framesDC = dc
+			s.mu.Unlock()
+			dc.OnOpen(func() {
+				s.mu.Lock()
+				s.framesOpen = true
+				s.mu.Unlock()
+				s.log.Info("frames channel open; starting stream")
+			})
+			dc.OnClose(func() {
+				s.log.Info("frames channel closed; ending session")
+				go s.Close()
+			})
+		}
+	})
+
+	// Keep the session through disconnects; the browser performs an ICE restart.
+	// Give up only if ICE stays failed for ReconnectTimeout.
+	reconnectTimeout := p.opts.ReconnectTimeout
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		s.log.Info("ICE connection state", "state", state.String())
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch state {
+		case webrtc.ICEConnectionStateFailed:
+			if s.failedTimer == nil {
+				s.failedTimer = time.AfterFunc(reconnectTimeout, func() {
+					s.log.Warn("no ICE restart in time; ending session", "timeout", reconnectTimeout)
+					s.Close()
+				})
+			}
+		case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+			if s.failedTimer != nil {
+				s.failedTimer.Stop()
+				s.failedTimer = nil
+			}
+		case webrtc.ICEConnectionStateClosed:
+			go s.Close()
+		}
+	})
+	return s, nil
+}
+
+// negotiate applies an offer, the first one or an ICE restart, and returns
+// the answer JSON once candidate gathering is complete; deviceToken is passed
+// on to a newly paired browser.
+func (s *Session) negotiate(offer webrtc.SessionDescription, deviceToken string) ([]byte, error) {
+	s.negotiateMu.Lock()
+	defer s.negotiateMu.Unlock()
+	if err := s.pc.SetRemoteDescription(offer); err != nil {
+		return nil, fmt.Errorf("set remote: %w", err)
+	}
+	answer, err := s.pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("create answer: %w", err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(s.pc)
+	if err := s.pc.SetLocalDescription(answer); err != nil {
+		return nil, fmt.Errorf("set local: %w", err)
+	}
+	<-gatherComplete
+	return json.Marshal(signaling.Answer{SessionDescription: *s.pc.LocalDescription(), DeviceToken: deviceToken})
+}
+
+// trackKeys remembers which keys the browser holds down so they can be
+// released if the session ends while a keyup is lost.
+func (s *Session) trackKeys(ev InputEvent) {
+	key := normalizeKey(ev.Key)
+	if key == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch ev.Type {
+	case "keydown":
+		s.heldKeys[key] = true
+	case "keyup":
+		delete(s.heldKeys, key)
+	}
+}
+
+func (s *Session) setViewport(v viewport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.view = v
+}
+
+func (s *Session) viewport() viewport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.view
+}
+
+// transform returns the mapping of the frame the browser last received,
+// which is what its input coordinates refer to.
+func (s *Session) transform() transform {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.xf
+}
+
+func (s *Session) setTransform(xf transform) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.xf = xf
+}
+
+// frames returns the frames channel when the session can stream.
+func (s *Session) frames() *webrtc.DataChannel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.framesOpen {
+		return nil
+	}
+	switch s.pc.ICEConnectionState() {
+	case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+		return s.framesDC
+	}
+	return nil
+}
+
+// Close releases held keys and tears down the PeerConnection.
+func (s *Session) Close() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		for key := range s.heldKeys {
+			s.in.KeyUp(key)
+		}
+		s.heldKeys = map[string]bool{}
+		if s.failedTimer != nil {
+			s.failedTimer.Stop()
+		}
+		started := s.started
+		s.mu.Unlock()
+		if err := s.pc.Close(); err != nil {
+			s.log.Debug("close peer connection", "err", err)
+		}
+		if s.rec != nil {
+			s.rec.EndSession(s.id)
+		}
+		if !started.IsZero() {
+			s.audit.Log(audit.Event{Kind: audit.KindSessionEnd, Session: s.id, Detail: time.Since(started).Round(time.Second).String()})
+			s.metrics.SessionEnded()
+		}
+		close(s.done)
+	})
+}
+
+// Done is closed when the session ends.
+func (s *Session) Done() <-chan struct{} { return s.done }
+
+func (s *Session) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sameBrowser reports whether offer carries the DTLS certificate this
+// session was negotiated with.
+func (s *Session) sameBrowser(offer signaling.Offer) bool {
+	fps := signaling.Fingerprints(offer.SDP.SDP)
+	if len(fps) == 0 {
+		return false
+	}
+	for _, fp := range fps {
+		if !slices.Contains(s.fingerprints, fp) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleOffer answers an offer (the JSON of a signaling.Offer or a bare
+// SessionDescription). An offer for the current session from the same
+// browser, by its DTLS certificate, is an ICE restart and keeps the session;
+// any other offer replaces the session once it is paired and the approver
+// accepts it, otherwise the error wraps pairing.ErrUnpaired or
+// approval.ErrRejected.
+func (p *Peer) HandleOffer(offerJSON []byte) (*Session, []byte, error) {
+	offer, err := signaling.DecodeOffer(offerJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+	cur := p.current()
+	if cur != nil && offer.Session != "" && offer.Session == cur.id && !cur.closed() {
+		if cur.sameBrowser(offer) {
+			cur.log.Info("ICE restart")
+			p.opts.Audit.Log(requesterEvent(audit.KindICERestart, offer))
+			ans, err := cur.negotiate(offer.SDP, "")
+			if err != nil {
+				// The browser gets the reason back instead of a timeout
+				cur.log.Warn("ICE restart failed", "err", err)
+				e := requesterEvent(audit.KindRejected, offer)
+				e.Detail = "ICE restart: " + err.Error()
+				p.opts.Audit.Log(e)
+				return nil, nil, fmt.Errorf("ICE restart: %w", err)
+			}
+			return cur, ans, nil
+		}
+		// The session id is not secret; without the certificate the offer
+		// must be paired and approved like any other
+		cur.log.Warn("restart offer from another DTLS certificate; handling it as a new session")
+	}
+	token, err := p.pair(offer)
+	if err == nil {
+		err = p.approve(offer)
+	}
+	if err != nil {
+		e := requesterEvent(audit.KindRejected, offer)
+		e.Detail = err.Error()
+		p.opts.Audit.Log(e)
+		return nil, nil, err
+	}
+	s, err := p.newSession(offer)
+	if err != nil {
+		return nil, nil, err
+	}
+	ans, err := s.negotiate(offer.SDP, token)
+	if err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+	p.mu.Lock()
+	old := p.sess
+	p.sess = s
+	p.mu.Unlock()
+	if old != nil {
+		old.log.Info("replaced by a new session", "new", s.id)
+		old.Close()
+	}
+	if s.rec != nil {
+		s.rec.StartSession(s.id)
+	}
+	s.mu.Lock()
+	s.started = time.Now()
+	s.mu.Unlock()
+	p.opts.Audit.Log(requesterEvent(audit.KindSessionStart, offer))
+	p.opts.Metrics.SessionStarted()
+	if p.opts.Metrics != nil {
+		go p.pollStats(s)
+	}
+	// Drop sessions whose browser never opens the frames channel
+	time.AfterFunc(p.opts.OpenTimeout, func() {
+		s.mu.Lock()
+		open := s.framesOpen
+		s.mu.Unlock()
+		if !open {
+			s.log.Warn("frames channel not opened by the browser; ending session")
+			s.Close()
+		}
+	})
+	s.log.Info("session answered; waiting for the data channels")
+	return s, ans, nil
+}
+
+// pair checks the offer's pairing code or device token and returns the
+// token for a newly paired browser.
+func (p *Peer) pair(offer signaling.Offer) (string, error) {
+	if p.opts.Pairing == nil {
+		return "", nil
+	}
+	var name, addr string
+	if r := offer.Requester; r != nil {
+		name, addr = r.Name, r.Addr
+	}
+	token, err := p.opts.Pairing.Verify(offer.Code, offer.DeviceToken, name, addr)
+	if err != nil {
+		p.sessionLog(offer.Session).Warn("not paired", "addr", addr, "err", err)
+		return "", err
+	}
+	if token != "" {
+		p.sessionLog(offer.Session).Info("paired a new device", "addr", addr, "name", name)
+		p.opts.Audit.Log(requesterEvent(audit.KindPaired, offer))
+	}
+	return token, nil
+}
+
+// requesterEvent is an audit event about offer's browser.
+func requesterEvent(kind string, offer signaling.Offer) audit.Event {
+	e := audit.Event{Kind: kind, Session: offer.Session}
+	if r := offer.Requester; r != nil {
+		e.Addr, e.Name, e.UserAgent = r.Addr, r.Name, r.UserAgent
+	}
+	return e
+}
+
+// approve asks the approver, if any, whether offer may start a session.
+func (p *Peer) approve(offer signaling.Offer) error {
+	if p.opts.Approver == nil {
+		return nil
+	}
+	req := approval.Request{Session: offer.Session}
+	if r := offer.Requester; r != nil {
+		req.Addr, req.Name, req.UserAgent = r.Addr, r.Name, r.UserAgent
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.ApprovalTimeout)
+	defer cancel()
+	l := p.sessionLog(offer.Session)
+	l.Info("asking the host to approve the connection", "from", req.String())
+	if err := p.opts.Approver.Approve(ctx, req); err != nil {
+		if !errors.Is(err, approval.ErrRejected) {
+			err = fmt.Errorf("%w: %v", approval.ErrRejected, err)
+		}
+		l.Warn("not approved", "addr", req.Addr, "err", err)
+		return err
+	}
+	l.Info("approved", "addr", req.Addr)
+	return nil
+}
+
+// sessionLog is the logger for lines about session id.
+func (p *Peer) sessionLog(id string) *slog.Logger {
+	return p.log.With("session", id)
+}
+
+func (p *Peer) current() *Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess
+}
+
+func (p *Peer) displayOffset() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.offsetX, p.offsetY
+}
+
+// ServeUDP answers OFFERs received on sig until it is closed. Later offers
+// are ICE restarts of the current session or new sessions replacing it.
+// Each offer is handled on its own, so a restart is answered while a new
+// browser's approval prompt is still open.
+func (p *Peer) ServeUDP(sig *signaling.Conn) error {
+	for {
+		req, err := sig.Receive(time.Hour, "OFFER")
+		if errors.Is(err, signaling.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("wait OFFER: %w", err)
+		}
+		go p.serveOffer(sig, req)
+	}
+}
+
+// serveOffer answers one OFFER, or tells the sender why not.
+func (p *Peer) serveOffer(sig *signaling.Conn, req signaling.Message) {
+	offerJSON, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(req.Payload)))
+	if err != nil {
+		p.log.Warn("decode offer", "from", req.From.String(), "err", err)
+		p.respondError(sig, req, fmt.Errorf("decode offer: %w", err))
+		return
+	}
+	_, ansJSON, err := p.HandleOffer(offerJSON)
+	if errors.Is(err, pairing.ErrUnpaired) {
+		// The browser asks for the code and tries again
+		if err := sig.Respond(req, "UNPAIRED", []byte(err.Error())); err != nil {
+			p.log.Warn("send UNPAIRED", "to", req.From.String(), "err", err)
+		}
+		return
+	}
+	if errors.Is(err, approval.ErrRejected) {
+		// Tell the server so the browser gets a message instead of a timeout
+		if err := sig.Respond(req, "REJECT", []byte(err.Error())); err != nil {
+			p.log.Warn("send REJECT", "to", req.From.String(), "err", err)
+		}
+		return
+	}
+	if err != nil {
+		p.log.Error("offer", "from", req.From.String(), "err", err)
+		p.respondError(sig, req, err)
+		return
+	}
+	ansB64 := base64.StdEncoding.EncodeToString(ansJSON)
+	// Send ANSWER back to the sender via UDP
+	if err := sig.Respond(req, "ANSWER", []byte(ansB64)); err != nil {
+		p.log.Warn("send ANSWER", "to", req.From.String(), "err", err)
+		return
+	}
+	p.log.Debug("ANSWER sent", "to", req.From.String())
+}
+
+// respondError tells the sender that its offer failed, so the browser gets
+// the reason instead of waiting for an answer that never comes.
+func (p *Peer) respondError(sig *signaling.Conn, req signaling.Message, err error) {
+	if err := sig.Respond(req, "ERROR", []byte(err.Error())); err != nil {
+		p.log.Warn("send ERROR", "to", req.From.String(), "err", err)
+	}
+}
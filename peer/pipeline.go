@@ -0,0 +1,343 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"sync"
+	"time"
+
+	"weblinuxgui/capture"
+	"weblinuxgui/metrics"
+)
+
+// The frames path is a pipeline: the capture stage grabs, plans and scales
+// a frame on every tick, a pool of Options.Encoders goroutines encodes
+// frames and the send stage puts them back in capture order and sends them.
+// Unchanged captures are not encoded; they become keepalives.
+// At most Encoders frames are in flight; when the encoders or the channel
+// fall behind, the capture stage skips ticks instead of queueing stale
+// frames.
+
+// bandAlign keeps band edges on JPEG MCU rows so bands join without seams.
+const bandAlign = 16
+
+// frameJob is one frame on its way through the pipeline.
+type frameJob struct {
+	seq       uint64
+	sess      *Session
+	dc        textSender
+	img       *image.RGBA // nil for a keepalive
+	format    string
+	keepalive bool
+	idle      time.Duration // for keepalives
+	mx, my    int
+	xf        transform
+	captured  time.Time
+	// Time spent in the capture and encoder stages, for Stats
+	captureDur, encodeDur time.Duration
+
+	// Set by the encoder
+	parts []string // base64, one per band
+	bands []Band   // nil when the frame is not banded
+	rec   []byte   // JPEG for the recorder
+	err   error
+}
+
+// imagePool recycles frame images between captures. Images of another
+// size than requested are dropped, so a resolution change drains it.
+type imagePool struct{ pool sync.Pool }
+
+// get returns a recycled image of the given size, or nil.
+func (p *imagePool) get(size image.Point) *image.RGBA {
+	if img, _ := p.pool.Get().(*image.RGBA); img != nil && img.Rect == (image.Rectangle{Max: size}) {
+		return img
+	}
+	return nil
+}
+
+func (p *imagePool) put(img *image.RGBA) {
+	if img != nil {
+		p.pool.Put(img)
+	}
+}
+
+var bufPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// Stream captures and sends frames to the current session until stop is
+// closed. Cursor updates run alongside at CursorRate and Stats messages
+// every StatsInterval.
+func (p *Peer) Stream(stop <-chan struct{}) {
+	if p.opts.CursorRate > 0 {
+		go p.streamCursor(stop)
+	}
+	if p.opts.StatsInterval > 0 {
+		go p.streamStats(stop)
+	}
+	slots := make(chan struct{}, p.opts.Encoders)
+	jobs := make(chan *frameJob, p.opts.Encoders)
+	encoded := make(chan *frameJob, p.opts.Encoders)
+	var workers sync.WaitGroup
+	for i := 0; i < p.opts.Encoders; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				p.encode(j)
+				encoded <- j
+			}
+		}()
+	}
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		p.sendFrames(encoded, slots)
+	}()
+	p.captureFrames(stop, jobs, slots)
+	close(jobs)
+	workers.Wait()
+	close(encoded)
+	<-sent
+}
+
+// captureFrame grabs the region of the current display requested by v and
+// returns it unscaled, with the mouse coords in frame coordinates and the
+// transform to scale it with.
+func (p *Peer) captureFrame(v viewport) (img *image.RGBA, mx, my int, xf transform, ok bool) {
+	bounds, err := capture.Display(p.opts.Capturer, p.opts.Display)
+	if err != nil {
+		return nil, 0, 0, xf, false
+	}
+	p.mu.Lock()
+	p.offsetX, p.offsetY = bounds.Min.X, bounds.Min.Y
+	p.mu.Unlock()
+	xf = v.transform(bounds.Size())
+	img, err = capture.CaptureInto(p.opts.Capturer, p.images.get(xf.region.Size()), xf.region.Add(bounds.Min))
+	if err != nil {
+		return nil, 0, 0, xf, false
+	}
+	x, y := p.opts.Capturer.Cursor()
+	mx, my = xf.toFrame(x-bounds.Min.X, y-bounds.Min.Y)
+	return img, mx, my, xf, true
+}
+
+// captureFrames is the capture stage. A slot is taken before capturing and
+// given back by the send stage.
+func (p *Peer) captureFrames(stop <-chan struct{}, jobs chan<- *frameJob, slots chan struct{}) {
+	interval := time.Second / time.Duration(max(p.opts.FPS, 1))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	plan := newFramePlanner(p.opts.Codec, p.opts.Lossless, p.opts.Settle)
+	var (
+		scaler frameScaler
+		sess   *Session
+		lastXf transform
+		seq    uint64
+	)
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-stop:
+			return
+		}
+		s := p.current()
+		if s == nil {
+			continue
+		}
+		dc := s.frames()
+		if dc == nil {
+			// Not open yet or reconnecting; avoid capturing for nobody
+			continue
+		}
+		if s != sess || p.resync.Swap(false) {
+			// A new browser has nothing on screen yet, and after a failed
+			// send the current one has a stale frame
+			sess = s
+			plan.reset()
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			// Every slot is busy encoding or sending; skip this tick
+			p.opts.Metrics.FrameDropped(metrics.DropBusy)
+			continue
+		}
+		start := time.Now()
+		img, mx, my, xf, ok := p.captureFrame(s.viewport())
+		if !ok {
+			<-slots
+			continue
+		}
+		p.opts.Metrics.FrameCaptured()
+		if xf != lastXf {
+			// The browser needs a frame for the new view even if it looks the same
+			lastXf = xf
+			plan.reset()
+		}
+		format, keepalive, send := plan.plan(hashPixels(img), mx, my, now)
+		if !send || keepalive {
+			p.images.put(img)
+		}
+		if !send {
+			<-slots
+			continue
+		}
+		j := &frameJob{seq: seq, sess: s, dc: dc, format: format, keepalive: keepalive, mx: mx, my: my, xf: xf, captured: start}
+		if keepalive {
+			j.idle = plan.idle(now)
+		} else {
+			if img.Rect.Size() != xf.size {
+				scaled := scaler.scale(p.images.get(xf.size), img, xf.size)
+				p.images.put(img)
+				img = scaled
+			}
+			j.img = img
+			j.captureDur = time.Since(start)
+		}
+		seq++
+		jobs <- j
+	}
+}
+
+// encode is the encoder stage: it fills in the parts of j and recycles
+// its image.
+func (p *Peer) encode(j *frameJob) {
+	if j.img == nil {
+		return
+	}
+	img := j.img
+	defer p.images.put(img)
+	start := time.Now()
+	defer func() {
+		j.encodeDur = time.Since(start)
+		if j.err == nil {
+			p.opts.Metrics.Encoded(j.format, j.encodeDur)
+		}
+	}()
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	n := p.opts.Bands
+	// Recordings are MJPEG whatever the streamed format is
+	record := j.sess.rec != nil
+	if n <= 1 || h < n*bandAlign {
+		j.parts = make([]string, 1)
+		var raw []byte
+		j.parts[0], raw, j.err = p.encodeB64(img, j.format, record && j.format == FormatJPEG)
+		if record && j.err == nil {
+			j.rec = raw
+		}
+	} else {
+		j.parts, j.bands = make([]string, n), make([]Band, n)
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			y0 := h * i / n / bandAlign * bandAlign
+			y1 := h
+			if i < n-1 {
+				y1 = h * (i + 1) / n / bandAlign * bandAlign
+			}
+			j.bands[i] = Band{Index: i, Count: n, Y: y0, Width: w, Height: h}
+			wg.Add(1)
+			go func(i int, band image.Image) {
+				defer wg.Done()
+				j.parts[i], _, errs[i] = p.encodeB64(band, j.format, false)
+			}(i, img.SubImage(image.Rect(0, y0, w, y1)))
+		}
+		wg.Wait()
+		j.err = errors.Join(errs...)
+	}
+	if record && j.err == nil && j.rec == nil {
+		_, j.rec, j.err = p.encodeB64(img, FormatJPEG, true)
+	}
+}
+
+// encodeB64 encodes img into a pooled buffer and returns it as base64, and
+// as a copy of the raw bytes when raw is set.
+func (p *Peer) encodeB64(img image.Image, format string, raw bool) (string, []byte, error) {
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+	buf.Reset()
+	if err := encodeImage(buf, img, format, p.opts.Quality); err != nil {
+		return "", nil, err
+	}
+	var data []byte
+	if raw {
+		data = bytes.Clone(buf.Bytes())
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), data, nil
+}
+
+// sendFrames is the send stage. Frames leave in capture order; a frame
+// whose successor is already encoded is dropped rather than sent late.
+func (p *Peer) sendFrames(encoded <-chan *frameJob, slots <-chan struct{}) {
+	pending := map[uint64]*frameJob{}
+	var (
+		next    uint64
+		frameID int
+	)
+	for j := range encoded {
+		pending[j.seq] = j
+		for {
+			j, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if p.sendJob(j, pending[next], frameID) {
+				frameID++
+				if frameID == int(^uint(0)>>1) { // avoid overflow; reset occasionally
+					frameID = 0
+				}
+			}
+			<-slots
+		}
+	}
+}
+
+// sendJob sends j unless it failed or is superseded by the already encoded
+// next frame, and reports whether a frame was sent.
+func (p *Peer) sendJob(j, next *frameJob, frameID int) bool {
+	if j.keepalive {
+		if err := j.dc.SendText(mustJSON(Keepalive{Type: "keepalive", MouseX: j.mx, MouseY: j.my, IdleMs: j.idle.Milliseconds()})); err != nil {
+			j.sess.log.Debug("send keepalive", "err", err)
+		} else {
+			p.opts.Metrics.KeepaliveSent()
+		}
+		return false
+	}
+	if j.err != nil {
+		j.sess.log.Error("encode frame", "format", j.format, "err", j.err)
+		p.opts.Metrics.FrameDropped(metrics.DropEncode)
+		p.resync.Store(true)
+		return false
+	}
+	if next != nil && !next.keepalive && next.err == nil {
+		p.opts.Metrics.FrameDropped(metrics.DropSuperseded)
+		return false
+	}
+	s := j.sess
+	// Input that arrives after this frame refers to its coordinates
+	s.setTransform(j.xf)
+	meta := FrameMeta{ID: frameID, MouseX: j.mx, MouseY: j.my, Format: j.format, View: j.xf.view(), Captured: j.captured.UnixMilli()}
+	size := 0
+	for i, part := range j.parts {
+		if j.bands != nil {
+			meta.Band = &j.bands[i]
+		}
+		if err := sendFrame(j.dc, meta, part); err != nil {
+			j.sess.log.Debug("send frame", "id", frameID, "err", err)
+			p.opts.Metrics.FrameDropped(metrics.DropSend)
+			p.resync.Store(true)
+			return false
+		}
+		size += base64.StdEncoding.DecodedLen(len(part))
+	}
+	p.opts.Metrics.FrameSent(j.format, size)
+	p.stats.add(j.captureDur, j.encodeDur, size)
+	if s.rec != nil && j.rec != nil {
+		s.rec.Frame(j.rec, j.mx, j.my)
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// statsInterval is how often a session's transport stats are polled for
+// Options.Metrics.
+const statsInterval = 5 * time.Second
+
+// frameStats sums up the frames sent since the last Stats message.
+type frameStats struct {
+	mu              sync.Mutex
+	frames, bytes   int
+	capture, encode time.Duration
+}
+
+func (fs *frameStats) add(capture, encode time.Duration, size int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.frames++
+	fs.bytes += size
+	fs.capture += capture
+	fs.encode += encode
+}
+
+// take returns the averages over elapsed and starts over.
+func (fs *frameStats) take(elapsed time.Duration) Stats {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	st := Stats{Type: "stats"}
+	if fs.frames > 0 {
+		n := time.Duration(fs.frames)
+		st.CaptureMs = msec(fs.capture / n)
+		st.EncodeMs = msec(fs.encode / n)
+		st.FrameBytes = fs.bytes / fs.frames
+		st.FPS = float64(fs.frames) / elapsed.Seconds()
+	}
+	fs.frames, fs.bytes, fs.capture, fs.encode = 0, 0, 0, 0
+	return st
+}
+
+func msec(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+// streamStats sends a Stats message to the streaming session every
+// StatsInterval.
+func (p *Peer) streamStats(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.opts.StatsInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-stop:
+			return
+		}
+		st := p.stats.take(now.Sub(last))
+		last = now
+		s := p.current()
+		if s == nil {
+			continue
+		}
+		dc := s.frames()
+		if dc == nil {
+			continue
+		}
+		st.BufferedBytes = dc.BufferedAmount()
+		if pair, ok := selectedPair(s.pc.GetStats()); ok {
+			st.RTTMs = pair.CurrentRoundTripTime * 1000
+		}
+		if err := dc.SendText(mustJSON(st)); err != nil {
+			s.log.Debug("send stats", "err", err)
+		}
+	}
+}
+
+// pong answers a ping from the browser with the peer's clock.
+func (s *Session) pong(browserTime int64) {
+	if dc := s.frames(); dc != nil {
+		if err := dc.SendText(mustJSON(Pong{Type: "pong", Time: browserTime, PeerTime: time.Now().UnixMilli()})); err != nil {
+			s.log.Debug("send pong", "err", err)
+		}
+	}
+}
+
+// pollStats feeds the DataChannel backlog, RTT and loss of s to the metrics
+// until s ends. DataChannels carry no RTP, so loss is taken from the ICE
+// connectivity checks of the selected candidate pair.
+func (p *Peer) pollStats(s *Session) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	var lastReq, lastResp uint64
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			if p.current() == s {
+				// Nothing is streaming until the next session
+				p.opts.Metrics.Buffered(0)
+			}
+			return
+		}
+		s.mu.Lock()
+		dc := s.framesDC
+		s.mu.Unlock()
+		if dc != nil {
+			p.opts.Metrics.Buffered(dc.BufferedAmount())
+		}
+		pair, ok := selectedPair(s.pc.GetStats())
+		if !ok {
+			continue
+		}
+		var loss float64
+		if req, resp := pair.RequestsSent-lastReq, pair.ResponsesReceived-lastResp; req > 0 && resp <= req {
+			loss = float64(req-resp) / float64(req)
+		}
+		lastReq, lastResp = pair.RequestsSent, pair.ResponsesReceived
+		p.opts.Metrics.Transport(time.Duration(pair.CurrentRoundTripTime*float64(time.Second)), loss)
+	}
+}
+
+// selectedPair returns the nominated candidate pair of a stats report.
+func selectedPair(report webrtc.StatsReport) (webrtc.ICECandidatePairStats, bool) {
+	for _, st := range report {
+		if pair, ok := st.(webrtc.ICECandidatePairStats); ok && pair.Nominated {
+			return pair, true
+		}
+	}
+	return webrtc.ICECandidatePairStats{}, false
+}
@@ -0,0 +1,149 @@
+package peer
+
+import (
+	"image"
+	"math"
+)
+
+// The browser reports how large it draws the screen and which part of it
+// with {"type":"viewport"} messages on the input channel. Frames are then
+// cropped to that region and scaled down to fit, and input coordinates,
+// which refer to the frame, are mapped back to the display.
+
+// maxZoom keeps the region at least 1/maxZoom of the display.
+const maxZoom = 16
+
+// ViewRect is a rectangle in display coordinates.
+type ViewRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// viewport is the browser's last viewport message.
+type viewport struct {
+	width, height int     // canvas size, 0 for no limit
+	zoom          float64 // <= 1 shows the whole display
+	cx, cy        int     // zoom center in display coordinates
+}
+
+func viewportFrom(ev InputEvent) viewport {
+	return viewport{width: ev.Width, height: ev.Height, zoom: ev.Zoom, cx: ev.X, cy: ev.Y}
+}
+
+// transform maps between display and frame coordinates: a frame shows
+// region of the display scaled to size. The zero value is the identity.
+type transform struct {
+	display image.Point
+	region  image.Rectangle
+	size    image.Point
+}
+
+// transform returns the crop and scale for a display of the given size.
+func (v viewport) transform(display image.Point) transform {
+	region := image.Rectangle{Max: display}
+	if v.zoom > 1 {
+		z := math.Min(v.zoom, maxZoom)
+		w, h := max(int(float64(display.X)/z), 1), max(int(float64(display.Y)/z), 1)
+		x := min(max(v.cx-w/2, 0), display.X-w)
+		y := min(max(v.cy-h/2, 0), display.Y-h)
+		region = image.Rect(x, y, x+w, y+h)
+	}
+	size := region.Size()
+	if v.width > 0 && v.height > 0 && size.X > 0 && size.Y > 0 {
+		// Fit into the canvas; never upscale
+		s := math.Min(1, math.Min(float64(v.width)/float64(size.X), float64(v.height)/float64(size.Y)))
+		size = image.Pt(max(int(math.Round(float64(size.X)*s)), 1), max(int(math.Round(float64(size.Y)*s)), 1))
+	}
+	return transform{display: display, region: region, size: size}
+}
+
+// view returns the frameMeta view, nil for the whole display at full size.
+func (t transform) view() *ViewRect {
+	if t.region == (image.Rectangle{Max: t.display}) && t.size == t.display {
+		return nil
+	}
+	return &ViewRect{X: t.region.Min.X, Y: t.region.Min.Y, W: t.region.Dx(), H: t.region.Dy()}
+}
+
+// toFrame maps display coordinates to frame coordinates.
+func (t transform) toFrame(x, y int) (int, int) {
+	if t.size.X == 0 || t.size.Y == 0 {
+		return x, y
+	}
+	return floorDiv((x-t.region.Min.X)*t.size.X, t.region.Dx()),
+		floorDiv((y-t.region.Min.Y)*t.size.Y, t.region.Dy())
+}
+
+// toDisplay maps frame coordinates to the display pixel under the center
+// of the frame pixel.
+func (t transform) toDisplay(x, y int) (int, int) {
+	if t.size.X == 0 || t.size.Y == 0 {
+		return x, y
+	}
+	return t.region.Min.X + floorDiv((2*x+1)*t.region.Dx(), 2*t.size.X),
+		t.region.Min.Y + floorDiv((2*y+1)*t.region.Dy(), 2*t.size.Y)
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// frameScaler downscales frames with an area-averaging (box) filter: every
+// frame pixel is the mean of the display pixels it covers, which keeps thin
+// text strokes instead of dropping them like nearest-neighbour sampling.
+// The column spans are reused while the sizes stay the same.
+type frameScaler struct {
+	src, dst image.Point
+	xs, ys   []int // dst pixel i covers src [xs[i], xs[i+1])
+	sums     []uint32
+}
+
+func spans(src, dst int) []int {
+	s := make([]int, dst+1)
+	for i := range s {
+		s[i] = i * src / dst
+	}
+	return s
+}
+
+// scale returns img resized to size, written into out when it has that
+// size.
+func (f *frameScaler) scale(out, img *image.RGBA, size image.Point) *image.RGBA {
+	src := img.Bounds().Size()
+	if f.src != src || f.dst != size {
+		f.src, f.dst = src, size
+		f.xs, f.ys = spans(src.X, size.X), spans(src.Y, size.Y)
+		f.sums = make([]uint32, src.X*4)
+	}
+	if out == nil || out.Rect != (image.Rectangle{Max: size}) {
+		out = image.NewRGBA(image.Rectangle{Max: size})
+	}
+	for dy := 0; dy < size.Y; dy++ {
+		y0, y1 := f.ys[dy], max(f.ys[dy+1], f.ys[dy]+1)
+		clear(f.sums)
+		for y := y0; y < y1; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+src.X*4]
+			for i, v := range row {
+				f.sums[i] += uint32(v)
+			}
+		}
+		o := dy * out.Stride
+		for dx := 0; dx < size.X; dx++ {
+			x0, x1 := f.xs[dx], max(f.xs[dx+1], f.xs[dx]+1)
+			var r, g, b, a uint32
+			for x := x0 * 4; x < x1*4; x += 4 {
+				r, g, b, a = r+f.sums[x], g+f.sums[x+1], b+f.sums[x+2], a+f.sums[x+3]
+			}
+			n := uint32((x1 - x0) * (y1 - y0))
+			out.Pix[o], out.Pix[o+1], out.Pix[o+2], out.Pix[o+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+			o += 4
+		}
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+//go:build !windows
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"weblinuxgui/recording"
+)
+
+// registerPlayback serves recorded sessions from dir (the peer's RECORD_DIR,
+// shared or copied to this host):
+//
+//	GET /playback                          player page (?rec=NAME), or a list
+//	GET /recordings                        segments, newest first
+//	GET /recordings/{name}                 segment index
+//	GET /recordings/{name}/frame?off=&len= one JPEG frame
+//
+// Recordings hold the screen and the input, keys included, so the
+// /recordings routes answer only localhost unless token is set; other
+// clients must then send it as ?token= or an "Authorization: Bearer" header.
+func registerPlayback(mux *http.ServeMux, dir, token string) {
+	mux.HandleFunc("GET /playback", func(w http.ResponseWriter, r *http.Request) {
+		serveEmbedded(w, "playback.html", "text/html; charset=utf-8")
+	})
+	if dir == "" {
+		mux.HandleFunc("GET /recordings/", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "recordings disabled (set RECORD_DIR)", http.StatusNotFound)
+		})
+		return
+	}
+	guard := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !playbackAllowed(r, token) {
+				slog.Warn("recordings refused", "addr", r.RemoteAddr)
+				msg := "recordings are only served to localhost; set PLAYBACK_TOKEN and open /playback?token=..."
+				if token != "" {
+					msg = "wrong or missing playback token"
+				}
+				http.Error(w, msg, http.StatusForbidden)
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux.HandleFunc("GET /recordings", guard(func(w http.ResponseWriter, r *http.Request) {
+		segs, err := recording.List(dir)
+		if err != nil && !os.IsNotExist(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		type item struct {
+			Name  string    `json:"name"`
+			Start time.Time `json:"start"`
+			Size  int64     `json:"size"`
+		}
+		list := []item{}
+		for _, s := range segs {
+			list = append(list, item{s.Name, s.Start, s.Size})
+		}
+		setNoCache(w)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	}))
+	mux.HandleFunc("GET /recordings/{name}", guard(func(w http.ResponseWriter, r *http.Request) {
+		seg, err := recording.Find(dir, r.PathValue("name"))
+		if err != nil {
+			http.Error(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		entries, err := seg.Entries()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setNoCache(w)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"name": seg.Name, "start": seg.Start, "entries": entries})
+	}))
+	mux.HandleFunc("GET /recordings/{name}/frame", guard(func(w http.ResponseWriter, r *http.Request) {
+		seg, err := recording.Find(dir, r.PathValue("name"))
+		if err != nil {
+			http.Error(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		off, err1 := strconv.ParseInt(r.URL.Query().Get("off"), 10, 64)
+		n, err2 := strconv.Atoi(r.URL.Query().Get("len"))
+		if err1 != nil || err2 != nil || n > 32<<20 {
+			http.Error(w, "bad off/len", http.StatusBadRequest)
+			return
+		}
+		b, err := seg.ReadFrame(off, n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Recorded frames never change, so let the browser keep them while seeking
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(b)
+	}))
+	slog.Info("serving recordings", "dir", dir, "remote", token != "")
+}
+
+// playbackAllowed reports whether r comes from localhost or carries token.
+func playbackAllowed(r *http.Request, token string) bool {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return true
+		}
+	}
+	if token == "" {
+		return false
+	}
+	got := r.URL.Query().Get("token")
+	if h, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		got = strings.TrimSpace(h)
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
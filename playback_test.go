@@ -0,0 +1,41 @@
+//go:build !windows
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPlaybackAccess(t *testing.T) {
+	for _, tc := range []struct {
+		name, token, remote, url, auth string
+		want                           int
+	}{
+		{"localhost", "", "127.0.0.1:5000", "/recordings", "", http.StatusOK},
+		{"localhost v6", "", "[::1]:5000", "/recordings", "", http.StatusOK},
+		{"remote without a token set", "", "192.0.2.7:5000", "/recordings", "", http.StatusForbidden},
+		{"remote, no token", "s3cret", "192.0.2.7:5000", "/recordings", "", http.StatusForbidden},
+		{"remote, wrong token", "s3cret", "192.0.2.7:5000", "/recordings?token=guess", "", http.StatusForbidden},
+		{"remote, query token", "s3cret", "192.0.2.7:5000", "/recordings?token=s3cret", "", http.StatusOK},
+		{"remote, bearer token", "s3cret", "192.0.2.7:5000", "/recordings", "Bearer s3cret", http.StatusOK},
+		{"remote frame", "s3cret", "192.0.2.7:5000", "/recordings/rec-x/frame?off=0&len=1", "", http.StatusForbidden},
+		{"remote page", "", "192.0.2.7:5000", "/playback", "", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			registerPlayback(mux, t.TempDir(), tc.token)
+			r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			r.RemoteAddr = tc.remote
+			if tc.auth != "" {
+				r.Header.Set("Authorization", tc.auth)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package qoi
+
+// Package qoi implements the QOI ("Quite OK Image") format, a lossless
+// codec that encodes screen content several times faster than PNG at a
+// similar size. See https://qoiformat.org/qoi-specification.pdf.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	opIndex = 0x00 // 00xxxxxx
+	opDiff  = 0x40 // 01xxxxxx
+	opLuma  = 0x80 // 10xxxxxx
+	opRun   = 0xc0 // 11xxxxxx
+	opRGB   = 0xfe
+	opRGBA  = 0xff
+	mask2   = 0xc0
+
+	headerSize = 14
+	// Refuse absurd sizes from untrusted headers
+	maxPixels = 400_000_000
+)
+
+var (
+	magic   = []byte("qoif")
+	padding = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+	// ErrFormat is returned for data that is not valid QOI.
+	ErrFormat = errors.New("qoi: invalid format")
+)
+
+func init() {
+	image.RegisterFormat("qoi", "qoif", Decode, DecodeConfig)
+}
+
+type pixel struct{ r, g, b, a uint8 }
+
+func (p pixel) hash() uint8 { return (p.r*3 + p.g*5 + p.b*7 + p.a*11) % 64 }
+
+// Encode writes img as QOI. Opaque pixels of an *image.RGBA are encoded
+// directly; other pixels are converted to QOI's non-premultiplied RGBA.
+func Encode(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	bw := bufio.NewWriterSize(w, 64*1024)
+	var hdr [headerSize]byte
+	copy(hdr[:4], magic)
+	binary.BigEndian.PutUint32(hdr[4:], uint32(width))
+	binary.BigEndian.PutUint32(hdr[8:], uint32(height))
+	hdr[12], hdr[13] = 4, 0
+	if _, err := bw.Write(hdr[:]); err != nil {
+		return err
+	}
+
+	rgba, _ := img.(*image.RGBA)
+	var (
+		index [64]pixel
+		prev  = pixel{0, 0, 0, 255}
+		run   int
+	)
+	n := width * height
+	for i := 0; i < n; i++ {
+		x, y := b.Min.X+i%width, b.Min.Y+i/width
+		var px pixel
+		if rgba != nil {
+			o := rgba.PixOffset(x, y)
+			px = pixel{rgba.Pix[o], rgba.Pix[o+1], rgba.Pix[o+2], rgba.Pix[o+3]}
+			if px.a != 255 {
+				// image.RGBA is premultiplied
+				c := color.NRGBAModel.Convert(color.RGBA{px.r, px.g, px.b, px.a}).(color.NRGBA)
+				px = pixel{c.R, c.G, c.B, c.A}
+			}
+		} else {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			px = pixel{c.R, c.G, c.B, c.A}
+		}
+
+		if px == prev {
+			run++
+			if run == 62 || i == n-1 {
+				bw.WriteByte(opRun | byte(run-1))
+				run = 0
+			}
+			continue
+		}
+		if run > 0 {
+			bw.WriteByte(opRun | byte(run-1))
+			run = 0
+		}
+		h := px.hash()
+		switch {
+		case index[h] == px:
+			bw.WriteByte(opIndex | h)
+		case px.a != prev.a:
+			index[h] = px
+			bw.Write([]byte{opRGBA, px.r, px.g, px.b, px.a})
+		default:
+			index[h] = px
+			// Differences wrap around like the reference implementation's signed chars
+			dr, dg, db := int8(px.r-prev.r), int8(px.g-prev.g), int8(px.b-prev.b)
+			drg, dbg := dr-dg, db-dg
+			switch {
+			case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+				bw.WriteByte(opDiff | byte(dr+2)<<4 | byte(dg+2)<<2 | byte(db+2))
+			case dg >= -32 && dg <= 31 && drg >= -8 && drg <= 7 && dbg >= -8 && dbg <= 7:
+				bw.Write([]byte{opLuma | byte(dg+32), byte(drg+8)<<4 | byte(dbg+8)})
+			default:
+				bw.Write([]byte{opRGB, px.r, px.g, px.b})
+			}
+		}
+		prev = px
+	}
+	bw.Write(padding)
+	return bw.Flush()
+}
+
+func readHeader(r io.Reader) (width, height int, err error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, 0, err
+	}
+	if string(hdr[:4]) != string(magic) {
+		return 0, 0, ErrFormat
+	}
+	w, h := binary.BigEndian.Uint32(hdr[4:]), binary.BigEndian.Uint32(hdr[8:])
+	if w == 0 || h == 0 || uint64(w)*uint64(h) > maxPixels || hdr[12] < 3 || hdr[12] > 4 {
+		return 0, 0, ErrFormat
+	}
+	return int(w), int(h), nil
+}
+
+// DecodeConfig returns the dimensions of a QOI image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	w, h, err := readHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: w, Height: h}, nil
+}
+
+// Decode reads a QOI image. The result is an *image.NRGBA.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	w, h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	var (
+		index [64]pixel
+		px    = pixel{0, 0, 0, 255}
+		run   int
+	)
+	for o := 0; o < len(img.Pix); o += 4 {
+		if run > 0 {
+			run--
+		} else {
+			b1, err := br.ReadByte()
+			if err != nil {
+				return nil, ErrFormat
+			}
+			switch {
+			case b1 == opRGB:
+				var c [3]byte
+				if _, err := io.ReadFull(br, c[:]); err != nil {
+					return nil, ErrFormat
+				}
+				px.r, px.g, px.b = c[0], c[1], c[2]
+			case b1 == opRGBA:
+				var c [4]byte
+				if _, err := io.ReadFull(br, c[:]); err != nil {
+					return nil, ErrFormat
+				}
+				px = pixel{c[0], c[1], c[2], c[3]}
+			case b1&mask2 == opIndex:
+				px = index[b1]
+			case b1&mask2 == opDiff:
+				px.r += (b1>>4)&3 - 2
+				px.g += (b1>>2)&3 - 2
+				px.b += b1&3 - 2
+			case b1&mask2 == opLuma:
+				b2, err := br.ReadByte()
+				if err != nil {
+					return nil, ErrFormat
+				}
+				dg := b1&0x3f - 32
+				px.r += dg + (b2>>4)&0x0f - 8
+				px.g += dg
+				px.b += dg + b2&0x0f - 8
+			case b1&mask2 == opRun:
+				run = int(b1 & 0x3f)
+			}
+			index[px.hash()] = px
+		}
+		img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = px.r, px.g, px.b, px.a
+	}
+	return img, nil
+}
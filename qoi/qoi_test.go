@@ -0,0 +1,146 @@
+package qoi_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"weblinuxgui/qoi"
+)
+
+// roundTrip encodes img and decodes the result.
+func roundTrip(t *testing.T, img image.Image) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := qoi.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	out, err := qoi.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds().Size() != img.Bounds().Size() {
+		t.Fatalf("decoded %v, want %v", out.Bounds().Size(), img.Bounds().Size())
+	}
+	return out
+}
+
+// sameColors fails unless a and b have the same non-premultiplied colors.
+func sameColors(t *testing.T, a, b image.Image) {
+	t.Helper()
+	ab, bb := a.Bounds(), b.Bounds()
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			ca := color.NRGBAModel.Convert(a.At(ab.Min.X+x, ab.Min.Y+y))
+			cb := color.NRGBAModel.Convert(b.At(bb.Min.X+x, bb.Min.Y+y))
+			if ca != cb {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, cb, ca)
+			}
+		}
+	}
+}
+
+func TestRoundTripTranslucentRGBA(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i, c := range []color.NRGBA{
+		{255, 0, 0, 255}, {255, 0, 0, 128}, {10, 200, 30, 64}, {0, 0, 0, 0},
+		{255, 255, 255, 1}, {90, 90, 90, 200}, {90, 90, 90, 200}, {1, 2, 3, 254},
+	} {
+		img.Set(i%4, i/4, c) // stored premultiplied
+	}
+	out := roundTrip(t, img)
+	sameColors(t, img, out)
+	// A pixel at half alpha keeps its full red in QOI's straight alpha
+	if c := color.NRGBAModel.Convert(out.At(1, 0)).(color.NRGBA); c.R != 255 || c.A != 128 {
+		t.Errorf("half transparent red decoded as %v", c)
+	}
+}
+
+func TestRoundTripOpaque(t *testing.T) {
+	// Runs, small differences, index hits and full colors
+	img := image.NewRGBA(image.Rect(0, 0, 64, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.RGBA{uint8(x / 8 * 30), uint8(y), uint8(x * y), 255}
+			if x%16 == 5 {
+				c = color.RGBA{uint8(x * 37), uint8(y * 91), 7, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	sameColors(t, img, roundTrip(t, img))
+	// A sub-image keeps its own bounds
+	sub := img.SubImage(image.Rect(3, 2, 20, 9))
+	sameColors(t, sub, roundTrip(t, sub))
+}
+
+func TestDecodeConfig(t *testing.T) {
+	var buf bytes.Buffer
+	if err := qoi.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 7, 3))); err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "qoi" || cfg.Width != 7 || cfg.Height != 3 {
+		t.Errorf("DecodeConfig = %+v %q", cfg, format)
+	}
+}
+
+// header returns a QOI header.
+func header(magic string, w, h uint32, channels byte) []byte {
+	b := append([]byte(magic), make([]byte, 10)...)
+	binary.BigEndian.PutUint32(b[4:], w)
+	binary.BigEndian.PutUint32(b[8:], h)
+	b[12] = channels
+	return b
+}
+
+func TestMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, io.EOF},
+		{"short header", []byte("qoif\x00\x00"), io.ErrUnexpectedEOF},
+		{"bad magic", header("qoiz", 1, 1, 4), qoi.ErrFormat},
+		{"zero width", header("qoif", 0, 1, 4), qoi.ErrFormat},
+		{"zero height", header("qoif", 1, 0, 4), qoi.ErrFormat},
+		{"too large", header("qoif", 1<<20, 1<<20, 4), qoi.ErrFormat},
+		{"bad channels", header("qoif", 1, 1, 5), qoi.ErrFormat},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := qoi.DecodeConfig(bytes.NewReader(tc.data)); !errors.Is(err, tc.want) {
+				t.Errorf("DecodeConfig: %v, want %v", err, tc.want)
+			}
+			if _, err := qoi.Decode(bytes.NewReader(tc.data)); !errors.Is(err, tc.want) {
+				t.Errorf("Decode: %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 13)
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	var buf bytes.Buffer
+	if err := qoi.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	// Cut inside the pixel data
+	cut := buf.Bytes()[:buf.Len()/2]
+	if _, err := qoi.Decode(bytes.NewReader(cut)); !errors.Is(err, qoi.ErrFormat) {
+		t.Errorf("truncated data: %v, want ErrFormat", err)
+	}
+}
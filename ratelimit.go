@@ -0,0 +1,56 @@
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimit admits at most n requests per window from each client address
+// and answers the rest with 429.
+type rateLimit struct {
+	n      int
+	window time.Duration
+
+	mu    sync.Mutex
+	start time.Time
+	seen  map[string]int
+}
+
+func newRateLimit(n int, window time.Duration) *rateLimit {
+	return &rateLimit{n: n, window: window, seen: make(map[string]int)}
+}
+
+// allow counts a request from addr (host:port or a bare host).
+func (l *rateLimit) allow(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Fixed windows: forgetting every address at once keeps the map bounded
+	if now := time.Now(); now.Sub(l.start) >= l.window {
+		l.start = now
+		clear(l.seen)
+	}
+	if l.seen[addr] >= l.n {
+		return false
+	}
+	l.seen[addr]++
+	return true
+}
+
+func (l *rateLimit) wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(r.RemoteAddr) {
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(l.window.Seconds()), 1)))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	l := newRateLimit(2, time.Hour)
+	h := l.wrap(func(w http.ResponseWriter, r *http.Request) {})
+	for i, tc := range []struct {
+		remote string
+		want   int
+	}{
+		{"192.0.2.7:5000", http.StatusOK},
+		{"192.0.2.7:5001", http.StatusOK},
+		{"192.0.2.7:5002", http.StatusTooManyRequests},
+		{"192.0.2.8:5000", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/config", nil)
+		r.RemoteAddr = tc.remote
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tc.want {
+			t.Errorf("request %d from %s: status %d, want %d", i, tc.remote, w.Code, tc.want)
+		}
+	}
+	l.start = l.start.Add(-time.Hour)
+	if !l.allow("192.0.2.7:5003") {
+		t.Error("limit not reset after the window")
+	}
+}
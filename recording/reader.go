@@ -0,0 +1,85 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Find returns the segment called name in dir. Names come from List, so
+// anything that is not a plain segment name (e.g. contains a path
+// separator) is rejected.
+func Find(dir, name string) (Segment, error) {
+	if !strings.HasPrefix(name, "rec-") || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return Segment{}, fmt.Errorf("invalid recording name %q", name)
+	}
+	stamp := strings.TrimPrefix(name, "rec-")
+	if len(stamp) > len(nameLayout) {
+		// Segments started in the same millisecond have a -NNN suffix
+		seq, ok := strings.CutPrefix(stamp[len(nameLayout):], "-")
+		if !ok || seq == "" || strings.Trim(seq, "0123456789") != "" {
+			return Segment{}, fmt.Errorf("invalid recording name %q", name)
+		}
+		stamp = stamp[:len(nameLayout)]
+	}
+	start, err := time.ParseInLocation(nameLayout, stamp, time.Local)
+	if err != nil {
+		return Segment{}, fmt.Errorf("invalid recording name %q", name)
+	}
+	s := Segment{Name: name, Path: filepath.Join(dir, name), Start: start}
+	for _, ext := range []string{".jsonl", ".mjpeg"} {
+		fi, err := os.Stat(s.Path + ext)
+		if err != nil {
+			return Segment{}, err
+		}
+		s.Size += fi.Size()
+	}
+	return s, nil
+}
+
+// Entries reads the segment index. A truncated last line (segment still
+// being written or a crash) is ignored.
+func (s Segment) Entries() ([]Entry, error) {
+	f, err := os.Open(s.Path + ".jsonl")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// ReadFrame returns the JPEG at off/len in the segment's frame file.
+func (s Segment) ReadFrame(off int64, n int) ([]byte, error) {
+	f, err := os.Open(s.Path + ".mjpeg")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if off < 0 || n <= 0 || off+int64(n) > fi.Size() {
+		return nil, fmt.Errorf("frame %d+%d out of range", off, n)
+	}
+	b := make([]byte, n)
+	if _, err := f.ReadAt(b, off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
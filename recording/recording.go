@@ -0,0 +1,357 @@
+package recording
+
+// Package recording writes an audit trail of remote sessions: every streamed
+// JPEG frame and every received input event, with timestamps. A recording
+// is split into segments; each segment is a pair of files:
+//
+//	rec-20261018-115630.123.mjpeg  concatenated JPEG frames
+//	rec-20261018-115630.123.jsonl  one Entry per line, in time order
+//
+// A segment started in the same millisecond as an existing one gets a
+// sequence suffix, e.g. rec-20261018-115630.123-001.
+//
+// Frame entries point into the .mjpeg file by offset and length, so a
+// segment can be played back or seeked without scanning the JPEG data.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"weblinuxgui/audit"
+	"weblinuxgui/config/setting"
+)
+
+// Entry kinds.
+const (
+	KindStart = "start" // a session started
+	KindEnd   = "end"   // a session ended
+	KindFrame = "frame"
+	KindInput = "input"
+)
+
+// Entry is one line of a segment index.
+type Entry struct {
+	// T is the wall clock time in Unix milliseconds.
+	T       int64  `json:"t"`
+	Kind    string `json:"kind"`
+	Session string `json:"session,omitempty"`
+	// Frame entries
+	Offset int64 `json:"off,omitempty"`
+	Len    int   `json:"len,omitempty"`
+	MouseX int   `json:"mouseX,omitempty"`
+	MouseY int   `json:"mouseY,omitempty"`
+	// Input entries carry the event as received from the browser
+	Event json.RawMessage `json:"event,omitempty"`
+}
+
+// Config controls recording, rotation and retention.
+type Config struct {
+	Dir string
+	// SegmentBytes and SegmentDuration start a new segment once the current
+	// one is this large or old (0 = no limit).
+	SegmentBytes    int64
+	SegmentDuration time.Duration
+	// MaxAge deletes segments older than this (0 = keep forever).
+	MaxAge time.Duration
+	// MaxBytes deletes the oldest segments while all segments together
+	// exceed this size (0 = no limit).
+	MaxBytes int64
+	// Keys is audit.KeysOff (the default), KeysRedact or KeysFull, applied
+	// to recorded key events and pastes like in the audit log.
+	Keys string
+}
+
+// Default returns the configuration with the default rotation; recording
+// stays off until Dir is set.
+func Default() Config {
+	return Config{SegmentBytes: 100 << 20, SegmentDuration: 30 * time.Minute, Keys: audit.KeysOff}
+}
+
+// Enabled reports whether Dir is set.
+func (c Config) Enabled() bool { return c.Dir != "" }
+
+// Settings lists the recording settings, bound to c. Keys comes from
+// AUDIT_KEYS, which the audit settings declare.
+func (c *Config) Settings() []*setting.Setting {
+	return []*setting.Setting{
+		{Env: "RECORD_DIR", Usage: "directory for session recordings; enables recording", Value: setting.String(&c.Dir)},
+		{Env: "RECORD_SEGMENT_MB", Usage: "start a new segment after this many MB; 0 for no limit", Value: setting.MB(&c.SegmentBytes)},
+		{Env: "RECORD_SEGMENT_DURATION", Usage: "start a new segment after this long; 0 for no limit", Value: setting.Duration(&c.SegmentDuration, 0)},
+		{Env: "RECORD_RETENTION", Usage: "delete segments older than this; 0 keeps them", Value: setting.Duration(&c.MaxAge, 0)},
+		{Env: "RECORD_MAX_MB", Usage: "delete the oldest segments above this total; 0 for no limit", Value: setting.MB(&c.MaxBytes)},
+	}
+}
+
+// FromEnv reads the recording configuration, and AUDIT_KEYS, from the
+// environment alone. It returns ok=false when RECORD_DIR is not set.
+func FromEnv() (cfg Config, ok bool, err error) {
+	cfg = Default()
+	err = setting.FromEnv(append(cfg.Settings(), audit.KeysSetting(&cfg.Keys))...)
+	return cfg, cfg.Enabled(), err
+}
+
+// Writer appends frames and input events to the current segment. It is
+// safe for concurrent use. Segments are created lazily and removed again if
+// they end up without frames or input, so an idle peer does not leave empty
+// files behind. Frames and input outside the current session are dropped.
+type Writer struct {
+	cfg Config
+
+	mu        sync.Mutex
+	name      string // current segment base path, "" when none is open
+	started   time.Time
+	data      *os.File
+	index     *os.File
+	size      int64
+	records   int    // frames and input events in the current segment
+	session   string // current session, repeated at the top of new segments
+	inSession bool
+}
+
+// NewWriter creates the directory and applies retention once.
+func NewWriter(cfg Config) (*Writer, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("recording dir: %w", err)
+	}
+	if cfg.Keys == "" {
+		cfg.Keys = audit.KeysOff
+	}
+	w := &Writer{cfg: cfg}
+	w.prune()
+	return w, nil
+}
+
+// StartSession marks the start of a session. A new segment is started so
+// each segment begins with its session.
+func (w *Writer) StartSession(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.name != "" {
+		w.closeSegmentLocked()
+	}
+	w.session, w.inSession = id, true
+	// openSegmentLocked writes the start entry
+	if err := w.openSegmentLocked(); err != nil {
+		slog.Error("recording segment", "err", err)
+	}
+}
+
+// EndSession marks the end of a session and closes its segment.
+func (w *Writer) EndSession(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.inSession || w.session != id {
+		// Already replaced by a newer session
+		return
+	}
+	w.session, w.inSession = "", false
+	if w.name == "" {
+		return
+	}
+	w.appendLocked(Entry{Kind: KindEnd, Session: id})
+	w.closeSegmentLocked()
+}
+
+// Frame records one JPEG frame as streamed with the cursor position.
+func (w *Writer) Frame(jpeg []byte, mouseX, mouseY int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.inSession {
+		// Still in the pipeline when the session ended
+		return
+	}
+	if err := w.rotateLocked(int64(len(jpeg))); err != nil {
+		slog.Error("recording frame", "err", err)
+		return
+	}
+	off := w.size
+	if _, err := w.data.Write(jpeg); err != nil {
+		slog.Error("recording frame", "err", err)
+		return
+	}
+	w.size += int64(len(jpeg))
+	w.records++
+	w.appendLocked(Entry{Kind: KindFrame, Offset: off, Len: len(jpeg), MouseX: mouseX, MouseY: mouseY})
+}
+
+// Input records one input event as received from the browser, with keys
+// and pastes limited by Config.Keys.
+func (w *Writer) Input(session string, event []byte) {
+	if event = redactInput(w.cfg.Keys, event); event == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.inSession || session != w.session {
+		return
+	}
+	if err := w.rotateLocked(0); err != nil {
+		slog.Error("recording input", "err", err)
+		return
+	}
+	w.records++
+	w.appendLocked(Entry{Kind: KindInput, Session: session, Event: json.RawMessage(append([]byte(nil), event...))})
+}
+
+// redactInput applies keys to an input event: KeysOff drops key events,
+// KeysRedact keeps named keys but shows characters as "*", and pastes keep
+// only their length unless keys is KeysFull. It returns nil for events that
+// are not recorded.
+func redactInput(keys string, event []byte) []byte {
+	if keys == audit.KeysFull {
+		return event
+	}
+	var ev map[string]any
+	if err := json.Unmarshal(event, &ev); err != nil {
+		return nil
+	}
+	switch ev["type"] {
+	case "keydown", "keyup":
+		if keys != audit.KeysRedact {
+			return nil
+		}
+		key, _ := ev["key"].(string)
+		if r := audit.RedactKey(key); r != key {
+			// The key code would give the character away
+			ev["key"] = r
+			delete(ev, "keyCode")
+		}
+	case "paste":
+		text, _ := ev["clipboardText"].(string)
+		delete(ev, "clipboardText")
+		ev["length"] = utf8.RuneCountInString(text)
+	default:
+		return event
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// Close flushes and closes the current segment.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeSegmentLocked()
+}
+
+// rotateLocked makes sure a segment is open with room for n more bytes.
+func (w *Writer) rotateLocked(n int64) error {
+	if w.name != "" {
+		full := w.cfg.SegmentBytes > 0 && w.size > 0 && w.size+n > w.cfg.SegmentBytes
+		old := w.cfg.SegmentDuration > 0 && time.Since(w.started) >= w.cfg.SegmentDuration
+		if !full && !old {
+			return nil
+		}
+		w.closeSegmentLocked()
+	}
+	return w.openSegmentLocked()
+}
+
+func (w *Writer) openSegmentLocked() error {
+	now := time.Now()
+	base := filepath.Join(w.cfg.Dir, "rec-"+now.Format(nameLayout))
+	name := base
+	var data *os.File
+	for seq := 1; ; seq++ {
+		var err error
+		data, err = os.OpenFile(name+".mjpeg", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) || seq > maxSeq {
+			return err
+		}
+		// Another segment started in this millisecond
+		name = fmt.Sprintf("%s-%03d", base, seq)
+	}
+	idx, err := os.OpenFile(name+".jsonl", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		data.Close()
+		os.Remove(name + ".mjpeg")
+		return err
+	}
+	w.name, w.started, w.data, w.index, w.size, w.records = name, now, data, idx, 0, 0
+	if w.inSession {
+		// Sessions spanning several segments are repeated at the top of each
+		w.appendLocked(Entry{Kind: KindStart, Session: w.session})
+	}
+	return nil
+}
+
+func (w *Writer) closeSegmentLocked() error {
+	if w.name == "" {
+		return nil
+	}
+	err := w.index.Close()
+	if cerr := w.data.Close(); err == nil {
+		err = cerr
+	}
+	if w.records == 0 {
+		// Only session markers; nothing to play back
+		err = Segment{Path: w.name}.Remove()
+	}
+	w.name, w.data, w.index = "", nil, nil
+	go w.prune()
+	return err
+}
+
+// appendLocked writes an index entry, opening a segment if needed.
+func (w *Writer) appendLocked(e Entry) {
+	if w.name == "" {
+		if err := w.openSegmentLocked(); err != nil {
+			slog.Error("recording segment", "err", err)
+			return
+		}
+	}
+	e.T = time.Now().UnixMilli()
+	b, _ := json.Marshal(e)
+	_, _ = w.index.Write(append(b, '\n'))
+}
+
+// prune applies MaxAge and MaxBytes to closed segments.
+func (w *Writer) prune() {
+	if w.cfg.MaxAge <= 0 && w.cfg.MaxBytes <= 0 {
+		return
+	}
+	segs, err := List(w.cfg.Dir)
+	if err != nil {
+		slog.Warn("recording retention", "err", err)
+		return
+	}
+	w.mu.Lock()
+	current := w.name
+	w.mu.Unlock()
+	var total int64
+	for _, s := range segs {
+		total += s.Size
+	}
+	// Oldest first
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Name < segs[j].Name })
+	for _, s := range segs {
+		if s.Path == current {
+			continue
+		}
+		expired := w.cfg.MaxAge > 0 && time.Since(s.Start) > w.cfg.MaxAge
+		tooBig := w.cfg.MaxBytes > 0 && total > w.cfg.MaxBytes
+		if !expired && !tooBig {
+			continue
+		}
+		if err := s.Remove(); err != nil {
+			slog.Warn("recording retention", "err", err)
+			continue
+		}
+		total -= s.Size
+	}
+}
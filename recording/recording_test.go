@@ -0,0 +1,262 @@
+package recording_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"weblinuxgui/audit"
+	"weblinuxgui/recording"
+)
+
+// entries returns the entries of all segments in dir, oldest first.
+func entries(t *testing.T, dir string) []recording.Entry {
+	t.Helper()
+	segs, err := recording.List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Name < segs[j].Name })
+	var all []recording.Entry
+	for _, s := range segs {
+		es, err := s.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, es...)
+	}
+	return all
+}
+
+func TestInputKeys(t *testing.T) {
+	events := []string{
+		`{"type":"keydown","key":"s","keyCode":83}`,
+		`{"type":"keydown","key":"Enter","keyCode":13}`,
+		`{"type":"paste","clipboardText":"hunter2"}`,
+		`{"type":"mousedown","x":1,"y":2,"button":"left"}`,
+	}
+	for _, tc := range []struct {
+		keys string
+		want []string
+	}{
+		{audit.KeysOff, []string{
+			`{"length":7,"type":"paste"}`,
+			`{"type":"mousedown","x":1,"y":2,"button":"left"}`,
+		}},
+		{audit.KeysRedact, []string{
+			`{"key":"*","type":"keydown"}`,
+			`{"key":"Enter","keyCode":13,"type":"keydown"}`,
+			`{"length":7,"type":"paste"}`,
+			`{"type":"mousedown","x":1,"y":2,"button":"left"}`,
+		}},
+		{audit.KeysFull, events},
+	} {
+		t.Run(tc.keys, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := recording.NewWriter(recording.Config{Dir: dir, Keys: tc.keys})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.StartSession("s1")
+			for _, ev := range events {
+				w.Input("s1", []byte(ev))
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries(t, dir) {
+				if e.Kind == recording.KindInput {
+					got = append(got, string(e.Event))
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("recorded:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+// segments returns the segments in dir, oldest first.
+func segments(t *testing.T, dir string) []recording.Segment {
+	t.Helper()
+	segs, err := recording.List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Name < segs[j].Name })
+	return segs
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := recording.NewWriter(recording.Config{Dir: dir, SegmentBytes: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.StartSession("s1")
+	frames := [][]byte{bytes.Repeat([]byte{1}, 60), bytes.Repeat([]byte{2}, 60), bytes.Repeat([]byte{3}, 30)}
+	// Rotations within a millisecond get distinct names
+	for _, f := range frames {
+		w.Frame(f, 1, 2)
+	}
+	w.EndSession("s1")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segs := segments(t, dir)
+	if len(segs) != 2 {
+		t.Fatalf("%d segments, want 2 (100 byte limit)", len(segs))
+	}
+	var read [][]byte
+	for i, s := range segs {
+		es, err := s.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every segment opens with its session
+		if es[0].Kind != recording.KindStart || es[0].Session != "s1" {
+			t.Errorf("segment %d starts with %+v", i, es[0])
+		}
+		for _, e := range es {
+			if e.Kind != recording.KindFrame {
+				continue
+			}
+			b, err := s.ReadFrame(e.Offset, e.Len)
+			if err != nil {
+				t.Fatal(err)
+			}
+			read = append(read, b)
+		}
+	}
+	if len(read) != len(frames) {
+		t.Fatalf("read %d frames, want %d", len(read), len(frames))
+	}
+	for i := range frames {
+		if !bytes.Equal(read[i], frames[i]) {
+			t.Errorf("frame %d differs", i)
+		}
+	}
+	if es := entries(t, dir); es[len(es)-1].Kind != recording.KindEnd {
+		t.Errorf("last entry %+v, want end", es[len(es)-1])
+	}
+}
+
+func TestRotationByDuration(t *testing.T) {
+	dir := t.TempDir()
+	w, err := recording.NewWriter(recording.Config{Dir: dir, SegmentDuration: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.StartSession("s1")
+	w.Frame([]byte{1}, 0, 0)
+	time.Sleep(60 * time.Millisecond)
+	w.Frame([]byte{2}, 0, 0)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(segments(t, dir)); n != 2 {
+		t.Fatalf("%d segments, want 2", n)
+	}
+}
+
+func TestNoEmptySegments(t *testing.T) {
+	dir := t.TempDir()
+	w, err := recording.NewWriter(recording.Config{Dir: dir, SegmentBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A session without frames or input leaves nothing
+	w.StartSession("idle")
+	w.EndSession("idle")
+	w.StartSession("s1")
+	w.Frame(make([]byte, 8), 0, 0)
+	w.Input("s1", []byte(`{"type":"mousemove","x":1,"y":1}`))
+	w.EndSession("s1")
+	// Frames still in the pipeline and late input do not open a segment
+	w.Frame(make([]byte, 8), 0, 0)
+	w.Input("s1", []byte(`{"type":"mousemove","x":2,"y":2}`))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segs := segments(t, dir)
+	if len(segs) != 1 {
+		t.Fatalf("%d segments, want 1", len(segs))
+	}
+	es, err := segs[0].Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 4 || es[0].Session != "s1" || es[3].Kind != recording.KindEnd {
+		t.Errorf("entries %+v", es)
+	}
+}
+
+func TestFindSequenceSuffix(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, dir, time.Date(2026, 10, 18, 11, 56, 30, 123e6, time.Local))
+	for _, ext := range []string{".jsonl", ".mjpeg"} {
+		if err := os.WriteFile(filepath.Join(dir, "rec-20261018-115630.123-001"+ext), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segs := segments(t, dir)
+	if len(segs) != 2 || segs[1].Name != "rec-20261018-115630.123-001" || !segs[1].Start.Equal(segs[0].Start) {
+		t.Fatalf("segments %+v", segs)
+	}
+	for _, bad := range []string{"rec-20261018-115630.123-", "rec-20261018-115630.123x1", "rec-20261018-115630.123-01a", "rec-../x"} {
+		if _, err := recording.Find(dir, bad); err == nil {
+			t.Errorf("Find(%q) accepted", bad)
+		}
+	}
+}
+
+// touch writes an empty segment named after start.
+func touch(t *testing.T, dir string, start time.Time) {
+	t.Helper()
+	base := filepath.Join(dir, "rec-"+start.Format("20060102-150405.000"))
+	for _, ext := range []string{".jsonl", ".mjpeg"} {
+		if err := os.WriteFile(base+ext, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, dir, time.Now().Add(-48*time.Hour))
+	touch(t, dir, time.Now().Add(-time.Hour))
+	w, err := recording.NewWriter(recording.Config{Dir: dir, MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	segs := segments(t, dir)
+	if len(segs) != 1 || time.Since(segs[0].Start) > 24*time.Hour {
+		t.Fatalf("segments after retention: %+v", segs)
+	}
+}
+
+func TestRetentionMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	for i := range 3 {
+		touch(t, dir, time.Now().Add(time.Duration(i-3)*time.Minute))
+	}
+	// Each segment is 2 bytes; keep the newest two
+	w, err := recording.NewWriter(recording.Config{Dir: dir, MaxBytes: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	segs := segments(t, dir)
+	if len(segs) != 2 {
+		t.Fatalf("%d segments after retention, want 2", len(segs))
+	}
+	if time.Since(segs[0].Start) > 150*time.Second {
+		t.Error("the oldest segment was kept")
+	}
+}
@@ -0,0 +1,56 @@
+package recording
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	nameLayout = "20060102-150405.000"
+	// maxSeq bounds the suffixes of segments started in the same millisecond
+	maxSeq = 999
+)
+
+// Segment is one recorded .mjpeg/.jsonl pair.
+type Segment struct {
+	// Name is the base name without extension, e.g. "rec-20261018-115630.123".
+	Name string
+	// Path is the base path without extension.
+	Path  string
+	Start time.Time
+	// Size is the combined size of both files.
+	Size int64
+}
+
+// List returns the segments in dir, newest first.
+func List(dir string) ([]Segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []Segment
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok || !strings.HasPrefix(name, "rec-") {
+			continue
+		}
+		s, err := Find(dir, name)
+		if err != nil {
+			continue
+		}
+		segs = append(segs, s)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Name > segs[j].Name })
+	return segs, nil
+}
+
+// Remove deletes both files of the segment.
+func (s Segment) Remove() error {
+	err := os.Remove(s.Path + ".jsonl")
+	if merr := os.Remove(s.Path + ".mjpeg"); err == nil && !os.IsNotExist(merr) {
+		err = merr
+	}
+	return err
+}
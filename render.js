@@ -0,0 +1,115 @@
+// Canvas renderer shared by the live page (index.html) and the playback page.
+// Frames are letterboxed into the canvas; scale/offset are kept so callers can
+// map between page and remote screen coordinates.
+function createRenderer(canvas) {
+    const ctx = canvas.getContext('2d');
+    // drawArrow: draw the built-in arrow at mouseX/mouseY (off once the peer streams its cursor image)
+    const r = { ctx, scale: 1, offsetX: 0, offsetY: 0, serverWidth: 1, serverHeight: 1, lastUpdate: null, onDraw: null, drawArrow: true };
+
+    function drawCursor(x, y) {
+        ctx.fillStyle = 'white'; ctx.strokeStyle = 'black'; ctx.lineWidth = 1;
+        ctx.beginPath(); ctx.moveTo(x, y); ctx.lineTo(x, y + 14); ctx.lineTo(x + 10, y + 10); ctx.closePath();
+        ctx.fill(); ctx.stroke();
+    }
+
+    function paint(update, src, w, h) {
+        ctx.clearRect(0, 0, canvas.width, canvas.height);
+        r.serverWidth = w; r.serverHeight = h;
+        r.scale = Math.min(canvas.width / r.serverWidth, canvas.height / r.serverHeight);
+        const newW = r.serverWidth * r.scale, newH = r.serverHeight * r.scale;
+        r.offsetX = (canvas.width - newW) / 2; r.offsetY = (canvas.height - newH) / 2;
+        ctx.imageSmoothingEnabled = r.scale !== 1;
+        ctx.drawImage(src, r.offsetX, r.offsetY, newW, newH);
+        if (r.drawArrow) {
+            const p = r.toCanvas(update.mouseX, update.mouseY);
+            drawCursor(p.x, p.y);
+        }
+        if (r.onDraw) r.onDraw(update);
+    }
+
+    // update: { image (base64), src (URL) or canvas (decoded), format ('jpeg' when absent, 'png' or 'qoi'), mouseX, mouseY }
+    r.draw = function (update) {
+        if (!update || !(update.image || update.src || update.canvas)) return;
+        r.lastUpdate = update;
+        loadImage(update, (src, w, h) => paint(update, src, w, h));
+    };
+
+    // Remote screen -> canvas coordinates
+    r.toCanvas = (x, y) => ({ x: r.offsetX + x * r.scale, y: r.offsetY + y * r.scale });
+
+    // Page (clientX/Y) -> remote screen coordinates, null outside the image
+    r.toRemote = function (clientX, clientY) {
+        const rect = canvas.getBoundingClientRect();
+        const x = clientX - rect.left, y = clientY - rect.top;
+        if (x < r.offsetX || x > r.offsetX + r.serverWidth * r.scale || y < r.offsetY || y > r.offsetY + r.serverHeight * r.scale) return null;
+        return { x: Math.round((x - r.offsetX) / r.scale), y: Math.round((y - r.offsetY) / r.scale) };
+    };
+
+    r.resize = function () {
+        canvas.width = canvas.clientWidth; canvas.height = canvas.clientHeight; r.draw(r.lastUpdate);
+    };
+    window.addEventListener('resize', r.resize); r.resize();
+    return r;
+}
+
+// loadImage decodes an update's image and calls done(source, width, height)
+// with something drawImage accepts.
+function loadImage(update, done) {
+    if (update.canvas) { done(update.canvas, update.canvas.width, update.canvas.height); return; }
+    if (update.format === 'qoi' && update.image) {
+        // Decoded once and kept as update.canvas, resize redraws reuse it
+        const data = decodeQOI(Uint8Array.from(atob(update.image), c => c.charCodeAt(0)));
+        if (!data) return;
+        update.canvas = document.createElement('canvas');
+        update.canvas.width = data.width; update.canvas.height = data.height;
+        update.canvas.getContext('2d').putImageData(data, 0, 0);
+        done(update.canvas, data.width, data.height);
+        return;
+    }
+    const img = new Image();
+    img.onload = () => done(img, img.naturalWidth, img.naturalHeight);
+    img.src = update.src || 'data:image/' + (update.format || 'jpeg') + ';base64,' + update.image;
+}
+
+// decodeQOI decodes a QOI image (https://qoiformat.org) into ImageData, or
+// returns null for invalid data.
+function decodeQOI(bytes) {
+    if (bytes.length < 22 || String.fromCharCode(bytes[0], bytes[1], bytes[2], bytes[3]) !== 'qoif') return null;
+    const view = new DataView(bytes.buffer, bytes.byteOffset, bytes.byteLength);
+    const width = view.getUint32(4), height = view.getUint32(8);
+    if (!width || !height || width * height > 400000000) return null;
+    const out = new ImageData(width, height), px = out.data;
+    const index = new Uint8Array(64 * 4);
+    let r = 0, g = 0, b = 0, a = 255, run = 0, p = 14;
+    const end = bytes.length - 8;
+    for (let o = 0; o < px.length; o += 4) {
+        if (run > 0) {
+            run--;
+        } else if (p < end) {
+            const b1 = bytes[p++];
+            if (b1 === 0xfe) {
+                r = bytes[p++]; g = bytes[p++]; b = bytes[p++];
+            } else if (b1 === 0xff) {
+                r = bytes[p++]; g = bytes[p++]; b = bytes[p++]; a = bytes[p++];
+            } else if ((b1 & 0xc0) === 0x00) {
+                const i = b1 * 4;
+                r = index[i]; g = index[i + 1]; b = index[i + 2]; a = index[i + 3];
+            } else if ((b1 & 0xc0) === 0x40) {
+                r = (r + ((b1 >> 4) & 3) - 2) & 0xff;
+                g = (g + ((b1 >> 2) & 3) - 2) & 0xff;
+                b = (b + (b1 & 3) - 2) & 0xff;
+            } else if ((b1 & 0xc0) === 0x80) {
+                const b2 = bytes[p++], dg = (b1 & 0x3f) - 32;
+                r = (r + dg - 8 + ((b2 >> 4) & 0x0f)) & 0xff;
+                g = (g + dg) & 0xff;
+                b = (b + dg - 8 + (b2 & 0x0f)) & 0xff;
+            } else {
+                run = b1 & 0x3f;
+            }
+            const i = ((r * 3 + g * 5 + b * 7 + a * 11) % 64) * 4;
+            index[i] = r; index[i + 1] = g; index[i + 2] = b; index[i + 3] = a;
+        }
+        px[o] = r; px[o + 1] = g; px[o + 2] = b; px[o + 3] = a;
+    }
+    return out;
+}
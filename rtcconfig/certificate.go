@@ -0,0 +1,133 @@
+package rtcconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// certificateValidity is long on purpose: DTLS certificates are
+// authenticated by the fingerprint in the SDP, and a pinned fingerprint
+// must not change when pion would otherwise rotate a short-lived one.
+const certificateValidity = 10 * 365 * 24 * time.Hour
+
+// DefaultCertificatePath is where the peer keeps its DTLS certificate when
+// DTLS_CERT is unset.
+func DefaultCertificatePath() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		base = "."
+	}
+	return filepath.Join(base, "weblinuxgui", "dtls.pem")
+}
+
+// LoadCertificate reads a DTLS certificate and its private key (PEM, in one
+// file) from path, generating and storing an ECDSA P-256 one when the file
+// does not exist. The certificate keeps its fingerprint across restarts, so
+// the server can pin it.
+func LoadCertificate(path string) (*webrtc.Certificate, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if b, err = generateCertificate(); err != nil {
+			return nil, fmt.Errorf("generate DTLS certificate: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, fmt.Errorf("DTLS certificate dir: %w", err)
+		}
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			return nil, fmt.Errorf("write DTLS certificate: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("read DTLS certificate: %w", err)
+	}
+	return parseCertificate(b)
+}
+
+func parseCertificate(b []byte) (*webrtc.Certificate, error) {
+	var (
+		cert *x509.Certificate
+		key  any
+	)
+	for {
+		var block *pem.Block
+		if block, b = pem.Decode(b); block == nil {
+			break
+		}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err = x509.ParseCertificate(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse DTLS certificate: %w", err)
+		}
+	}
+	if cert == nil || key == nil {
+		return nil, fmt.Errorf("DTLS certificate file needs a CERTIFICATE and a PRIVATE KEY block")
+	}
+	if time.Now().After(cert.NotAfter) {
+		return nil, fmt.Errorf("DTLS certificate expired on %s", cert.NotAfter.Format(time.DateOnly))
+	}
+	c := webrtc.CertificateFromX509(key, cert)
+	return &c, nil
+}
+
+func generateCertificate() ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "weblinuxgui peer"},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.Add(certificateValidity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...), nil
+}
+
+// Fingerprint returns the certificate's SHA-256 fingerprint as it appears
+// in the SDP ("sha-256 AB:CD:..."), the form PEER_FINGERPRINT takes.
+func Fingerprint(c *webrtc.Certificate) (string, error) {
+	fps, err := c.GetFingerprints()
+	if err != nil {
+		return "", err
+	}
+	for _, fp := range fps {
+		if fp.Algorithm == "sha-256" {
+			return fp.Algorithm + " " + strings.ToUpper(fp.Value), nil
+		}
+	}
+	return "", fmt.Errorf("no sha-256 fingerprint")
+}
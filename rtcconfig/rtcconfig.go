@@ -0,0 +1,284 @@
+package rtcconfig
+
+// Package rtcconfig holds the ICE/WebRTC transport settings shared by the
+// browser page, the HTTP server and the Windows peer, so that both ends of a
+// session agree on STUN/TURN servers and transport policy.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"weblinuxgui/config/setting"
+
+	pionlogging "github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+// DefaultSTUN is used when no ICE servers are configured.
+const DefaultSTUN = "stun:stun.l.google.com:19302"
+
+// ICEServer mirrors the browser's RTCIceServer dictionary.
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// Config is the ICE configuration. It can be loaded from a JSON file and
+// overridden by settings (see Options).
+type Config struct {
+	ICEServers []ICEServer `json:"iceServers"`
+	// ICETransportPolicy is "all" (default) or "relay" (TURN only).
+	ICETransportPolicy string `json:"iceTransportPolicy,omitempty"`
+	// UDPPortMin/UDPPortMax limit the local ports used for ICE candidates.
+	UDPPortMin uint16 `json:"udpPortMin,omitempty"`
+	UDPPortMax uint16 `json:"udpPortMax,omitempty"`
+	// NAT1To1IPs advertises these public IPs as host candidates.
+	NAT1To1IPs []string `json:"nat1to1IPs,omitempty"`
+	// Interfaces, when non-empty, restricts gathering to these interface names.
+	Interfaces []string `json:"interfaces,omitempty"`
+	// ExcludeInterfaces skips these interface names during gathering.
+	ExcludeInterfaces []string `json:"excludeInterfaces,omitempty"`
+	// IncludeLoopback gathers loopback candidates, for sessions on one host.
+	IncludeLoopback bool `json:"includeLoopback,omitempty"`
+	// Certificates are the DTLS certificates of the peer (see
+	// LoadCertificate); empty means a new one per PeerConnection.
+	Certificates []webrtc.Certificate `json:"-"`
+	// LoggerFactory, if set, receives pion's ICE/DTLS/SCTP logs (see
+	// logging.PionFactory).
+	LoggerFactory pionlogging.LoggerFactory `json:"-"`
+}
+
+// BrowserConfig is the subset of Config that the page passes to
+// RTCPeerConnection. It is served as JSON from /config.
+type BrowserConfig struct {
+	ICEServers         []ICEServer `json:"iceServers"`
+	ICETransportPolicy string      `json:"iceTransportPolicy"`
+}
+
+// Default returns the built-in configuration (public Google STUN only).
+func Default() Config {
+	return Config{
+		ICEServers:         []ICEServer{{URLs: []string{DefaultSTUN}}},
+		ICETransportPolicy: "all",
+	}
+}
+
+// Options are the ICE settings: the JSON file ICE_CONFIG, and the
+// variables that override it when set (see Settings).
+type Options struct {
+	File string
+	// Servers replaces the server list; "none" empties it.
+	Servers []string
+	// TURNUsername and TURNCredential apply to the turn:/turns: URLs in
+	// Servers.
+	TURNUsername, TURNCredential string
+	TransportPolicy              string
+	UDPPortMin, UDPPortMax       uint16
+	NAT1To1IPs                   []string
+	Interfaces                   []string
+	ExcludeInterfaces            []string
+	IncludeLoopback              bool
+}
+
+// Settings lists the ICE settings, bound to o.
+func (o *Options) Settings() []*setting.Setting {
+	return []*setting.Setting{
+		{Env: "ICE_CONFIG", Usage: "JSON file with the ICE servers and options", Value: setting.String(&o.File)},
+		{Env: "ICE_SERVERS", Usage: "comma-separated STUN/TURN URLs replacing the server list; none for no servers", Value: setting.List(&o.Servers)},
+		{Env: "TURN_USERNAME", Usage: "username for the turn: URLs in ICE_SERVERS", Value: setting.String(&o.TURNUsername)},
+		{Env: "TURN_CREDENTIAL", Usage: "credential for the turn: URLs in ICE_SERVERS", Value: setting.Secret(&o.TURNCredential)},
+		{Env: "ICE_TRANSPORT_POLICY", Usage: "all, or relay for TURN only (default all)", Value: setting.String(&o.TransportPolicy)},
+		{Env: "ICE_UDP_PORT_RANGE", Usage: "local ports for ICE candidates, MIN-MAX", Value: setting.PortRange(&o.UDPPortMin, &o.UDPPortMax)},
+		{Env: "NAT_1TO1_IPS", Usage: "comma-separated public IPs advertised as host candidates", Value: setting.List(&o.NAT1To1IPs)},
+		{Env: "ICE_INTERFACES", Usage: "comma-separated interfaces to gather candidates on", Value: setting.List(&o.Interfaces)},
+		{Env: "ICE_EXCLUDE_INTERFACES", Usage: "comma-separated interfaces to skip", Value: setting.List(&o.ExcludeInterfaces)},
+		{Env: "ICE_INCLUDE_LOOPBACK", Usage: "gather loopback candidates, for sessions on one host", Value: setting.Bool(&o.IncludeLoopback)},
+	}
+}
+
+// Config builds the configuration from defaults, the file and then the
+// other options.
+func (o Options) Config() (Config, error) {
+	cfg := Default()
+	if o.File != "" {
+		b, err := os.ReadFile(o.File)
+		if err != nil {
+			return cfg, fmt.Errorf("read %s: %w", o.File, err)
+		}
+		// Start from an empty server list so the file fully controls it.
+		cfg.ICEServers = nil
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return cfg, fmt.Errorf("parse %s: %w", o.File, err)
+		}
+	}
+	if o.Servers != nil {
+		cfg.ICEServers = nil
+		if len(o.Servers) != 1 || strings.ToLower(o.Servers[0]) != "none" {
+			var stun, turn []string
+			for _, u := range o.Servers {
+				if isTURN(u) {
+					turn = append(turn, u)
+				} else {
+					stun = append(stun, u)
+				}
+			}
+			if len(stun) > 0 {
+				cfg.ICEServers = append(cfg.ICEServers, ICEServer{URLs: stun})
+			}
+			if len(turn) > 0 {
+				cfg.ICEServers = append(cfg.ICEServers, ICEServer{URLs: turn, Username: o.TURNUsername, Credential: o.TURNCredential})
+			}
+		}
+	}
+	if o.TransportPolicy != "" {
+		cfg.ICETransportPolicy = strings.ToLower(o.TransportPolicy)
+	}
+	if o.UDPPortMax != 0 {
+		cfg.UDPPortMin, cfg.UDPPortMax = o.UDPPortMin, o.UDPPortMax
+	}
+	if o.NAT1To1IPs != nil {
+		cfg.NAT1To1IPs = o.NAT1To1IPs
+	}
+	if o.Interfaces != nil {
+		cfg.Interfaces = o.Interfaces
+	}
+	if o.ExcludeInterfaces != nil {
+		cfg.ExcludeInterfaces = o.ExcludeInterfaces
+	}
+	if o.IncludeLoopback {
+		cfg.IncludeLoopback = true
+	}
+	return cfg, cfg.Validate()
+}
+
+// Load reads the Options from the environment alone and builds the
+// configuration.
+func Load() (Config, error) {
+	var o Options
+	if err := setting.FromEnv(o.Settings()...); err != nil {
+		return Default(), err
+	}
+	return o.Config()
+}
+
+// Validate reports configuration errors that would otherwise surface as
+// confusing ICE failures.
+func (c Config) Validate() error {
+	// relay without a static turn: server is allowed: the server's embedded
+	// relay hands out TURN credentials per session.
+	switch c.ICETransportPolicy {
+	case "", "all", "relay":
+	default:
+		return fmt.Errorf("iceTransportPolicy %q: want all or relay", c.ICETransportPolicy)
+	}
+	for _, s := range c.ICEServers {
+		if len(s.URLs) == 0 {
+			return fmt.Errorf("ice server without urls")
+		}
+		for _, u := range s.URLs {
+			if isTURN(u) && (s.Username == "" || s.Credential == "") {
+				return fmt.Errorf("ice server %s: TURN requires username and credential", u)
+			}
+		}
+	}
+	if (c.UDPPortMin == 0) != (c.UDPPortMax == 0) || c.UDPPortMin > c.UDPPortMax {
+		return fmt.Errorf("invalid UDP port range %d-%d", c.UDPPortMin, c.UDPPortMax)
+	}
+	for _, ip := range c.NAT1To1IPs {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("nat1to1IPs: invalid IP %q", ip)
+		}
+	}
+	return nil
+}
+
+// WebRTC converts the configuration to a pion PeerConnection configuration.
+func (c Config) WebRTC() webrtc.Configuration {
+	var servers []webrtc.ICEServer
+	for _, s := range c.ICEServers {
+		servers = append(servers, webrtc.ICEServer{
+			URLs:           s.URLs,
+			Username:       s.Username,
+			Credential:     s.Credential,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		})
+	}
+	policy := webrtc.ICETransportPolicyAll
+	if c.ICETransportPolicy == "relay" {
+		policy = webrtc.ICETransportPolicyRelay
+	}
+	return webrtc.Configuration{ICEServers: servers, ICETransportPolicy: policy, Certificates: c.Certificates}
+}
+
+// SettingEngine returns a pion SettingEngine with the port range, NAT 1:1,
+// interface filters and logger applied.
+func (c Config) SettingEngine() (webrtc.SettingEngine, error) {
+	var se webrtc.SettingEngine
+	if c.LoggerFactory != nil {
+		se.LoggerFactory = c.LoggerFactory
+	}
+	if c.UDPPortMin != 0 {
+		if err := se.SetEphemeralUDPPortRange(c.UDPPortMin, c.UDPPortMax); err != nil {
+			return se, fmt.Errorf("udp port range: %w", err)
+		}
+	}
+	if len(c.NAT1To1IPs) > 0 {
+		se.SetNAT1To1IPs(c.NAT1To1IPs, webrtc.ICECandidateTypeHost)
+	}
+	if c.IncludeLoopback {
+		se.SetIncludeLoopbackCandidate(true)
+	}
+	if len(c.Interfaces) > 0 || len(c.ExcludeInterfaces) > 0 {
+		allow, deny := toSet(c.Interfaces), toSet(c.ExcludeInterfaces)
+		se.SetInterfaceFilter(func(name string) bool {
+			if deny[name] {
+				return false
+			}
+			return len(allow) == 0 || allow[name]
+		})
+	}
+	return se, nil
+}
+
+// NewPeerConnection creates a PeerConnection using both the ICE servers and
+// the SettingEngine options. extra servers (e.g. minted TURN credentials)
+// are appended to the configured ones.
+func (c Config) NewPeerConnection(extra ...ICEServer) (*webrtc.PeerConnection, error) {
+	se, err := c.SettingEngine()
+	if err != nil {
+		return nil, err
+	}
+	c.ICEServers = append(append([]ICEServer(nil), c.ICEServers...), extra...)
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(se))
+	return api.NewPeerConnection(c.WebRTC())
+}
+
+// Browser returns the part of the configuration that the page needs.
+func (c Config) Browser() BrowserConfig {
+	policy := c.ICETransportPolicy
+	if policy == "" {
+		policy = "all"
+	}
+	servers := c.ICEServers
+	if servers == nil {
+		servers = []ICEServer{}
+	}
+	return BrowserConfig{ICEServers: servers, ICETransportPolicy: policy}
+}
+
+func isTURN(u string) bool {
+	u = strings.ToLower(u)
+	return strings.HasPrefix(u, "turn:") || strings.HasPrefix(u, "turns:")
+}
+
+func toSet(list []string) map[string]bool {
+	m := make(map[string]bool, len(list))
+	for _, v := range list {
+		m[v] = true
+	}
+	return m
+}
@@ -0,0 +1,72 @@
+package signaling
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Manual copy/paste signaling: the page shows its offer as base64 of the
+// SessionDescription JSON and expects the answer in the same form.
+
+// ReadPasted reads a pasted offer or answer from r. Input ends at an empty
+// line (once some text was read), a line containing only END, or EOF.
+// Whitespace is ignored so pastes wrapped by a terminal still decode. Raw
+// JSON is accepted as-is.
+func ReadPasted(r io.Reader) ([]byte, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	var sb strings.Builder
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "END" || (line == "" && sb.Len() > 0) {
+			break
+		}
+		sb.WriteString(line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read pasted text: %w", err)
+	}
+	text := strings.Join(strings.Fields(sb.String()), "")
+	if text == "" {
+		return nil, fmt.Errorf("nothing pasted")
+	}
+	if strings.HasPrefix(text, "{") {
+		return []byte(sb.String()), nil
+	}
+	b, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("decode pasted base64: %w", err)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		return nil, fmt.Errorf("pasted text is not a session description")
+	}
+	return b, nil
+}
+
+// EncodePasted returns the copy/paste form of a JSON session description.
+func EncodePasted(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// QR renders text as a QR code PNG of the given pixel size. Session
+// descriptions with many candidates may exceed QR capacity, in which case an
+// error is returned and the text must be copied instead.
+func QR(text string, size int) ([]byte, error) {
+	return qrcode.Encode(text, qrcode.Low, size)
+}
+
+// TerminalQR renders text as a QR code drawn with block characters for a
+// console.
+func TerminalQR(text string) (string, error) {
+	q, err := qrcode.New(text, qrcode.Low)
+	if err != nil {
+		return "", err
+	}
+	return q.ToSmallString(false), nil
+}
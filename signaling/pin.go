@@ -0,0 +1,192 @@
+package signaling
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"weblinuxgui/config/setting"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// PinConfig lists the DTLS certificate fingerprints of enrolled peers.
+type PinConfig struct {
+	// Fingerprints are "sha-256 AB:CD:..." (the algorithm may be omitted).
+	Fingerprints []string
+	// File holds more fingerprints, one per line; # starts a comment.
+	File string
+	// Enroll trusts the first answer's fingerprint while none is enrolled
+	// and appends it to File.
+	Enroll bool
+}
+
+// Enabled reports whether a fingerprint or a fingerprint file is set.
+func (c PinConfig) Enabled() bool { return len(c.Fingerprints) > 0 || c.File != "" }
+
+// Check reports settings that do not go together.
+func (c PinConfig) Check() error {
+	if c.Enroll && c.File == "" {
+		return fmt.Errorf("PEER_ENROLL needs PEER_FINGERPRINT_FILE to record the fingerprint")
+	}
+	return nil
+}
+
+// Settings lists the pinning settings, bound to c.
+func (c *PinConfig) Settings() []*setting.Setting {
+	return []*setting.Setting{
+		{Env: "PEER_FINGERPRINT", Usage: "comma-separated enrolled peer fingerprints (sha-256 AB:CD:...)", Value: setting.List(&c.Fingerprints)},
+		{Env: "PEER_FINGERPRINT_FILE", Usage: "file with one enrolled peer fingerprint per line", Value: setting.String(&c.File)},
+		{Env: "PEER_ENROLL", Usage: "trust the first peer's fingerprint and record it in PEER_FINGERPRINT_FILE", Value: setting.Bool(&c.Enroll)},
+	}
+}
+
+// PinsFromEnv reads the pinning configuration from the environment alone.
+// It returns ok=false when no fingerprint or file is configured.
+func PinsFromEnv() (cfg PinConfig, ok bool, err error) {
+	if err = setting.FromEnv(cfg.Settings()...); err != nil {
+		return cfg, cfg.Enabled(), err
+	}
+	return cfg, cfg.Enabled(), cfg.Check()
+}
+
+// Pins checks ANSWERs against the enrolled fingerprints, so a host that
+// answers in place of the peer cannot impersonate it.
+type Pins struct {
+	file   string
+	enroll bool
+
+	mu  sync.Mutex
+	set map[string]bool
+}
+
+// NewPins loads cfg. A missing File is fine when enrolling.
+func NewPins(cfg PinConfig) (*Pins, error) {
+	p := &Pins{file: cfg.File, enroll: cfg.Enroll, set: map[string]bool{}}
+	for _, fp := range cfg.Fingerprints {
+		n, err := normalizeFingerprint(fp)
+		if err != nil {
+			return nil, err
+		}
+		p.set[n] = true
+	}
+	if cfg.File == "" {
+		return p, nil
+	}
+	f, err := os.Open(cfg.File)
+	if os.IsNotExist(err) && cfg.Enroll {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open fingerprint file: %w", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		n, err := normalizeFingerprint(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.File, err)
+		}
+		p.set[n] = true
+	}
+	return p, sc.Err()
+}
+
+// Len returns the number of enrolled fingerprints.
+func (p *Pins) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.set)
+}
+
+// Check verifies an ANSWER (the JSON SessionDescription): every
+// fingerprint in its SDP must be enrolled.
+func (p *Pins) Check(answerJSON []byte) error {
+	var sd webrtc.SessionDescription
+	if err := json.Unmarshal(answerJSON, &sd); err != nil {
+		return fmt.Errorf("unmarshal answer: %w", err)
+	}
+	fps := Fingerprints(sd.SDP)
+	if len(fps) == 0 {
+		return fmt.Errorf("answer has no DTLS fingerprint")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.set) == 0 && p.enroll {
+		return p.enrollLocked(fps)
+	}
+	for _, fp := range fps {
+		if !p.set[fp] {
+			return fmt.Errorf("peer fingerprint %s is not enrolled", fp)
+		}
+	}
+	return nil
+}
+
+func (p *Pins) enrollLocked(fps []string) error {
+	f, err := os.OpenFile(p.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("enroll: %w", err)
+	}
+	defer f.Close()
+	for _, fp := range fps {
+		if p.set[fp] {
+			continue
+		}
+		if _, err := fmt.Fprintln(f, fp); err != nil {
+			return fmt.Errorf("enroll: %w", err)
+		}
+		p.set[fp] = true
+		slog.Info("enrolled peer fingerprint", "fingerprint", fp)
+	}
+	return nil
+}
+
+var fingerprintLine = regexp.MustCompile(`(?m)^a=fingerprint:(\S+) (\S+)\s*$`)
+
+// Fingerprints returns the normalized DTLS fingerprints of an SDP, from
+// both the session and the media sections.
+func Fingerprints(sdp string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, m := range fingerprintLine.FindAllStringSubmatch(sdp, -1) {
+		fp := strings.ToLower(m[1]) + " " + strings.ToUpper(m[2])
+		if !seen[fp] {
+			seen[fp] = true
+			out = append(out, fp)
+		}
+	}
+	return out
+}
+
+var fingerprintValue = regexp.MustCompile(`^[0-9A-F]{2}(:[0-9A-F]{2})+$`)
+
+// normalizeFingerprint accepts "sha-256 ab:cd:..." or a bare SHA-256 value,
+// with or without colons.
+func normalizeFingerprint(s string) (string, error) {
+	algo, value, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		algo, value = "sha-256", algo
+	}
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if !strings.Contains(value, ":") && len(value)%2 == 0 {
+		parts := make([]string, 0, len(value)/2)
+		for i := 0; i < len(value); i += 2 {
+			parts = append(parts, value[i:i+2])
+		}
+		value = strings.Join(parts, ":")
+	}
+	if !fingerprintValue.MatchString(value) {
+		return "", fmt.Errorf("invalid fingerprint %q", s)
+	}
+	return strings.ToLower(algo) + " " + value, nil
+}
@@ -0,0 +1,129 @@
+package signaling_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+
+	"weblinuxgui/signaling"
+)
+
+const (
+	fpA = "AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99"
+	fpB = "01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF"
+)
+
+// answer returns the JSON of an answer whose SDP carries fingerprints.
+func answer(t *testing.T, fingerprints ...string) []byte {
+	t.Helper()
+	sdp := "v=0\r\no=- 1 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"
+	for _, fp := range fingerprints {
+		sdp += "a=fingerprint:" + fp + "\r\n"
+	}
+	b, err := json.Marshal(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFingerprints(t *testing.T) {
+	sdp := "v=0\r\na=fingerprint:SHA-256 " + strings.ToLower(fpA) + "\r\nm=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\na=fingerprint:sha-256 " + fpA + "\r\na=fingerprint:sha-256 " + fpB + "\r\n"
+	want := []string{"sha-256 " + fpA, "sha-256 " + fpB}
+	if got := signaling.Fingerprints(sdp); !reflect.DeepEqual(got, want) {
+		t.Errorf("Fingerprints = %q, want %q", got, want)
+	}
+	if got := signaling.Fingerprints("v=0\r\n"); len(got) != 0 {
+		t.Errorf("Fingerprints without any = %q", got)
+	}
+}
+
+func TestPinsCheck(t *testing.T) {
+	// Lower case and a bare value without colons are accepted too
+	p, err := signaling.NewPins(signaling.PinConfig{Fingerprints: []string{"sha-256 " + strings.ToLower(fpA), strings.ReplaceAll(fpB, ":", "")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", p.Len())
+	}
+	if err := p.Check(answer(t, "sha-256 "+fpA)); err != nil {
+		t.Errorf("enrolled fingerprint: %v", err)
+	}
+	if err := p.Check(answer(t, "sha-256 "+fpA, "sha-256 "+fpB)); err != nil {
+		t.Errorf("two enrolled fingerprints: %v", err)
+	}
+	other := "sha-256 " + strings.Repeat("12:", 31) + "12"
+	if err := p.Check(answer(t, "sha-256 "+fpA, other)); err == nil {
+		t.Error("answer with an unknown fingerprint accepted")
+	}
+	if err := p.Check(answer(t)); err == nil {
+		t.Error("answer without a fingerprint accepted")
+	}
+	if _, err := signaling.NewPins(signaling.PinConfig{Fingerprints: []string{"sha-256 xyz"}}); err == nil {
+		t.Error("invalid fingerprint accepted")
+	}
+}
+
+func TestPinsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "peers.txt")
+	if err := os.WriteFile(file, []byte("# office PC\nsha-256 "+fpA+"  # old\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := signaling.NewPins(signaling.PinConfig{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Check(answer(t, "sha-256 "+fpA)); err != nil {
+		t.Errorf("fingerprint from the file: %v", err)
+	}
+	if _, err := signaling.NewPins(signaling.PinConfig{File: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("missing file accepted without enrollment")
+	}
+}
+
+func TestPinsEnroll(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "peers.txt")
+	p, err := signaling.NewPins(signaling.PinConfig{File: file, Enroll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Check(answer(t, "sha-256 "+fpA)); err != nil {
+		t.Fatalf("first answer: %v", err)
+	}
+	// Only the first peer is trusted
+	if err := p.Check(answer(t, "sha-256 "+fpB)); err == nil {
+		t.Error("second fingerprint enrolled too")
+	}
+	reloaded, err := signaling.NewPins(signaling.PinConfig{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Check(answer(t, "sha-256 "+fpA)); err != nil {
+		t.Errorf("enrolled fingerprint not recorded: %v", err)
+	}
+}
+
+func TestPinsFromEnv(t *testing.T) {
+	t.Setenv("PEER_FINGERPRINT", "")
+	t.Setenv("PEER_FINGERPRINT_FILE", "")
+	t.Setenv("PEER_ENROLL", "")
+	if _, ok, err := signaling.PinsFromEnv(); ok || err != nil {
+		t.Fatalf("unset: ok=%v err=%v", ok, err)
+	}
+	t.Setenv("PEER_ENROLL", "1")
+	if _, _, err := signaling.PinsFromEnv(); err == nil {
+		t.Error("PEER_ENROLL without PEER_FINGERPRINT_FILE accepted")
+	}
+	t.Setenv("PEER_ENROLL", "")
+	t.Setenv("PEER_FINGERPRINT", " sha-256 "+fpA+", ,"+fpB)
+	cfg, ok, err := signaling.PinsFromEnv()
+	if !ok || err != nil || len(cfg.Fingerprints) != 2 {
+		t.Errorf("PEER_FINGERPRINT: %+v ok=%v err=%v", cfg, ok, err)
+	}
+}
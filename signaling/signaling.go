@@ -0,0 +1,72 @@
+package signaling
+
+// Package signaling defines the messages the HTTP server and the Windows peer
+// exchange over the UDP signaling leg.
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"weblinuxgui/rtcconfig"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Offer is the payload of an OFFER message. Besides the browser's SDP it may
+// carry extra ICE servers (e.g. short-lived TURN credentials) for the peer.
+// Session identifies the browser session; an offer for the session the peer
+// is already serving is an ICE restart. Requester is filled in by the server
+// for the host's approval prompt. Code and DeviceToken are the browser's
+// pairing credentials, relayed as given.
+type Offer struct {
+	SDP         webrtc.SessionDescription `json:"sdp"`
+	ICEServers  []rtcconfig.ICEServer     `json:"iceServers,omitempty"`
+	Session     string                    `json:"session,omitempty"`
+	Requester   *Requester                `json:"requester,omitempty"`
+	Code        string                    `json:"code,omitempty"`
+	DeviceToken string                    `json:"deviceToken,omitempty"`
+}
+
+// Answer is the payload of an ANSWER message: the peer's SessionDescription
+// ({"type","sdp"} like a bare one) and, after pairing with a code, the
+// device token the browser keeps for later sessions. The server adds
+// ICEServers (the browser's TURN credentials) before relaying it.
+type Answer struct {
+	webrtc.SessionDescription
+	DeviceToken string                `json:"deviceToken,omitempty"`
+	ICEServers  []rtcconfig.ICEServer `json:"iceServers,omitempty"`
+}
+
+// Requester is who sent an offer, as seen by the server.
+type Requester struct {
+	Addr      string `json:"addr,omitempty"`
+	Name      string `json:"name,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+// DecodeOffer parses an OFFER payload. Older servers sent the bare
+// SessionDescription, which is still accepted.
+func DecodeOffer(b []byte) (Offer, error) {
+	// A bare SessionDescription has a string "sdp" field, the envelope an object
+	var probe struct {
+		SDP json.RawMessage `json:"sdp"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return Offer{}, fmt.Errorf("unmarshal offer: %w", err)
+	}
+	if len(probe.SDP) > 0 && probe.SDP[0] == '"' {
+		var sd webrtc.SessionDescription
+		if err := json.Unmarshal(b, &sd); err != nil || sd.SDP == "" {
+			return Offer{}, fmt.Errorf("offer has no SDP")
+		}
+		return Offer{SDP: sd}, nil
+	}
+	var o Offer
+	if err := json.Unmarshal(b, &o); err != nil {
+		return o, fmt.Errorf("unmarshal offer: %w", err)
+	}
+	if o.SDP.SDP == "" {
+		return o, fmt.Errorf("offer has no SDP")
+	}
+	return o, nil
+}
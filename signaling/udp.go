@@ -0,0 +1,438 @@
+package signaling
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reliable framing for the UDP signaling leg.
+//
+// A message is split into fragments that fit a conservative MTU. Each
+// fragment is a datagram with a one-line text header followed by payload:
+//
+//	RSIG1 <kind> <msgID> <replyTo> <index> <total>\n<payload>
+//
+// The receiver acknowledges every fragment with
+//
+//	RACK1 <msgID> <index>
+//
+// and the sender retransmits unacknowledged fragments until all are acked,
+// the retries run out or SendTimeout has passed. IDs are hex uint64; replyTo
+// is 0 unless the message answers another one.
+//
+// Legacy single-datagram messages ("OFFER:<payload>") are still accepted and
+// answered in kind, so an upgraded peer works with an older server. The
+// reverse does not: an older peer neither acks RSIG1 fragments nor sends
+// replyTo, so an upgraded server needs an upgraded peer.
+
+const (
+	dataMagic = "RSIG1"
+	ackMagic  = "RACK1"
+	// FragmentSize is the maximum payload per datagram. With the header this
+	// stays well below the 1280 byte IPv6 minimum MTU.
+	FragmentSize = 1100
+	// maxFragments bounds reassembly memory per message (~1.1 MB).
+	maxFragments = 1024
+)
+
+var (
+	// ErrTimeout is returned when no matching message arrives in time.
+	ErrTimeout = errors.New("signaling: timeout")
+	// ErrClosed is returned after Close.
+	ErrClosed = errors.New("signaling: connection closed")
+)
+
+// Message is a fully reassembled signaling message.
+type Message struct {
+	Kind    string
+	ID      uint64
+	ReplyTo uint64
+	Payload []byte
+	From    *net.UDPAddr
+}
+
+// Conn adds fragmentation, acks, retries and reassembly on top of a UDP socket.
+type Conn struct {
+	conn *net.UDPConn
+
+	// RetryInterval is the initial retransmit interval (doubling per attempt)
+	// and Retries the number of retransmissions before Send gives up.
+	// SendTimeout bounds the whole Send, retransmissions included.
+	RetryInterval time.Duration
+	Retries       int
+	SendTimeout   time.Duration
+
+	mu      sync.Mutex
+	acks    map[uint64]chan int
+	partial map[partialKey]*partialMsg
+	done    map[partialKey]time.Time
+	waiters []*waiter
+	queue   []Message
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type partialKey struct {
+	from string
+	id   uint64
+}
+
+type partialMsg struct {
+	kind    string
+	replyTo uint64
+	parts   [][]byte
+	got     int
+	started time.Time
+}
+
+type waiter struct {
+	match func(Message) bool
+	ch    chan Message
+}
+
+// NewConn starts reading from c. The Conn owns c from now on.
+func NewConn(c *net.UDPConn) *Conn {
+	sc := &Conn{
+		conn:          c,
+		RetryInterval: 200 * time.Millisecond,
+		Retries:       8,
+		SendTimeout:   10 * time.Second,
+		acks:          make(map[uint64]chan int),
+		partial:       make(map[partialKey]*partialMsg),
+		done:          make(map[partialKey]time.Time),
+		closed:        make(chan struct{}),
+	}
+	go sc.readLoop()
+	return sc
+}
+
+// LocalAddr returns the local UDP address.
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// Close stops the read loop and closes the socket.
+func (c *Conn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// Send delivers a message and waits until every fragment is acknowledged.
+// It returns the message ID, which the other side uses as replyTo.
+func (c *Conn) Send(to *net.UDPAddr, kind string, payload []byte) (uint64, error) {
+	return c.send(to, kind, 0, payload)
+}
+
+// Respond answers req. Legacy requests (ID 0) get a legacy single
+// datagram reply, since the old sender cannot reassemble fragments.
+func (c *Conn) Respond(req Message, kind string, payload []byte) error {
+	if req.ID == 0 {
+		_, err := c.conn.WriteToUDP(append([]byte(kind+":"), payload...), req.From)
+		return err
+	}
+	_, err := c.send(req.From, kind, req.ID, payload)
+	return err
+}
+
+func (c *Conn) send(to *net.UDPAddr, kind string, replyTo uint64, payload []byte) (uint64, error) {
+	if to == nil {
+		return 0, fmt.Errorf("signaling: no destination")
+	}
+	if kind == "" || strings.ContainsAny(kind, " \n") {
+		return 0, fmt.Errorf("signaling: invalid kind %q", kind)
+	}
+	total := (len(payload) + FragmentSize - 1) / FragmentSize
+	if total == 0 {
+		total = 1
+	}
+	if total > maxFragments {
+		return 0, fmt.Errorf("signaling: message too large (%d bytes)", len(payload))
+	}
+	id := newID()
+	frags := make([][]byte, total)
+	for i := range frags {
+		start := i * FragmentSize
+		end := min(start+FragmentSize, len(payload))
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "%s %s %x %x %d %d\n", dataMagic, kind, id, replyTo, i, total)
+		b.Write(payload[start:end])
+		frags[i] = b.Bytes()
+	}
+
+	ackCh := make(chan int, total)
+	c.mu.Lock()
+	c.acks[id] = ackCh
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.acks, id)
+		c.mu.Unlock()
+	}()
+
+	acked := make([]bool, total)
+	remaining := total
+	interval := c.RetryInterval
+	deadline := time.Now().Add(c.SendTimeout)
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		left := time.Until(deadline)
+		if left <= 0 {
+			break
+		}
+		for i, f := range frags {
+			if !acked[i] {
+				if _, err := c.conn.WriteToUDP(f, to); err != nil {
+					return id, fmt.Errorf("signaling: write: %w", err)
+				}
+			}
+		}
+		timer := time.NewTimer(min(interval, left))
+	wait:
+		for remaining > 0 {
+			select {
+			case i := <-ackCh:
+				if i >= 0 && i < total && !acked[i] {
+					acked[i] = true
+					remaining--
+				}
+			case <-timer.C:
+				break wait
+			case <-c.closed:
+				timer.Stop()
+				return id, ErrClosed
+			}
+		}
+		timer.Stop()
+		if remaining == 0 {
+			return id, nil
+		}
+		interval *= 2
+	}
+	return id, fmt.Errorf("signaling: %s not acknowledged by %s (%d/%d fragments)", kind, to, total-remaining, total)
+}
+
+// Receive waits for the next message whose kind is one of kinds.
+// Messages that arrive before anyone waits for them are queued briefly.
+func (c *Conn) Receive(timeout time.Duration, kinds ...string) (Message, error) {
+	return c.wait(timeout, func(m Message) bool {
+		for _, k := range kinds {
+			if m.Kind == k {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ReceiveReply waits for a message answering the message with the given ID.
+func (c *Conn) ReceiveReply(timeout time.Duration, id uint64) (Message, error) {
+	return c.wait(timeout, func(m Message) bool { return m.ReplyTo == id })
+}
+
+func (c *Conn) wait(timeout time.Duration, match func(Message) bool) (Message, error) {
+	c.mu.Lock()
+	for i, m := range c.queue {
+		if match(m) {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			c.mu.Unlock()
+			return m, nil
+		}
+	}
+	w := &waiter{match: match, ch: make(chan Message, 1)}
+	c.waiters = append(c.waiters, w)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case m := <-w.ch:
+		return m, nil
+	case <-timer.C:
+	case <-c.closed:
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, x := range c.waiters {
+		if x == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			break
+		}
+	}
+	// A message may have been handed over just before we unregistered
+	select {
+	case m := <-w.ch:
+		return m, nil
+	default:
+	}
+	select {
+	case <-c.closed:
+		return Message{}, ErrClosed
+	default:
+		return Message{}, ErrTimeout
+	}
+}
+
+func (c *Conn) deliver(m Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, w := range c.waiters {
+		if w.match(m) {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			w.ch <- m
+			return
+		}
+	}
+	// Keep a short backlog for callers that start waiting a bit late
+	const maxQueue = 32
+	c.queue = append(c.queue, m)
+	if len(c.queue) > maxQueue {
+		c.queue = c.queue[len(c.queue)-maxQueue:]
+	}
+}
+
+func (c *Conn) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-c.closed:
+				return
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			// Transient errors (e.g. ICMP port unreachable on Windows)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		pkt := buf[:n]
+		switch {
+		case bytes.HasPrefix(pkt, []byte(ackMagic+" ")):
+			c.handleAck(pkt)
+		case bytes.HasPrefix(pkt, []byte(dataMagic+" ")):
+			c.handleData(pkt, from)
+		default:
+			c.handleLegacy(pkt, from)
+		}
+	}
+}
+
+func (c *Conn) handleAck(pkt []byte) {
+	f := strings.Fields(string(pkt))
+	if len(f) != 3 {
+		return
+	}
+	id, err1 := strconv.ParseUint(f[1], 16, 64)
+	idx, err2 := strconv.Atoi(f[2])
+	if err1 != nil || err2 != nil {
+		return
+	}
+	c.mu.Lock()
+	ch := c.acks[id]
+	c.mu.Unlock()
+	if ch != nil {
+		select {
+		case ch <- idx:
+		default:
+		}
+	}
+}
+
+func (c *Conn) handleData(pkt []byte, from *net.UDPAddr) {
+	nl := bytes.IndexByte(pkt, '\n')
+	if nl < 0 {
+		return
+	}
+	f := strings.Fields(string(pkt[:nl]))
+	if len(f) != 6 {
+		return
+	}
+	kind := f[1]
+	id, err1 := strconv.ParseUint(f[2], 16, 64)
+	replyTo, err2 := strconv.ParseUint(f[3], 16, 64)
+	idx, err3 := strconv.Atoi(f[4])
+	total, err4 := strconv.Atoi(f[5])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil ||
+		total <= 0 || total > maxFragments || idx < 0 || idx >= total {
+		return
+	}
+	// Always ack, including retransmissions of fragments we already have
+	_, _ = c.conn.WriteToUDP([]byte(fmt.Sprintf("%s %x %d", ackMagic, id, idx)), from)
+
+	key := partialKey{from: from.String(), id: id}
+	now := time.Now()
+	c.mu.Lock()
+	c.pruneLocked(now)
+	if _, dup := c.done[key]; dup {
+		c.mu.Unlock()
+		return
+	}
+	p := c.partial[key]
+	if p == nil {
+		p = &partialMsg{kind: kind, replyTo: replyTo, parts: make([][]byte, total), started: now}
+		c.partial[key] = p
+	}
+	if len(p.parts) != total || p.parts[idx] != nil {
+		c.mu.Unlock()
+		return
+	}
+	p.parts[idx] = append([]byte(nil), pkt[nl+1:]...)
+	p.got++
+	if p.got < total {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.partial, key)
+	c.done[key] = now
+	c.mu.Unlock()
+
+	c.deliver(Message{Kind: p.kind, ID: id, ReplyTo: p.replyTo, Payload: bytes.Join(p.parts, nil), From: from})
+}
+
+// pruneLocked drops stale partial messages and old duplicate-suppression entries.
+func (c *Conn) pruneLocked(now time.Time) {
+	const keep = 2 * time.Minute
+	for k, p := range c.partial {
+		if now.Sub(p.started) > keep {
+			delete(c.partial, k)
+		}
+	}
+	for k, t := range c.done {
+		if now.Sub(t) > keep {
+			delete(c.done, k)
+		}
+	}
+}
+
+func (c *Conn) handleLegacy(pkt []byte, from *net.UDPAddr) {
+	colon := bytes.IndexByte(pkt, ':')
+	if colon <= 0 {
+		return
+	}
+	kind := string(pkt[:colon])
+	if strings.ToUpper(kind) != kind || strings.ContainsAny(kind, " \n") {
+		return
+	}
+	c.deliver(Message{Kind: kind, Payload: append([]byte(nil), pkt[colon+1:]...), From: from})
+}
+
+func newID() uint64 {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+		return id
+	}
+	return 1
+}
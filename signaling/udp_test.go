@@ -0,0 +1,230 @@
+package signaling_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"weblinuxgui/signaling"
+)
+
+func listen(t *testing.T) *net.UDPConn {
+	t.Helper()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newConn(t *testing.T) *signaling.Conn {
+	t.Helper()
+	c := signaling.NewConn(listen(t))
+	c.RetryInterval = 50 * time.Millisecond
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func addr(c interface{ LocalAddr() net.Addr }) *net.UDPAddr {
+	return c.LocalAddr().(*net.UDPAddr)
+}
+
+// proxy forwards datagrams between the first client that writes to it and
+// to, passing each to drop first; dropped datagrams are not forwarded.
+func proxy(t *testing.T, to *net.UDPAddr, drop func(pkt []byte) bool) *net.UDPAddr {
+	t.Helper()
+	c := listen(t)
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		var client *net.UDPAddr
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := c.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			pkt := buf[:n]
+			if drop(pkt) {
+				continue
+			}
+			if from.String() == to.String() {
+				if client != nil {
+					_, _ = c.WriteToUDP(pkt, client)
+				}
+				continue
+			}
+			client = from
+			_, _ = c.WriteToUDP(pkt, to)
+		}
+	}()
+	return addr(c)
+}
+
+func payload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + i%26)
+	}
+	return b
+}
+
+func TestFragmentReassembly(t *testing.T) {
+	a, b := newConn(t), newConn(t)
+	want := payload(5*signaling.FragmentSize + 17)
+	if _, err := a.Send(addr(b), "OFFER", want); err != nil {
+		t.Fatal(err)
+	}
+	m, err := b.Receive(time.Second, "OFFER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.Payload, want) {
+		t.Fatalf("got %d bytes, want %d", len(m.Payload), len(want))
+	}
+	if m.From.String() != addr(a).String() {
+		t.Errorf("From = %v, want %v", m.From, addr(a))
+	}
+}
+
+func TestRetransmitDroppedFragment(t *testing.T) {
+	a, b := newConn(t), newConn(t)
+	var mu sync.Mutex
+	dropped := 0
+	// Lose the first copy of fragment 2
+	via := proxy(t, addr(b), func(pkt []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if dropped == 0 && bytes.HasPrefix(pkt, []byte("RSIG1 ")) && strings.Fields(string(pkt[:bytes.IndexByte(pkt, '\n')]))[4] == "2" {
+			dropped++
+			return true
+		}
+		return false
+	})
+	want := payload(4 * signaling.FragmentSize)
+	if _, err := a.Send(via, "OFFER", want); err != nil {
+		t.Fatal(err)
+	}
+	m, err := b.Receive(time.Second, "OFFER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.Payload, want) {
+		t.Fatal("payload differs after retransmission")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if dropped != 1 {
+		t.Fatalf("dropped %d fragments, want 1", dropped)
+	}
+}
+
+func TestDuplicateSuppression(t *testing.T) {
+	b := newConn(t)
+	raw := listen(t)
+	defer raw.Close()
+	pkt := []byte("RSIG1 OFFER 2a 0 0 1\nhello")
+	for range 2 {
+		if _, err := raw.WriteToUDP(pkt, addr(b)); err != nil {
+			t.Fatal(err)
+		}
+		// Retransmissions are acked again, so a lost ack does not stall the sender
+		buf := make([]byte, 128)
+		_ = raw.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := raw.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != "RACK1 2a 0" {
+			t.Fatalf("ack = %q", got)
+		}
+	}
+	if m, err := b.Receive(time.Second, "OFFER"); err != nil || string(m.Payload) != "hello" {
+		t.Fatalf("first delivery: %q, %v", m.Payload, err)
+	}
+	if m, err := b.Receive(200*time.Millisecond, "OFFER"); !errors.Is(err, signaling.ErrTimeout) {
+		t.Fatalf("duplicate delivered: %q, %v", m.Payload, err)
+	}
+}
+
+func TestReplyMatching(t *testing.T) {
+	a, b := newConn(t), newConn(t)
+	var ids []uint64
+	for i := range 2 {
+		id, err := a.Send(addr(b), "OFFER", fmt.Appendf(nil, "offer %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	var offers []signaling.Message
+	for range 2 {
+		m, err := b.Receive(time.Second, "OFFER")
+		if err != nil {
+			t.Fatal(err)
+		}
+		offers = append(offers, m)
+	}
+	// Answer in reverse order; each reply must still reach its own request
+	for i := len(offers) - 1; i >= 0; i-- {
+		if err := b.Respond(offers[i], "ANSWER", append([]byte("answer to "), offers[i].Payload...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, id := range ids {
+		m, err := a.ReceiveReply(time.Second, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("answer to offer %d", i); string(m.Payload) != want {
+			t.Errorf("reply to %x = %q, want %q", id, m.Payload, want)
+		}
+	}
+}
+
+func TestLegacyReply(t *testing.T) {
+	b := newConn(t)
+	raw := listen(t)
+	defer raw.Close()
+	if _, err := raw.WriteToUDP([]byte("OFFER:abc"), addr(b)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := b.Receive(time.Second, "OFFER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 0 || string(m.Payload) != "abc" {
+		t.Fatalf("legacy offer = %+v", m)
+	}
+	if err := b.Respond(m, "ANSWER", []byte("xyz")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 128)
+	_ = raw.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := raw.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "ANSWER:xyz" {
+		t.Fatalf("legacy answer = %q", got)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	a := newConn(t)
+	a.SendTimeout = 300 * time.Millisecond
+	// A socket that reads nothing never acks
+	silent := listen(t)
+	defer silent.Close()
+	start := time.Now()
+	if _, err := a.Send(addr(silent), "OFFER", []byte("x")); err == nil {
+		t.Fatal("Send succeeded without acks")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Send took %v with a 300ms SendTimeout", d)
+	}
+}
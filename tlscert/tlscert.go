@@ -0,0 +1,309 @@
+package tlscert
+
+// Package tlscert provides the HTTPS setup of the server: a certificate and
+// key from files, or a self-signed certificate that is generated on first
+// start and kept on disk, so a browser exception or an imported certificate
+// stays valid across restarts.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"weblinuxgui/config/setting"
+)
+
+const (
+	selfSignedCert = "self-signed.crt"
+	selfSignedKey  = "self-signed.key"
+	// selfSignedValidity is kept under the 398 days browsers accept.
+	selfSignedValidity = 397 * 24 * time.Hour
+	// renewBefore regenerates a self-signed certificate close to expiry.
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// Config selects the certificate.
+type Config struct {
+	// Enable serves HTTPS; TLSConfig ignores it.
+	Enable bool
+	// CertFile and KeyFile are PEM files. When empty, a self-signed
+	// certificate in Dir is used.
+	CertFile, KeyFile string
+	// Dir keeps the self-signed certificate.
+	Dir string
+	// Hosts are extra DNS names or IPs for the self-signed certificate;
+	// localhost, the hostname and the local interface addresses are
+	// always included.
+	Hosts []string
+	// RedirectAddr, if set, is a plain HTTP listener that redirects to
+	// HTTPS.
+	RedirectAddr string
+}
+
+// Default returns the configuration with the self-signed certificate in
+// <user config dir>/weblinuxgui/tls; HTTPS stays off until Enabled.
+func Default() Config {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		base = "."
+	}
+	return Config{Dir: filepath.Join(base, "weblinuxgui", "tls")}
+}
+
+// Enabled reports whether HTTPS is served: Enable or CertFile is set.
+func (c Config) Enabled() bool { return c.Enable || c.CertFile != "" }
+
+// Check reports settings that do not go together.
+func (c Config) Check() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("TLS_CERT and TLS_KEY must be set together")
+	}
+	return nil
+}
+
+// Settings lists the TLS settings, bound to c.
+func (c *Config) Settings() []*setting.Setting {
+	return []*setting.Setting{
+		{Env: "TLS_ENABLE", Usage: "serve HTTPS (implied by TLS_CERT)", Value: setting.Bool(&c.Enable)},
+		{Env: "TLS_CERT", Usage: "PEM certificate (chain) file", Value: setting.String(&c.CertFile)},
+		{Env: "TLS_KEY", Usage: "PEM private key file", Value: setting.String(&c.KeyFile)},
+		{Env: "TLS_DIR", Usage: "directory of the self-signed certificate", Value: setting.String(&c.Dir)},
+		{Env: "TLS_HOSTS", Usage: "comma-separated extra names and IPs for the self-signed certificate", Value: setting.List(&c.Hosts)},
+		{Env: "HTTP_REDIRECT_ADDR", Usage: "plain HTTP address redirecting to HTTPS, e.g. :80", Value: setting.Addr(&c.RedirectAddr, false)},
+	}
+}
+
+// FromEnv reads the TLS configuration from the environment alone. It
+// returns ok=false when neither TLS_ENABLE nor TLS_CERT is set.
+func FromEnv() (cfg Config, ok bool, err error) {
+	cfg = Default()
+	if err = setting.FromEnv(cfg.Settings()...); err != nil || !cfg.Enabled() {
+		return cfg, cfg.Enabled(), err
+	}
+	return cfg, true, cfg.Check()
+}
+
+// TLSConfig returns a server configuration. Certificate files are reloaded
+// when they change on disk (e.g. after a renewal); the self-signed
+// certificate is loaded from Dir or generated there.
+func (c Config) TLSConfig() (*tls.Config, error) {
+	if c.CertFile != "" {
+		r := &reloader{certFile: c.CertFile, keyFile: c.KeyFile}
+		if _, err := r.load(); err != nil {
+			return nil, err
+		}
+		return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: r.get}, nil
+	}
+	cert, err := SelfSigned(c.Dir, c.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}, nil
+}
+
+// reloader serves a certificate pair and reloads it when either file's
+// modification time changes.
+type reloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (r *reloader) load() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mod, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// Keep serving the old pair while files are being replaced
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	if r.cert != nil && mod.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	r.cert, r.modTime = &cert, mod
+	return r.cert, nil
+}
+
+func (r *reloader) get(*tls.ClientHelloInfo) (*tls.Certificate, error) { return r.load() }
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if st.ModTime().After(latest) {
+			latest = st.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// SelfSigned loads the self-signed certificate from dir, generating a new
+// one when it is missing, expires within 30 days or does not cover hosts.
+func SelfSigned(dir string, hosts []string) (tls.Certificate, error) {
+	certPath, keyPath := filepath.Join(dir, selfSignedCert), filepath.Join(dir, selfSignedKey)
+	names, ips := subjectAltNames(hosts)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	switch {
+	case err == nil && covers(cert.Leaf, names, ips) && time.Until(cert.Leaf.NotAfter) > renewBefore:
+		return cert, nil
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return tls.Certificate{}, fmt.Errorf("load self-signed certificate: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return tls.Certificate{}, fmt.Errorf("tls dir: %w", err)
+	}
+	certPEM, keyPEM, err := generate(names, ips)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generate self-signed certificate: %w", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return tls.Certificate{}, fmt.Errorf("write key: %w", err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return tls.Certificate{}, fmt.Errorf("write certificate: %w", err)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate's leaf in
+// the colon-separated form browsers show.
+func Fingerprint(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(h); i += 2 {
+		parts = append(parts, h[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// subjectAltNames returns localhost, the hostname, the local interface
+// addresses and hosts, split into DNS names and IPs.
+func subjectAltNames(hosts []string) ([]string, []net.IP) {
+	names := []string{"localhost"}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	if h, err := os.Hostname(); err == nil && h != "" {
+		names = append(names, h)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() && !ipn.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipn.IP)
+			}
+		}
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			names = append(names, h)
+		}
+	}
+	return names, ips
+}
+
+// covers reports whether leaf is valid for all names and ips.
+func covers(leaf *x509.Certificate, names []string, ips []net.IP) bool {
+	if leaf == nil {
+		return false
+	}
+	for _, n := range names {
+		if leaf.VerifyHostname(n) != nil {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if leaf.VerifyHostname(ip.String()) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func generate(names []string, ips []net.IP) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"weblinuxgui"}, CommonName: names[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              names,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// RedirectHandler redirects plain HTTP requests to the same host and path
+// on the HTTPS listener at httpsAddr (e.g. ":8443").
+func RedirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			// Bare IPv6 literal
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,124 @@
+package tlscert_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"weblinuxgui/tlscert"
+)
+
+func TestSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	cert, err := tlscert.SelfSigned(dir, []string{"example.test", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{"localhost", "127.0.0.1", "example.test", "192.0.2.1"} {
+		if err := cert.Leaf.VerifyHostname(h); err != nil {
+			t.Errorf("certificate does not cover %s: %v", h, err)
+		}
+	}
+	again, err := tlscert.SelfSigned(dir, []string{"example.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlscert.Fingerprint(again) != tlscert.Fingerprint(cert) {
+		t.Error("certificate regenerated although it covers the hosts")
+	}
+	other, err := tlscert.SelfSigned(dir, []string{"other.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlscert.Fingerprint(other) == tlscert.Fingerprint(cert) {
+		t.Error("certificate kept although it misses a new host")
+	}
+	if err := other.Leaf.VerifyHostname("other.test"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	cert, err := tlscert.SelfSigned(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	fp := tlscert.Fingerprint(cert)
+	if got := strings.ReplaceAll(fp, ":", ""); got != strings.ToUpper(hex.EncodeToString(sum[:])) {
+		t.Errorf("Fingerprint = %s", fp)
+	}
+	if n := strings.Count(fp, ":"); n != 31 {
+		t.Errorf("Fingerprint has %d colons, want 31", n)
+	}
+}
+
+func TestTLSConfigFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	cert, err := tlscert.SelfSigned(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := tlscert.Config{CertFile: filepath.Join(dir, "self-signed.crt"), KeyFile: filepath.Join(dir, "self-signed.key")}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := cfg.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlscert.Fingerprint(*got) != tlscert.Fingerprint(cert) {
+		t.Error("served another certificate than the files hold")
+	}
+	if _, err := (tlscert.Config{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: filepath.Join(dir, "missing.key")}).TLSConfig(); err == nil {
+		t.Error("missing certificate files accepted")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	for _, tc := range []struct {
+		httpsAddr, host, path, want string
+	}{
+		{":8443", "example.test:8080", "/a?b=1", "https://example.test:8443/a?b=1"},
+		{":443", "example.test", "/", "https://example.test/"},
+		{":443", "[::1]:80", "/x", "https://[::1]/x"},
+		{":8443", "[::1]:80", "/x", "https://[::1]:8443/x"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		r.Host = tc.host
+		w := httptest.NewRecorder()
+		tlscert.RedirectHandler(tc.httpsAddr).ServeHTTP(w, r)
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tc.want {
+			t.Errorf("%s via %s: %d %q, want %q", tc.host, tc.httpsAddr, w.Code, w.Header().Get("Location"), tc.want)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	for _, k := range []string{"TLS_ENABLE", "TLS_CERT", "TLS_KEY", "TLS_DIR", "TLS_HOSTS", "HTTP_REDIRECT_ADDR"} {
+		t.Setenv(k, "")
+	}
+	if _, ok, err := tlscert.FromEnv(); ok || err != nil {
+		t.Fatalf("unset: ok=%v err=%v", ok, err)
+	}
+	t.Setenv("TLS_CERT", "server.crt")
+	if _, ok, err := tlscert.FromEnv(); !ok || err == nil {
+		t.Fatalf("TLS_CERT without TLS_KEY: ok=%v err=%v", ok, err)
+	}
+	t.Setenv("TLS_CERT", "")
+	t.Setenv("TLS_ENABLE", "yes")
+	t.Setenv("TLS_DIR", "/tmp/tls")
+	t.Setenv("TLS_HOSTS", " a.test, ,192.0.2.1 ")
+	cfg, ok, err := tlscert.FromEnv()
+	if !ok || err != nil {
+		t.Fatalf("TLS_ENABLE: ok=%v err=%v", ok, err)
+	}
+	if cfg.Dir != "/tmp/tls" || !reflect.DeepEqual(cfg.Hosts, []string{"a.test", "192.0.2.1"}) {
+		t.Errorf("config = %+v", cfg)
+	}
+}
@@ -0,0 +1,173 @@
+package turnserver
+
+// Package turnserver runs an optional embedded TURN relay inside the HTTP
+// server binary and mints short-lived TURN REST style credentials
+// (username "expiry:user", password base64(HMAC-SHA1(secret, username))).
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"weblinuxgui/config/setting"
+	"weblinuxgui/rtcconfig"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v4"
+)
+
+// Config controls the embedded relay.
+type Config struct {
+	// Enable runs the relay; Start ignores it.
+	Enable bool
+	// Listen is the UDP/TCP address the relay listens on (e.g. "0.0.0.0:3478").
+	Listen string
+	// PublicIP is the address advertised to clients and used for relayed candidates.
+	PublicIP net.IP
+	Realm    string
+	// Secret is the shared HMAC secret. A random one is used when empty,
+	// which is fine because only this process mints credentials.
+	Secret string
+	// TTL is how long minted credentials stay valid.
+	TTL time.Duration
+	// RelayPortMin/RelayPortMax restrict relayed allocation ports (0 = any).
+	RelayPortMin, RelayPortMax uint16
+	// LoggerFactory, if set, receives pion's TURN logs.
+	LoggerFactory logging.LoggerFactory
+}
+
+// Default returns the configuration with the default listener, realm and
+// credential lifetime; the relay stays off until Enable is set.
+func Default() Config {
+	return Config{Listen: "0.0.0.0:3478", Realm: "weblinuxgui", TTL: 10 * time.Minute}
+}
+
+// Settings lists the relay settings, bound to c.
+func (c *Config) Settings() []*setting.Setting {
+	return []*setting.Setting{
+		{Env: "TURN_ENABLE", Usage: "run the embedded TURN relay", Value: setting.Bool(&c.Enable)},
+		{Env: "TURN_LISTEN", Usage: "UDP and TCP address of the relay", Value: setting.Addr(&c.Listen, false)},
+		{Env: "TURN_PUBLIC_IP", Usage: "IP advertised for the relay (default the primary outbound IPv4)", Value: setting.NetIP(&c.PublicIP)},
+		{Env: "TURN_REALM", Usage: "TURN realm", Value: setting.String(&c.Realm)},
+		{Env: "TURN_SECRET", Usage: "HMAC secret of the credentials (default random per process)", Value: setting.Secret(&c.Secret)},
+		{Env: "TURN_CRED_TTL", Usage: "lifetime of minted credentials", Value: setting.Duration(&c.TTL, 1)},
+		{Env: "TURN_RELAY_PORT_RANGE", Usage: "ports of relayed allocations, MIN-MAX", Value: setting.PortRange(&c.RelayPortMin, &c.RelayPortMax)},
+	}
+}
+
+// FromEnv reads the relay configuration from the environment alone. It
+// returns ok=false when TURN_ENABLE is not set.
+func FromEnv() (cfg Config, ok bool, err error) {
+	cfg = Default()
+	err = setting.FromEnv(cfg.Settings()...)
+	return cfg, cfg.Enable, err
+}
+
+// Server is a running embedded TURN relay.
+type Server struct {
+	cfg  Config
+	port int
+	srv  *turn.Server
+}
+
+// Start listens on UDP and TCP and starts relaying.
+func Start(cfg Config) (*Server, error) {
+	if cfg.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generate secret: %w", err)
+		}
+		cfg.Secret = hex.EncodeToString(b)
+	}
+	if cfg.PublicIP == nil {
+		ip, err := outboundIP()
+		if err != nil {
+			return nil, fmt.Errorf("detect public IP (set TURN_PUBLIC_IP): %w", err)
+		}
+		cfg.PublicIP = ip
+	}
+	_, portStr, err := net.SplitHostPort(cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("listen address %q: %w", cfg.Listen, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("listen port %q: %w", portStr, err)
+	}
+
+	udpConn, err := net.ListenPacket("udp4", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("listen UDP: %w", err)
+	}
+	tcpLn, err := net.Listen("tcp4", cfg.Listen)
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, fmt.Errorf("listen TCP: %w", err)
+	}
+
+	srv, err := turn.NewServer(turn.ServerConfig{
+		Realm:             cfg.Realm,
+		AuthHandler:       turn.LongTermTURNRESTAuthHandler(cfg.Secret, nil),
+		PacketConnConfigs: []turn.PacketConnConfig{{PacketConn: udpConn, RelayAddressGenerator: relayGenerator(cfg)}},
+		ListenerConfigs:   []turn.ListenerConfig{{Listener: tcpLn, RelayAddressGenerator: relayGenerator(cfg)}},
+		LoggerFactory:     cfg.LoggerFactory,
+	})
+	if err != nil {
+		_ = udpConn.Close()
+		_ = tcpLn.Close()
+		return nil, fmt.Errorf("start TURN: %w", err)
+	}
+	return &Server{cfg: cfg, port: port, srv: srv}, nil
+}
+
+func relayGenerator(cfg Config) turn.RelayAddressGenerator {
+	if cfg.RelayPortMin != 0 {
+		return &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: cfg.PublicIP,
+			Address:      "0.0.0.0",
+			MinPort:      cfg.RelayPortMin,
+			MaxPort:      cfg.RelayPortMax,
+		}
+	}
+	return &turn.RelayAddressGeneratorStatic{RelayAddress: cfg.PublicIP, Address: "0.0.0.0"}
+}
+
+// Credentials mints a fresh ICE server entry valid for the configured TTL.
+// user is an arbitrary label recorded in the username (e.g. "browser").
+func (s *Server) Credentials(user string) (rtcconfig.ICEServer, error) {
+	username, password, err := turn.GenerateLongTermTURNRESTCredentials(s.cfg.Secret, user, s.cfg.TTL)
+	if err != nil {
+		return rtcconfig.ICEServer{}, fmt.Errorf("mint TURN credentials: %w", err)
+	}
+	hostPort := net.JoinHostPort(s.cfg.PublicIP.String(), strconv.Itoa(s.port))
+	return rtcconfig.ICEServer{
+		URLs: []string{
+			"turn:" + hostPort + "?transport=udp",
+			"turn:" + hostPort + "?transport=tcp",
+		},
+		Username:   username,
+		Credential: password,
+	}, nil
+}
+
+// Addr returns the advertised host:port of the relay.
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.cfg.PublicIP.String(), strconv.Itoa(s.port))
+}
+
+// Close stops the relay and its listeners.
+func (s *Server) Close() error { return s.srv.Close() }
+
+// outboundIP returns the local IPv4 used for the default route. No packets
+// are sent; dialing UDP only selects a source address.
+func outboundIP() (net.IP, error) {
+	c, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
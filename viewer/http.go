@@ -0,0 +1,53 @@
+package viewer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"weblinuxgui/rtcconfig"
+	"weblinuxgui/signaling"
+)
+
+// FetchConfig reads the server's /config, i.e. the ICE servers and policy
+// the page would use.
+func FetchConfig(client *http.Client, base string) (rtcconfig.Config, error) {
+	var cfg rtcconfig.Config
+	resp, err := client.Get(strings.TrimRight(base, "/") + "/config")
+	if err != nil {
+		return cfg, fmt.Errorf("config: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return cfg, fmt.Errorf("config failed: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("decode config: %w", err)
+	}
+	return cfg, nil
+}
+
+// HTTPExchange returns an Exchange that POSTs {offer, session, code, token}
+// to the server's /signal endpoint like the page does.
+func HTTPExchange(client *http.Client, base string) Exchange {
+	return func(offer signaling.Offer) (signaling.Answer, error) {
+		var answer signaling.Answer
+		body := mustJSON(map[string]any{"offer": offer.SDP, "session": offer.Session, "code": offer.Code, "token": offer.DeviceToken})
+		resp, err := client.Post(strings.TrimRight(base, "/")+"/signal", "application/json", bytes.NewReader(body))
+		if err != nil {
+			return answer, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			return answer, fmt.Errorf("signaling failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+			return answer, fmt.Errorf("decode answer: %w", err)
+		}
+		return answer, nil
+	}
+}
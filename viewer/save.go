@@ -0,0 +1,58 @@
+package viewer
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"weblinuxgui/peer"
+)
+
+// Save writes the frame to path as PNG or, for .jpg/.jpeg, as JPEG. A frame
+// that already arrived in the requested format is written as received.
+func (f *Frame) Save(path string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		data, err := f.JPEG()
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", path, err)
+		}
+		return os.WriteFile(path, data, 0o644)
+	case ".png":
+		if f.Format == peer.FormatPNG && f.Data != nil {
+			return os.WriteFile(path, f.Data, 0o644)
+		}
+		out, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(out, f.Image); err != nil {
+			out.Close()
+			return fmt.Errorf("encode %s: %w", path, err)
+		}
+		return out.Close()
+	default:
+		return fmt.Errorf("unsupported snapshot format %q (use .png or .jpg)", filepath.Ext(path))
+	}
+}
+
+// JPEG returns the frame as JPEG, re-encoding lossless and banded frames.
+func (f *Frame) JPEG() ([]byte, error) {
+	if f.Format == peer.FormatJPEG && f.Data != nil {
+		return f.Data, nil
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, f.Image, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,137 @@
+package viewer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"weblinuxgui/peer"
+)
+
+// Action is one step of an input script: an input event to send, a pause,
+// or a snapshot of the latest frame.
+type Action struct {
+	Line     int
+	Event    *peer.InputEvent
+	Sleep    time.Duration
+	Snapshot string
+}
+
+// ParseScript reads an input script. One command per line; blank lines and
+// lines starting with # are ignored:
+//
+//	sleep 500ms
+//	move X Y
+//	click X Y [left|right|middle]
+//	rightclick X Y
+//	scroll X Y DELTA
+//	key NAME            (keydown + keyup, names as in KeyboardEvent.key)
+//	keydown NAME
+//	keyup NAME
+//	type TEXT           (sent as a paste)
+//	snapshot FILE       (saves the latest frame, .png or .jpg)
+func ParseScript(r io.Reader) ([]Action, error) {
+	var actions []Action
+	sc := bufio.NewScanner(r)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// The command ends at the first space or tab
+		cmd, rest := line, ""
+		if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+			cmd, rest = line[:i], strings.TrimSpace(line[i:])
+		}
+		cmd = strings.ToLower(cmd)
+		args := strings.Fields(rest)
+		bad := func(format string, a ...any) error {
+			return fmt.Errorf("script line %d: %s", n, fmt.Sprintf(format, a...))
+		}
+		event := func(ev peer.InputEvent) { actions = append(actions, Action{Line: n, Event: &ev}) }
+		point := func() (int, int, error) {
+			if len(args) < 2 {
+				return 0, 0, bad("%s needs X Y", cmd)
+			}
+			x, errX := strconv.Atoi(args[0])
+			y, errY := strconv.Atoi(args[1])
+			if errX != nil || errY != nil {
+				return 0, 0, bad("invalid coordinates %q %q", args[0], args[1])
+			}
+			return x, y, nil
+		}
+		switch cmd {
+		case "sleep", "wait":
+			d, err := time.ParseDuration(rest)
+			if err != nil {
+				return nil, bad("invalid duration %q", rest)
+			}
+			actions = append(actions, Action{Line: n, Sleep: d})
+		case "move":
+			x, y, err := point()
+			if err != nil {
+				return nil, err
+			}
+			event(peer.InputEvent{Type: "mousemove", X: x, Y: y})
+		case "click":
+			x, y, err := point()
+			if err != nil {
+				return nil, err
+			}
+			btn := "left"
+			if len(args) > 2 {
+				btn = strings.ToLower(args[2])
+			}
+			event(peer.InputEvent{Type: "mousedown", X: x, Y: y, Button: btn})
+			event(peer.InputEvent{Type: "mouseup", X: x, Y: y, Button: btn})
+		case "rightclick":
+			x, y, err := point()
+			if err != nil {
+				return nil, err
+			}
+			event(peer.InputEvent{Type: "contextmenu", X: x, Y: y, Button: "right"})
+		case "scroll":
+			x, y, err := point()
+			if err != nil {
+				return nil, err
+			}
+			if len(args) < 3 {
+				return nil, bad("scroll needs X Y DELTA")
+			}
+			d, err := strconv.ParseFloat(args[2], 64)
+			if err != nil {
+				return nil, bad("invalid delta %q", args[2])
+			}
+			event(peer.InputEvent{Type: "wheel", X: x, Y: y, DeltaY: d})
+		case "key", "keydown", "keyup":
+			if rest == "" {
+				return nil, bad("%s needs a key name", cmd)
+			}
+			if cmd != "keyup" {
+				event(peer.InputEvent{Type: "keydown", Key: rest})
+			}
+			if cmd != "keydown" {
+				event(peer.InputEvent{Type: "keyup", Key: rest})
+			}
+		case "type":
+			event(peer.InputEvent{Type: "paste", ClipboardText: rest})
+		case "snapshot":
+			if rest == "" {
+				return nil, bad("snapshot needs a file name")
+			}
+			actions = append(actions, Action{Line: n, Snapshot: rest})
+		default:
+			return nil, bad("unknown command %q", cmd)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
@@ -0,0 +1,81 @@
+package viewer_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"weblinuxgui/viewer"
+)
+
+func TestParseScript(t *testing.T) {
+	actions, err := viewer.ParseScript(strings.NewReader(`
+# comment
+Sleep 250ms
+move 10 20
+CLICK 1 2 Right
+KeyDown a
+KEYUP a
+key Enter
+type hello world
+snapshot out.png
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range actions {
+		switch {
+		case a.Event != nil:
+			got = append(got, a.Event.Type+" "+a.Event.Key+a.Event.Button+a.Event.ClipboardText)
+		case a.Snapshot != "":
+			got = append(got, "snapshot "+a.Snapshot)
+		default:
+			got = append(got, "sleep "+a.Sleep.String())
+		}
+	}
+	want := []string{
+		"sleep " + (250 * time.Millisecond).String(),
+		"mousemove ",
+		"mousedown right",
+		"mouseup right",
+		"keydown a",
+		"keyup a",
+		"keydown Enter",
+		"keyup Enter",
+		"paste hello world",
+		"snapshot out.png",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("actions:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if actions[1].Line != 4 {
+		t.Errorf("move is on line %d, want 4", actions[1].Line)
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	for _, script := range []string{
+		"sleep soon",
+		"move 10",
+		"click x 1",
+		"scroll 1 2",
+		"keydown",
+		"snapshot",
+		"jump 1 2",
+	} {
+		if _, err := viewer.ParseScript(strings.NewReader(script)); err == nil || !strings.Contains(err.Error(), "script line 1") {
+			t.Errorf("%q: err = %v, want a line 1 error", script, err)
+		}
+	}
+}
+
+func TestParseScriptTabs(t *testing.T) {
+	actions, err := viewer.ParseScript(strings.NewReader("move\t10\t20\nkey\tEnter\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 3 || actions[0].Event.Type != "mousemove" || actions[0].Event.X != 10 || actions[0].Event.Y != 20 || actions[1].Event.Key != "Enter" {
+		t.Fatalf("tab separated script parsed as %+v", actions)
+	}
+}
@@ -0,0 +1,442 @@
+package viewer
+
+// Package viewer plays the browser's role in Go: it creates the "input" and
+// "frames" DataChannels, performs the offer/answer exchange and reassembles
+// frameMeta/frameChunk messages into images.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"weblinuxgui/peer"
+	_ "weblinuxgui/qoi"
+	"weblinuxgui/rtcconfig"
+	"weblinuxgui/signaling"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Frame is one reassembled frame.
+type Frame struct {
+	ID int
+	// Data is the encoded frame as received, in Format (peer.FormatJPEG,
+	// FormatPNG or FormatQOI). It is nil for frames sent in bands.
+	Data           []byte
+	Format         string
+	Image          image.Image
+	MouseX, MouseY int
+	// View is the display region the frame shows, nil for the whole
+	// display at full size.
+	View     *peer.ViewRect
+	Received time.Time
+	// Captured is when the peer captured the frame, by the peer's clock;
+	// zero from older peers.
+	Captured time.Time
+}
+
+// Reassembler turns frames DataChannel messages into frames, mirroring the
+// page's logic: a frameMeta starts a new frame and chunks of other frames
+// are dropped.
+type Reassembler struct {
+	meta  *peer.FrameMeta
+	parts []string
+	got   int
+
+	banded   *image.RGBA // frame being assembled from bands
+	bandID   int
+	bandNext int
+}
+
+// Push handles one message and returns a frame when it is complete.
+func (r *Reassembler) Push(msg []byte) (*Frame, error) {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(msg, &head); err != nil {
+		return nil, fmt.Errorf("frames message: %w", err)
+	}
+	switch head.Type {
+	case "frameMeta":
+		var m peer.FrameMeta
+		if err := json.Unmarshal(msg, &m); err != nil {
+			return nil, fmt.Errorf("frameMeta: %w", err)
+		}
+		r.meta, r.parts, r.got = &m, make([]string, m.Chunks), 0
+	case "frameChunk":
+		var c peer.FrameChunk
+		if err := json.Unmarshal(msg, &c); err != nil {
+			return nil, fmt.Errorf("frameChunk: %w", err)
+		}
+		if r.meta == nil || c.ID != r.meta.ID || c.Index < 0 || c.Index >= len(r.parts) || r.parts[c.Index] != "" {
+			return nil, nil
+		}
+		r.parts[c.Index] = c.Data
+		r.got++
+		if r.got < len(r.parts) {
+			return nil, nil
+		}
+		meta, b64 := r.meta, strings.Join(r.parts, "")
+		r.meta, r.parts = nil, nil
+		f, err := decodeFrame(meta, b64)
+		if err != nil || meta.Band == nil {
+			return f, err
+		}
+		return r.addBand(meta.Band, f), nil
+	}
+	return nil, nil
+}
+
+// addBand draws a band into the frame being assembled and returns the
+// frame once its last band is in. Bands arrive in order; a missing band
+// drops the frame.
+func (r *Reassembler) addBand(b *peer.Band, f *Frame) *Frame {
+	if b.Index == 0 {
+		r.banded = image.NewRGBA(image.Rect(0, 0, b.Width, b.Height))
+		r.bandID, r.bandNext = f.ID, 0
+	}
+	if r.banded == nil || f.ID != r.bandID || b.Index != r.bandNext {
+		r.banded = nil
+		return nil
+	}
+	draw.Draw(r.banded, image.Rect(0, b.Y, b.Width, b.Height), f.Image, image.Point{}, draw.Src)
+	if r.bandNext++; r.bandNext < b.Count {
+		return nil
+	}
+	f.Image, f.Data = r.banded, nil
+	r.banded = nil
+	return f
+}
+
+func decodeFrame(meta *peer.FrameMeta, b64 string) (*Frame, error) {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("frame %d base64: %w", meta.ID, err)
+	}
+	format := meta.Format
+	if format == "" {
+		format = peer.FormatJPEG
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("frame %d %s: %w", meta.ID, format, err)
+	}
+	f := &Frame{ID: meta.ID, Data: raw, Format: format, Image: img, MouseX: meta.MouseX, MouseY: meta.MouseY, View: meta.View, Received: time.Now()}
+	if meta.Captured != 0 {
+		f.Captured = time.UnixMilli(meta.Captured)
+	}
+	return f, nil
+}
+
+// CursorShape is a decoded cursor message.
+type CursorShape struct {
+	ID         uint64
+	HotX, HotY int
+	// Image is nil when the cursor is hidden.
+	Image image.Image
+}
+
+func decodeCursor(msg []byte) (*CursorShape, error) {
+	var m peer.CursorMsg
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil, fmt.Errorf("cursor: %w", err)
+	}
+	cs := &CursorShape{ID: m.ID, HotX: m.HotX, HotY: m.HotY}
+	if m.Hidden || m.PNG == "" {
+		return cs, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(m.PNG)
+	if err != nil {
+		return nil, fmt.Errorf("cursor %d base64: %w", m.ID, err)
+	}
+	if cs.Image, err = png.Decode(bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("cursor %d png: %w", m.ID, err)
+	}
+	return cs, nil
+}
+
+// Exchange delivers an offer to the peer and returns its answer, e.g. over
+// UDP signaling or the server's /signal endpoint.
+type Exchange func(offer signaling.Offer) (signaling.Answer, error)
+
+// Client is a connected browser-role session.
+type Client struct {
+	pc       *webrtc.PeerConnection
+	input    *webrtc.DataChannel
+	frames   *webrtc.DataChannel
+	session  string
+	exchange Exchange
+
+	// Frames delivers reassembled frames. Frames are dropped when the
+	// receiver falls behind, like the page which only draws the latest.
+	Frames chan *Frame
+	// Cursors delivers cursor shape changes (non-blocking).
+	Cursors chan *CursorShape
+	// Keepalives delivers the peer's keepalives, sent instead of frames
+	// while the screen is unchanged (non-blocking).
+	Keepalives chan *peer.Keepalive
+	// Stats delivers the peer's periodic stream statistics (non-blocking).
+	Stats chan *peer.Stats
+	// Errors receives reassembly/decode errors (non-blocking).
+	Errors chan error
+	// DeviceToken is set when the peer paired this client with a code;
+	// pass it as Offer.DeviceToken next time instead of a code.
+	DeviceToken string
+
+	posMu            sync.Mutex
+	cursorX, cursorY int
+
+	open      chan struct{}
+	done      chan struct{}
+	doneOnce  sync.Once
+	closeOnce sync.Once
+}
+
+// Dial creates the DataChannels, exchanges offer/answer and waits until both
+// channels are open or timeout elapses.
+func Dial(cfg rtcconfig.Config, session string, exchange Exchange, timeout time.Duration) (*Client, error) {
+	pc, err := cfg.NewPeerConnection()
+	if err != nil {
+		return nil, fmt.Errorf("new pc: %w", err)
+	}
+	c := &Client{pc: pc, session: session, exchange: exchange, Frames: make(chan *Frame, 8), Cursors: make(chan *CursorShape, 8), Keepalives: make(chan *peer.Keepalive, 8), Stats: make(chan *peer.Stats, 8), Errors: make(chan error, 8), open: make(chan struct{}), done: make(chan struct{})}
+	if c.input, err = pc.CreateDataChannel("input", nil); err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("input channel: %w", err)
+	}
+	if c.frames, err = pc.CreateDataChannel("frames", nil); err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("frames channel: %w", err)
+	}
+
+	var opened sync.WaitGroup
+	opened.Add(2)
+	c.input.OnOpen(opened.Done)
+	c.frames.OnOpen(opened.Done)
+	go func() { opened.Wait(); close(c.open) }()
+	c.frames.OnClose(c.finish)
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			c.finish()
+		}
+	})
+
+	var r Reassembler
+	c.frames.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if c.handleControl(msg.Data) {
+			return
+		}
+		f, err := r.Push(msg.Data)
+		switch {
+		case err != nil:
+			select {
+			case c.Errors <- err:
+			default:
+			}
+		case f != nil:
+			select {
+			case c.Frames <- f:
+			default:
+			}
+		}
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("create offer: %w", err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("set local: %w", err)
+	}
+	<-gatherComplete
+	answer, err := exchange(signaling.Offer{SDP: *pc.LocalDescription(), Session: session})
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("signaling: %w", err)
+	}
+	if err := pc.SetRemoteDescription(answer.SessionDescription); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("set remote: %w", err)
+	}
+	c.DeviceToken = answer.DeviceToken
+	select {
+	case <-c.open:
+		return c, nil
+	case <-time.After(timeout):
+		c.Close()
+		return nil, fmt.Errorf("data channels not open after %s", timeout)
+	}
+}
+
+// Restart renegotiates ICE for the same session, like the page does when
+// the connection drops. The peer keeps the session's state: the
+// DataChannels, the viewport and the held keys.
+func (c *Client) Restart() error {
+	offer, err := c.pc.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return fmt.Errorf("create offer: %w", err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(c.pc)
+	if err := c.pc.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("set local: %w", err)
+	}
+	<-gatherComplete
+	answer, err := c.exchange(signaling.Offer{SDP: *c.pc.LocalDescription(), Session: c.session})
+	if err != nil {
+		_ = c.pc.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback})
+		return fmt.Errorf("signaling: %w", err)
+	}
+	if err := c.pc.SetRemoteDescription(answer.SessionDescription); err != nil {
+		return fmt.Errorf("set remote: %w", err)
+	}
+	return nil
+}
+
+// handleControl consumes the control messages on the frames channel
+// (cursor, cursorPos, keepalive, stats and pong) and reports whether msg was
+// one; anything else is frame data.
+func (c *Client) handleControl(msg []byte) bool {
+	var head struct {
+		Type string `json:"type"`
+		X    int    `json:"x"`
+		Y    int    `json:"y"`
+	}
+	if json.Unmarshal(msg, &head) != nil {
+		return false
+	}
+	switch head.Type {
+	case "keepalive":
+		var k peer.Keepalive
+		if json.Unmarshal(msg, &k) == nil {
+			select {
+			case c.Keepalives <- &k:
+			default:
+			}
+		}
+	case "stats":
+		var st peer.Stats
+		if json.Unmarshal(msg, &st) == nil {
+			select {
+			case c.Stats <- &st:
+			default:
+			}
+		}
+	case "pong":
+		// Only the page pings
+	case "cursorPos":
+		c.posMu.Lock()
+		c.cursorX, c.cursorY = head.X, head.Y
+		c.posMu.Unlock()
+	case "cursor":
+		cs, err := decodeCursor(msg)
+		if err != nil {
+			select {
+			case c.Errors <- err:
+			default:
+			}
+			return true
+		}
+		select {
+		case c.Cursors <- cs:
+		default:
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// CursorPos returns the last cursor position sent between frames.
+func (c *Client) CursorPos() (x, y int) {
+	c.posMu.Lock()
+	defer c.posMu.Unlock()
+	return c.cursorX, c.cursorY
+}
+
+// SendInput sends an input event as the page does.
+func (c *Client) SendInput(ev peer.InputEvent) error {
+	return c.input.SendText(string(mustJSON(ev)))
+}
+
+// SetViewport asks the peer to scale frames down to fit width x height and,
+// for zoom > 1, to show 1/zoom of the display around cx,cy (display
+// coordinates). Input coordinates then refer to the scaled frame.
+func (c *Client) SetViewport(width, height int, zoom float64, cx, cy int) error {
+	return c.SendInput(peer.InputEvent{Type: "viewport", Width: width, Height: height, Zoom: zoom, X: cx, Y: cy})
+}
+
+// Done is closed when the peer ends the session or the connection fails.
+func (c *Client) Done() <-chan struct{} { return c.done }
+
+func (c *Client) finish() { c.doneOnce.Do(func() { close(c.done) }) }
+
+// Close tears down the connection.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() { _ = c.pc.Close() })
+	c.finish()
+}
+
+// UDPExchange returns an Exchange that sends the offer to addr over
+// reliable UDP signaling, like the HTTP server does.
+func UDPExchange(sig *signaling.Conn, addr string, timeout time.Duration) Exchange {
+	return func(offer signaling.Offer) (signaling.Answer, error) {
+		var answer signaling.Answer
+		to, err := resolveUDP(addr)
+		if err != nil {
+			return answer, err
+		}
+		payload := base64.StdEncoding.EncodeToString(mustJSON(offer))
+		id, err := sig.Send(to, "OFFER", []byte(payload))
+		if err != nil {
+			return answer, err
+		}
+		msg, err := sig.ReceiveReply(timeout, id)
+		if err != nil {
+			return answer, fmt.Errorf("wait ANSWER: %w", err)
+		}
+		switch msg.Kind {
+		case "REJECT":
+			return answer, fmt.Errorf("peer rejected the offer: %s", msg.Payload)
+		case "UNPAIRED":
+			return answer, fmt.Errorf("peer not paired: %s", msg.Payload)
+		case "ERROR":
+			return answer, fmt.Errorf("peer could not answer: %s", msg.Payload)
+		}
+		raw, err := base64.StdEncoding.DecodeString(string(msg.Payload))
+		if err != nil {
+			return answer, fmt.Errorf("decode ANSWER: %w", err)
+		}
+		if err := json.Unmarshal(raw, &answer); err != nil {
+			return answer, fmt.Errorf("unmarshal ANSWER: %w", err)
+		}
+		return answer, nil
+	}
+}
+
+func mustJSON(v any) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return []byte("{}")
+	}
+	return b
+}
+
+func resolveUDP(addr string) (*net.UDPAddr, error) {
+	to, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", addr, err)
+	}
+	return to, nil
+}
@@ -3,179 +3,43 @@
 package main
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"image/jpeg"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"runtime"
 	"runtime/debug"
-	"strconv"
-	"strings"
 	"time"
 
+	"weblinuxgui/approval"
+	"weblinuxgui/audit"
+	"weblinuxgui/capture"
+	"weblinuxgui/config"
 	"weblinuxgui/input"
+	"weblinuxgui/logging"
+	"weblinuxgui/metrics"
+	"weblinuxgui/pairing"
+	"weblinuxgui/peer"
+	"weblinuxgui/recording"
+	"weblinuxgui/rtcconfig"
+	"weblinuxgui/signaling"
 
-	"github.com/kbinani/screenshot"
 	"github.com/pion/webrtc/v4"
 )
 
-// InputEvent mirrors the browser-sent event structure
-type InputEvent struct {
-	Type          string   `json:"type"`
-	Key           string   `json:"key"`
-	KeyCode       int      `json:"keyCode"`
-	Modifiers     []string `json:"modifiers"`
-	DeltaY        float64  `json:"deltaY"`
-	X             int      `json:"x"`
-	Y             int      `json:"y"`
-	Button        string   `json:"button"`
-	ClipboardText string   `json:"clipboardText"`
-}
-
-// Global display offset for multi-monitor setups
-var dispOffsetX, dispOffsetY int
-
-func handleInput(ev InputEvent) {
-	switch ev.Type {
-	case "mousemove":
-		// Adjust for display origin in multi-monitor setups
-		input.MoveMouse(ev.X+dispOffsetX, ev.Y+dispOffsetY)
-	case "mousedown":
-		// Ensure cursor is at target before any press logic
-		input.MoveMouse(ev.X+dispOffsetX, ev.Y+dispOffsetY)
-	case "mouseup":
-		// Ensure cursor is at target even if no prior mousemove arrived
-		input.MoveMouse(ev.X+dispOffsetX, ev.Y+dispOffsetY)
-		input.Click(mapButton(ev.Button))
-	case "contextmenu":
-		// Right click at target location
-		input.MoveMouse(ev.X+dispOffsetX, ev.Y+dispOffsetY)
-		input.Click(input.ButtonRight)
-	case "wheel":
-		// Scroll at target location
-		input.MoveMouse(ev.X+dispOffsetX, ev.Y+dispOffsetY)
-		input.Scroll(ev.DeltaY)
-	case "keydown":
-		if key := normalizeKey(ev.Key); key != "" {
-			input.KeyDown(key)
-		}
-	case "keyup":
-		if key := normalizeKey(ev.Key); key != "" {
-			input.KeyUp(key)
-		}
-	case "paste":
-		if ev.ClipboardText != "" {
-			input.TypeString(ev.ClipboardText)
-		}
-	}
-}
-
-func mapButton(b string) input.Button {
-	switch strings.ToLower(b) {
-	case "left", "l":
-		return input.ButtonLeft
-	case "right", "r":
-		return input.ButtonRight
-	case "center", "middle", "m":
-		return input.ButtonMiddle
-	default:
-		return input.ButtonLeft
-	}
-}
-
-func normalizeKey(k string) string {
-	k = strings.ToLower(k)
-	switch k {
-	case "enter":
-		return "enter"
-	case "shift":
-		return "shift"
-	case "control", "ctrl":
-		return "ctrl"
-	case "alt", "option":
-		return "alt"
-	case "meta", "command", "cmd":
-		return "cmd"
-	case "escape", "esc":
-		return "esc"
-	case " ", "space":
-		return "space"
-	case "tab":
-		return "tab"
-	case "backspace":
-		return "backspace"
-	case "delete":
-		return "delete"
-	case "arrowup":
-		return "up"
-	case "arrowdown":
-		return "down"
-	case "arrowleft":
-		return "left"
-	case "arrowright":
-		return "right"
-	default:
-		if len(k) == 1 {
-			return k
-		}
-		return ""
-	}
-}
-
-// captureAndEncode grabs the current display image and returns base64 JPEG and mouse coords.
-func captureAndEncode(quality, display int) (b64 string, mx, my int, ok bool) {
-	num := screenshot.NumActiveDisplays()
-	if num <= 0 {
-		return "", 0, 0, false
-	}
-	d := display
-	if d < 0 || d >= num {
-		d = 0
-	}
-	bounds := screenshot.GetDisplayBounds(d)
-	dispOffsetX, dispOffsetY = bounds.Min.X, bounds.Min.Y
-	img, err := screenshot.CaptureRect(bounds)
-	if err != nil {
-		return "", 0, 0, false
-	}
-
-	var buf bytes.Buffer
-	q := quality
-	if q <= 0 || q > 100 {
-		q = 80
-	}
-	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: q}); err != nil {
-		return "", 0, 0, false
-	}
-	b64 = base64.StdEncoding.EncodeToString(buf.Bytes())
-	x, y := input.GetMousePos()
-	return b64, x, y, true
-}
-
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
-}
-
-func runPeer(fps, quality, display int) error {
-	// Start periodic memory release based on env var CACHE_CLEAN_INTERVAL
+func runPeer(cfg *config.Peer) error {
+	// CACHE_CLEAN_INTERVAL periodically returns memory to the OS. The
+	// pipeline reuses its encode buffers, and its images with backends that
+	// capture into a buffer (x11shm, synthetic), but the default screenshot
+	// backend allocates a new image per frame.
 	stopMem := make(chan struct{})
 	startPeriodicMemoryRelease := func() {
-		intervalStr := os.Getenv("CACHE_CLEAN_INTERVAL")
-		if strings.TrimSpace(intervalStr) == "" {
-			intervalStr = "5m"
-		}
-		d, err := time.ParseDuration(intervalStr)
-		if err != nil || d <= 0 {
+		if cfg.CacheCleanInterval <= 0 {
 			return
 		}
-		ticker := time.NewTicker(d)
+		ticker := time.NewTicker(cfg.CacheCleanInterval)
 		go func() {
 			defer ticker.Stop()
 			for {
@@ -190,214 +54,218 @@ func runPeer(fps, quality, display int) error {
 		}()
 	}
 	startPeriodicMemoryRelease()
-	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{{URLs: []string{"stun:stun.l.google.com:19302"}}},
-	})
-	if err != nil {
-		return fmt.Errorf("new pc: %w", err)
-	}
-	defer pc.Close()
 	defer close(stopMem)
+	iceCfg := cfg.ICE
+	iceCfg.LoggerFactory = cfg.Log.Pion()
 
-	var framesDC *webrtc.DataChannel
-	framesReady := make(chan struct{})
-
-	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
-		label := dc.Label()
-		log.Println("data channel:", label)
-		switch label {
-		case "input":
-			dc.OnOpen(func() { log.Println("input data channel open") })
-			dc.OnMessage(func(msg webrtc.DataChannelMessage) {
-				if msg.IsString {
-					var ev InputEvent
-					if err := json.Unmarshal(msg.Data, &ev); err == nil {
-						// Process input event without extra logging
-						handleInput(ev)
-					}
-				}
-			})
-		case "frames":
-			framesDC = dc
-			dc.OnOpen(func() { close(framesReady) })
-		}
-	})
-
-	// UDP signaling: listen for OFFER and reply with ANSWER
-	getEnv := func(k, def string) string {
-		if v := os.Getenv(k); v != "" {
-			return v
-		}
-		return def
-	}
-	getEnvBool := func(k string, def bool) bool {
-		if v := os.Getenv(k); v != "" {
-			v = strings.ToLower(strings.TrimSpace(v))
-			return v == "1" || v == "true" || v == "yes"
+	// DTLS_CERT keeps the DTLS certificate so its fingerprint can be enrolled
+	// with the server (PEER_FINGERPRINT); "none" uses a new one per session
+	if path := cfg.DTLSCert; path != "none" {
+		cert, err := rtcconfig.LoadCertificate(path)
+		if err != nil {
+			return err
 		}
-		return def
-	}
-	udpPort := getEnv("UDP_PORT", "8080")
-	localhostOnly := getEnvBool("BIND_LOCALHOST_ONLY", false)
-	// LOCAL_ADDR can be either "ip:port" or just "ip"; if empty, fall back to 0.0.0.0:UDP_PORT (or 127.0.0.1 when localhost only)
-	bindAddr := getEnv("LOCAL_ADDR", "")
-	if bindAddr == "" {
-		if localhostOnly {
-			bindAddr = net.JoinHostPort("127.0.0.1", udpPort)
-		} else {
-			bindAddr = net.JoinHostPort("0.0.0.0", udpPort)
+		iceCfg.Certificates = []webrtc.Certificate{*cert}
+		fp, err := rtcconfig.Fingerprint(cert)
+		if err != nil {
+			return fmt.Errorf("dtls fingerprint: %w", err)
 		}
-	} else if !strings.Contains(bindAddr, ":") {
-		// If user provided only an IP, append UDP_PORT automatically
-		bindAddr = net.JoinHostPort(bindAddr, udpPort)
+		slog.Info("DTLS certificate", "path", path, "fingerprint", fp)
 	}
-	localAddr, err := net.ResolveUDPAddr("udp4", bindAddr)
+	// CAPTURE selects the capture backend: screenshot (default), x11shm or synthetic
+	capturer, err := capture.New(cfg.Capture)
 	if err != nil {
-		return fmt.Errorf("resolve local UDP: %w", err)
+		return fmt.Errorf("capture: %w", err)
 	}
-	conn, err := net.ListenUDP("udp4", localAddr)
-	if err != nil {
-		return fmt.Errorf("listen UDP: %w", err)
+	defer capturer.Close()
+	opts := peer.Options{
+		FPS:      cfg.FPS,
+		Quality:  cfg.Quality,
+		Display:  cfg.Display,
+		ICE:      iceCfg,
+		Capturer: capturer,
+		// RECONNECT_TIMEOUT is how long a failed session waits for the browser's ICE restart
+		ReconnectTimeout: cfg.ReconnectTimeout,
+		// ENCODERS frames are encoded in parallel (default: CPUs, at most 4);
+		// ENCODE_BANDS also splits each frame into bands encoded in parallel
+		Encoders: cfg.Encoders,
+		Bands:    cfg.EncodeBands,
 	}
-	defer conn.Close()
-	log.Println("Windows peer UDP listening on", bindAddr)
-
-	offerStr, from, err := waitForPrefix(conn, "OFFER:", 60*time.Second)
-	if err != nil {
-		return fmt.Errorf("wait OFFER: %w", err)
-	}
-	offerJSON, err := base64.StdEncoding.DecodeString(strings.TrimSpace(offerStr))
-	if err != nil {
-		return fmt.Errorf("decode offer b64: %w", err)
+	// CURSOR_RATE is how often the cursor position is sent between frames; 0 disables cursor streaming
+	if rate := cfg.CursorRate; rate > 0 {
+		opts.CursorRate = rate
+	} else {
+		opts.CursorRate = -1
 	}
-	var offer webrtc.SessionDescription
-	if err := json.Unmarshal(offerJSON, &offer); err != nil {
-		return fmt.Errorf("unmarshal offer: %w", err)
+	// CODEC picks the frame format: jpeg (default), png, qoi or auto (JPEG while
+	// the screen changes, one LOSSLESS frame after it has been still for SETTLE)
+	if opts.Codec, err = peer.ParseCodec(cfg.Codec); err != nil {
+		return fmt.Errorf("CODEC: %w", err)
 	}
-	if err := pc.SetRemoteDescription(offer); err != nil {
-		return fmt.Errorf("set remote: %w", err)
-	}
-
-	answer, err := pc.CreateAnswer(nil)
-	if err != nil {
-		return fmt.Errorf("create answer: %w", err)
+	opts.Lossless, opts.Settle = cfg.Lossless, cfg.Settle
+	// RECORD_DIR enables session recording (frames + input events) with rotation and retention
+	if recCfg := cfg.Recording; recCfg.Enabled() {
+		rec, err := recording.NewWriter(recCfg)
+		if err != nil {
+			return fmt.Errorf("recording: %w", err)
+		}
+		defer rec.Close()
+		opts.Recorder = rec
+		slog.Info("recording sessions", "dir", recCfg.Dir)
 	}
-	gatherComplete := webrtc.GatheringCompletePromise(pc)
-	if err := pc.SetLocalDescription(answer); err != nil {
-		return fmt.Errorf("set local: %w", err)
+	// AUDIT_DIR logs sessions, refusals and pastes (AUDIT_KEYS adds key presses) as JSON lines
+	if auditCfg := cfg.Audit; auditCfg.Enabled() {
+		auditLog, err := audit.New(auditCfg, "peer")
+		if err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+		defer auditLog.Close()
+		opts.Audit = auditLog
+		slog.Info("audit log", "dir", auditCfg.Dir)
 	}
-	<-gatherComplete
-	local := pc.LocalDescription()
-	ansJSON, _ := json.Marshal(local)
-	ansB64 := base64.StdEncoding.EncodeToString(ansJSON)
-	// Send ANSWER back to the sender via UDP
-	if _, err := conn.WriteToUDP([]byte("ANSWER:"+ansB64), from); err != nil {
-		return fmt.Errorf("send ANSWER: %w", err)
+	// METRICS_ADDR serves Prometheus metrics of the stream on /metrics
+	if addr := cfg.MetricsAddr; addr != "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("metrics: %w", err)
+		}
+		defer ln.Close()
+		opts.Metrics = metrics.NewPeer()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", opts.Metrics.Handler())
+		go func() {
+			if err := http.Serve(ln, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+				slog.Error("metrics", "err", err)
+			}
+		}()
+		slog.Info("metrics listening", "url", "http://"+ln.Addr().String()+"/metrics")
 	}
-	log.Println("ANSWER sent via UDP to", from.String())
-	log.Println("Waiting for data channels to open...")
-
-	select {
-	case <-framesReady:
-		log.Println("frames channel ready; starting stream")
-	case <-time.After(30 * time.Second):
-		return fmt.Errorf("frames channel not opened by browser")
+	// INPUT_DRY_RUN prints the input the browser sends instead of injecting it
+	if cfg.InputDryRun {
+		slog.Info("input dry run: events are printed, not injected")
+		opts.Input = input.NewPrinter(os.Stdout)
 	}
 
-	// Chunked transfer to respect SCTP/DC message size limits
-	// Keep chunks small (<16KB) to be safe across browsers/OSes
-	const chunkSize = 12 * 1024
-	interval := time.Second / time.Duration(max(fps, 1))
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-	frameID := 0
-	for range ticker.C {
-		if framesDC == nil {
-			continue
-		}
-		b64, mx, my, ok := captureAndEncode(quality, display)
-		if !ok || len(b64) == 0 {
-			continue
+	// Signaling: SIGNALING=manual reads the offer from stdin/OFFER_FILE and prints the answer.
+	// Manual sessions cannot be restarted automatically; the peer exits when the session ends.
+	if cfg.Signaling == "manual" {
+		// The browser needs longer to open channels when a human copies the answer back
+		opts.OpenTimeout = 5 * time.Minute
+		p := peer.New(opts)
+		stopStream := make(chan struct{})
+		defer close(stopStream)
+		go p.Stream(stopStream)
+		offerJSON, err := readManualOffer(cfg.OfferFile)
+		if err != nil {
+			return err
 		}
-		nChunks := (len(b64) + chunkSize - 1) / chunkSize
-		// Send metadata first
-		meta := struct {
-			Type   string `json:"type"`
-			ID     int    `json:"id"`
-			Chunks int    `json:"chunks"`
-			MouseX int    `json:"mouseX"`
-			MouseY int    `json:"mouseY"`
-		}{Type: "frameMeta", ID: frameID, Chunks: nChunks, MouseX: mx, MouseY: my}
-		if err := framesDC.SendText(mustJSON(meta)); err != nil {
-			// If we fail to send meta, skip this frame
-			continue
+		s, ansJSON, err := p.HandleOffer(offerJSON)
+		if err != nil {
+			return err
 		}
-		// Send chunks
-		for i := 0; i < nChunks; i++ {
-			start := i * chunkSize
-			end := start + chunkSize
-			if end > len(b64) {
-				end = len(b64)
-			}
-			chunk := struct {
-				Type  string `json:"type"`
-				ID    int    `json:"id"`
-				Index int    `json:"index"`
-				Data  string `json:"data"`
-			}{Type: "frameChunk", ID: frameID, Index: i, Data: b64[start:end]}
-			// Best-effort; drop frame if a chunk fails, next frame will arrive soon
-			_ = framesDC.SendText(mustJSON(chunk))
+		if err := printManualAnswer(ansJSON, cfg.AnswerFile, cfg.QRTerminal); err != nil {
+			s.Close()
+			return err
 		}
-		frameID++
-		if frameID == int(^uint(0)>>1) { // avoid overflow; reset occasionally
-			frameID = 0
+		<-s.Done()
+		return nil
+	}
+
+	// APPROVAL asks the local user before a browser gets the desktop: off (default),
+	// desktop (message box) or console; unanswered prompts are rejected after APPROVAL_TIMEOUT.
+	// Manual signaling needs no prompt, the local user pastes the offer.
+	if opts.Approver, err = approval.New(cfg.Approval, os.Stdin, os.Stdout); err != nil {
+		return fmt.Errorf("APPROVAL: %w", err)
+	}
+	opts.ApprovalTimeout = cfg.ApprovalTimeout
+
+	// PAIRING admits browsers with the one-time code printed here, or the device token
+	// they were given when they paired
+	if pairCfg := cfg.Pairing; pairCfg.Enable {
+		pairer, err := pairing.New(pairCfg, func(code string, expires time.Time) {
+			fmt.Printf("Pairing code: %s (valid until %s)\n", code, expires.Format(time.TimeOnly))
+		})
+		if err != nil {
+			return fmt.Errorf("pairing: %w", err)
 		}
+		defer pairer.Close()
+		opts.Pairing = pairer
+		slog.Info("pairing required", "devices", len(pairer.Devices()), "store", pairCfg.Store)
 	}
-	return nil
+
+	// UDP signaling: listen for OFFERs and reply with ANSWERs
+	// LOCAL_ADDR can be either "ip:port" or just "ip"; if empty, fall back to 0.0.0.0:UDP_PORT (or 127.0.0.1 when localhost only)
+	bindAddr := cfg.UDP.BindAddr()
+	localAddr, err := net.ResolveUDPAddr("udp4", bindAddr)
+	if err != nil {
+		return fmt.Errorf("resolve local UDP: %w", err)
+	}
+	conn, err := net.ListenUDP("udp4", localAddr)
+	if err != nil {
+		return fmt.Errorf("listen UDP: %w", err)
+	}
+	sig := signaling.NewConn(conn)
+	defer sig.Close()
+	slog.Info("Windows peer UDP listening", "addr", bindAddr)
+
+	p := peer.New(opts)
+	stopStream := make(chan struct{})
+	defer close(stopStream)
+	go p.Stream(stopStream)
+	return p.ServeUDP(sig)
 }
 
-// waitForPrefix reads UDP packets until one starting with the given prefix arrives, or timeout.
-func waitForPrefix(conn *net.UDPConn, prefix string, timeout time.Duration) (string, *net.UDPAddr, error) {
-	_ = conn.SetReadDeadline(time.Now().Add(timeout))
-	buf := make([]byte, 64*1024)
-	for {
-		n, addr, err := conn.ReadFromUDP(buf)
+// readManualOffer reads a pasted offer from path (OFFER_FILE), or from stdin when empty.
+func readManualOffer(path string) ([]byte, error) {
+	if path != "" {
+		f, err := os.Open(path)
 		if err != nil {
-			return "", nil, err
-		}
-		msg := string(buf[:n])
-		if strings.HasPrefix(msg, prefix) {
-			return msg[len(prefix):], addr, nil
+			return nil, fmt.Errorf("open offer file: %w", err)
 		}
-		// ignore other packets
+		defer f.Close()
+		return signaling.ReadPasted(f)
 	}
+	fmt.Println("Paste Offer (base64) from browser. End with an empty line or type END on a new line:")
+	return signaling.ReadPasted(os.Stdin)
 }
 
-// envInt reads an environment variable or returns a default if unset/invalid.
-func envInt(key string, def int) int {
-	if v := os.Getenv(key); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			return n
+// printManualAnswer prints the answer for copy/paste, optionally as a terminal
+// QR code (QR_TERMINAL), and writes it to path (ANSWER_FILE) when set.
+func printManualAnswer(ansJSON []byte, path string, showQR bool) error {
+	ansB64 := signaling.EncodePasted(ansJSON)
+	if path != "" {
+		if err := os.WriteFile(path, []byte(ansB64+"\n"), 0o600); err != nil {
+			return fmt.Errorf("write answer file: %w", err)
+		}
+	}
+	fmt.Println("Answer (base64) — copy this back into the browser:")
+	fmt.Println(ansB64)
+	if showQR {
+		if qr, err := signaling.TerminalQR(ansB64); err != nil {
+			slog.Warn("answer too large for a QR code", "err", err)
+		} else {
+			fmt.Println(qr)
 		}
 	}
-	return def
+	return nil
 }
 
 // Windows entry point so this file can be run directly: `go run ./windows.go`
 func main() {
-	fps := envInt("FPS", 10)
-	quality := envInt("QUALITY", 80)
-	display := envInt("DISPLAY_INDEX", 0)
-	if err := runPeer(fps, quality, display); err != nil {
-		log.Fatal(err)
-	}
-}
-
-// mustJSON is a tiny helper to marshal or return an empty JSON object on error.
-func mustJSON(v any) string {
-	b, err := json.Marshal(v)
+	// Defaults < CONFIG_FILE (or -config) < environment < flags
+	cfg, err := config.LoadPeer(os.Args[1:])
 	if err != nil {
-		return "{}"
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		_ = cfg.Print(os.Stdout)
+		return
+	}
+	// LOG_LEVEL, LOG_FORMAT and PION_LOG_LEVEL; pion's ICE/DTLS/SCTP logs go to the same log
+	logging.Setup(cfg.Log, os.Stderr)
+	if err := runPeer(cfg); err != nil {
+		slog.Error("peer", "err", err)
+		os.Exit(1)
 	}
-	return string(b)
 }